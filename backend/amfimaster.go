@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// refreshSchemeMaster replaces the stored AMFI scheme master with a fresh
+// download from AMFI.
+func refreshSchemeMaster(ctx context.Context, q dbQuerier) (int, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	schemes, err := (&AMFIProvider{}).SchemeMaster(reqCtx)
+	if err != nil {
+		return 0, err
+	}
+
+	codes := make([]int, len(schemes))
+	names := make([]string, len(schemes))
+	growth := make([]string, len(schemes))
+	reinvest := make([]string, len(schemes))
+	for i, s := range schemes {
+		codes[i], names[i], growth[i], reinvest[i] = s.Code, s.Name, s.ISINGrowth, s.ISINReinvest
+	}
+	_, err = q.Exec(ctx, `
+		INSERT INTO amfi_schemes (scheme_code, name, isin_growth, isin_reinvest, updated_at)
+		SELECT code, name, NULLIF(g, ''), NULLIF(r, ''), NOW()
+		FROM unnest($1::int[], $2::text[], $3::text[], $4::text[]) AS s(code, name, g, r)
+		ON CONFLICT (scheme_code) DO UPDATE SET name=EXCLUDED.name, isin_growth=EXCLUDED.isin_growth,
+			isin_reinvest=EXCLUDED.isin_reinvest, updated_at=NOW()`,
+		codes, names, growth, reinvest)
+	return len(schemes), err
+}
+
+// schemeSymbolsByISIN maps ISINs to "AMFI:<code>" symbols, downloading the
+// scheme master first if it has never been loaded. Unknown ISINs are absent.
+func schemeSymbolsByISIN(ctx context.Context, q dbQuerier, isins []string) (map[string]string, error) {
+	symbols := map[string]string{}
+	if len(isins) == 0 {
+		return symbols, nil
+	}
+	var loaded bool
+	if err := q.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM amfi_schemes)").Scan(&loaded); err != nil {
+		return nil, err
+	}
+	if !loaded {
+		if _, err := refreshSchemeMaster(ctx, q); err != nil {
+			return nil, fmt.Errorf("amfi scheme master unavailable: %w", err)
+		}
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT scheme_code, COALESCE(isin_growth, ''), COALESCE(isin_reinvest, '') FROM amfi_schemes
+		WHERE isin_growth = ANY($1) OR isin_reinvest = ANY($1)`, isins)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code int
+		var growth, reinvest string
+		if err := rows.Scan(&code, &growth, &reinvest); err != nil {
+			return nil, err
+		}
+		for _, isin := range []string{growth, reinvest} {
+			if isin != "" {
+				symbols[isin] = fmt.Sprintf("AMFI:%d", code)
+			}
+		}
+	}
+	return symbols, rows.Err()
+}
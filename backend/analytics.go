@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// cashFlow is money moving between the investor and the portfolio, from the
+// investor's side: negative when contributed, positive when received.
+type cashFlow struct {
+	Date   time.Time
+	Amount float64
+}
+
+// valuationPoint is a portfolio's end-of-day value and the net external
+// contribution made that day (positive = money in).
+type valuationPoint struct {
+	Date  time.Time
+	Value float64
+	Flow  float64
+}
+
+// ReturnStats are the returns of one asset, asset type or the whole portfolio
+// over a range. XIRR is money-weighted and annualised; TWR strips out the
+// effect of contribution timing and is cumulative over the range.
+type ReturnStats struct {
+	Name             string   `json:"name"`
+	Currency         string   `json:"currency"`
+	StartValue       float64  `json:"startValue"`
+	EndValue         float64  `json:"endValue"`
+	NetContributions float64  `json:"netContributions"`
+	Gain             float64  `json:"gain"`
+	XIRR             *float64 `json:"xirr"`
+	TWR              *float64 `json:"twr"`
+	TWRAnnualized    *float64 `json:"twrAnnualized,omitempty"`
+}
+
+// txnContribution is the external money a transaction brings into the
+// position, in the transaction's currency. Dividends and fees carry their
+// cash amount as Quantity × Price, or just Price when no quantity is given.
+func txnContribution(t Transaction) float64 {
+	gross := t.Quantity * t.Price
+	switch t.Type {
+	case TxnBuy, TxnTransferIn:
+		return gross + t.Fees
+	case TxnSell:
+		return -(gross - t.Fees)
+	case TxnTransferOut:
+		return -gross
+	case TxnDividend:
+		return -(cashAmount(t) - t.Fees)
+	case TxnFee:
+		return cashAmount(t) + t.Fees
+	}
+	return 0
+}
+
+func cashAmount(t Transaction) float64 {
+	if t.Quantity > 0 {
+		return t.Quantity * t.Price
+	}
+	return t.Price
+}
+
+// xirr solves for the annual rate at which the flows' net present value is
+// zero, using Newton's method with a bisection fallback.
+func xirr(flows []cashFlow) (float64, error) {
+	if len(flows) < 2 {
+		return 0, fmt.Errorf("need at least two cash flows")
+	}
+	hasIn, hasOut := false, false
+	for _, f := range flows {
+		hasIn = hasIn || f.Amount < 0
+		hasOut = hasOut || f.Amount > 0
+	}
+	if !hasIn || !hasOut {
+		return 0, fmt.Errorf("cash flows must include both contributions and proceeds")
+	}
+
+	t0 := flows[0].Date
+	years := make([]float64, len(flows))
+	for i, f := range flows {
+		years[i] = f.Date.Sub(t0).Hours() / 24 / 365
+	}
+	npv := func(r float64) float64 {
+		sum := 0.0
+		for i, f := range flows {
+			sum += f.Amount / math.Pow(1+r, years[i])
+		}
+		return sum
+	}
+
+	r := 0.1
+	for i := 0; i < 50; i++ {
+		f, d := 0.0, 0.0
+		for j, fl := range flows {
+			f += fl.Amount / math.Pow(1+r, years[j])
+			d -= years[j] * fl.Amount / math.Pow(1+r, years[j]+1)
+		}
+		if d == 0 {
+			break
+		}
+		next := r - f/d
+		if math.IsNaN(next) || next <= -1 {
+			break
+		}
+		if math.Abs(next-r) < 1e-9 {
+			return next, nil
+		}
+		r = next
+	}
+
+	lo, hi := -0.9999, 10.0
+	for npv(hi) > 0 && hi < 1e6 {
+		hi *= 10
+	}
+	if npv(lo)*npv(hi) > 0 {
+		return 0, fmt.Errorf("XIRR did not converge")
+	}
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		if npv(lo)*npv(mid) <= 0 {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return (lo + hi) / 2, nil
+}
+
+// valuationSeries values the transactions' positions at the end of every day
+// that has a price or a trade between start and end, plus the opening value
+// just before start. prices holds chronological observations per symbol and
+// rate converts a currency into the reporting currency on a date.
+func valuationSeries(txns []Transaction, prices map[string][]PricePoint, currencyOf map[string]string,
+	rate func(currency string, date time.Time) float64, start, end time.Time) (float64, []valuationPoint) {
+
+	days := map[time.Time]bool{start: true, end: true}
+	symbols := map[string]bool{}
+	for _, t := range txns {
+		symbols[t.Symbol] = true
+	}
+	for s := range symbols {
+		for _, p := range prices[s] {
+			if !p.Time.Before(start) && !p.Time.After(end) {
+				days[p.Time] = true
+			}
+		}
+	}
+	for _, t := range txns {
+		if d, err := time.Parse(dateLayout, t.Date); err == nil && !d.Before(start) && !d.After(end) {
+			days[d] = true
+		}
+	}
+	grid := make([]time.Time, 0, len(days))
+	for d := range days {
+		grid = append(grid, d)
+	}
+	sort.Slice(grid, func(i, j int) bool { return grid[i].Before(grid[j]) })
+
+	qty := map[string]float64{}
+	apply := func(t Transaction) {
+		switch t.Type {
+		case TxnBuy, TxnTransferIn:
+			qty[t.Symbol] += t.Quantity
+		case TxnSell, TxnTransferOut:
+			qty[t.Symbol] -= t.Quantity
+		case TxnSplit:
+			qty[t.Symbol] *= t.Quantity
+		}
+	}
+	value := func(d time.Time) float64 {
+		v := 0.0
+		for s, q := range qty {
+			if q <= 1e-9 {
+				continue
+			}
+			if p, ok := closeOn(prices[s], d); ok {
+				v += q * p * rate(currencyOf[s], d)
+			}
+		}
+		return v
+	}
+
+	next := 0
+	for next < len(txns) && txns[next].Date < start.Format(dateLayout) {
+		apply(txns[next])
+		next++
+	}
+	// Opening value uses the prior close, before any trade on the start date
+	opening := value(start.AddDate(0, 0, -1))
+
+	points := make([]valuationPoint, 0, len(grid))
+	for _, d := range grid {
+		day := d.Format(dateLayout)
+		flow := 0.0
+		for next < len(txns) && txns[next].Date <= day {
+			t := txns[next]
+			apply(t)
+			flow += txnContribution(t) * rate(currencyOf[t.Symbol], d)
+			next++
+		}
+		points = append(points, valuationPoint{Date: d, Value: value(d), Flow: flow})
+	}
+	return opening, points
+}
+
+// twrIndex chains flow-adjusted daily returns into a growth index starting at
+// 1, one point per valuation point. Flows are taken at the end of the day; a
+// period starting from zero (inception, or after a full exit) has no return
+// of its own. measured is false when no period had a prior value.
+func twrIndex(opening float64, points []valuationPoint) ([]PricePoint, bool) {
+	index := make([]PricePoint, 0, len(points))
+	growth, prev, measured := 1.0, opening, false
+	for _, p := range points {
+		if prev > 0 {
+			growth *= (p.Value - p.Flow) / prev
+			measured = true
+		}
+		prev = p.Value
+		index = append(index, PricePoint{Time: p.Date, Close: growth})
+	}
+	return index, measured
+}
+
+// returnStats derives XIRR and TWR from an opening value and a valuation series.
+func returnStats(name, currency string, opening float64, points []valuationPoint, start time.Time) ReturnStats {
+	stats := ReturnStats{Name: name, Currency: currency, StartValue: opening}
+	if len(points) == 0 {
+		return stats
+	}
+
+	var flows []cashFlow
+	if opening > 0 {
+		flows = append(flows, cashFlow{Date: start, Amount: -opening})
+	}
+	for _, p := range points {
+		stats.NetContributions += p.Flow
+		if p.Flow != 0 {
+			flows = append(flows, cashFlow{Date: p.Date, Amount: -p.Flow})
+		}
+	}
+	index, measured := twrIndex(opening, points)
+	growth := index[len(index)-1].Close
+	last := points[len(points)-1]
+	stats.EndValue = last.Value
+	stats.Gain = stats.EndValue - stats.StartValue - stats.NetContributions
+	if last.Value > 0 {
+		flows = append(flows, cashFlow{Date: last.Date, Amount: last.Value})
+	}
+
+	if r, err := xirr(flows); err == nil {
+		stats.XIRR = &r
+	}
+	if measured {
+		twr := growth - 1
+		stats.TWR = &twr
+		if years := last.Date.Sub(start).Hours() / 24 / 365; years >= 1 {
+			ann := math.Pow(growth, 1/years) - 1
+			stats.TWRAnnualized = &ann
+		}
+	}
+	return stats
+}
+
+// returnsRangeStart maps a returns range (1M, 3M, 6M, YTD, 1Y, 3Y, 5Y, ALL)
+// to its first day; ranges reaching past inception start at inception.
+func returnsRangeStart(rng string, today, inception time.Time) (time.Time, error) {
+	var start time.Time
+	switch strings.ToUpper(rng) {
+	case "1M":
+		start = today.AddDate(0, -1, 0)
+	case "3M":
+		start = today.AddDate(0, -3, 0)
+	case "6M":
+		start = today.AddDate(0, -6, 0)
+	case "YTD":
+		start = time.Date(today.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	case "1Y":
+		start = today.AddDate(-1, 0, 0)
+	case "3Y":
+		start = today.AddDate(-3, 0, 0)
+	case "5Y":
+		start = today.AddDate(-5, 0, 0)
+	case "ALL", "MAX", "":
+		start = inception
+	default:
+		return time.Time{}, fmt.Errorf("range must be one of 1M, 3M, 6M, YTD, 1Y, 3Y, 5Y, ALL")
+	}
+	if start.Before(inception) {
+		start = inception
+	}
+	return start, nil
+}
+
+// portfolioInputs is everything needed to value a user's ledger over time.
+type portfolioInputs struct {
+	txns       []Transaction
+	prices     map[string][]PricePoint
+	currencyOf map[string]string
+	typeOf     map[string]string
+}
+
+// loadPortfolioInputs loads the ledger, stored closes (topping up missing
+// history from upstream when fetch is set) and today's prices for every
+// symbol the user traded.
+func loadPortfolioInputs(ctx context.Context, dbPool *pgxpool.Pool, userID int, fetch bool) (portfolioInputs, error) {
+	in := portfolioInputs{prices: map[string][]PricePoint{}, currencyOf: map[string]string{}, typeOf: map[string]string{}}
+	txns, err := loadTransactions(ctx, dbPool, userID, "")
+	if err != nil {
+		return in, err
+	}
+	in.txns = txns
+
+	rows, err := dbPool.Query(ctx, "SELECT name, COALESCE(asset_type, ''), currency, current_price FROM assets WHERE user_id=$1", userID)
+	if err != nil {
+		return in, err
+	}
+	current := map[string]float64{}
+	for rows.Next() {
+		var name, assetType, currency string
+		var price float64
+		if err := rows.Scan(&name, &assetType, &currency, &price); err != nil {
+			rows.Close()
+			return in, err
+		}
+		in.typeOf[name], in.currencyOf[name], current[name] = assetType, normalizeCurrency(currency), price
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return in, err
+	}
+
+	firstTrade := map[string]time.Time{}
+	tradePrices := map[string][]PricePoint{}
+	for _, t := range txns {
+		d, _ := time.Parse(dateLayout, t.Date)
+		if _, ok := firstTrade[t.Symbol]; !ok {
+			firstTrade[t.Symbol] = d
+		}
+		if in.currencyOf[t.Symbol] == "" {
+			in.currencyOf[t.Symbol] = normalizeCurrency(t.Currency)
+		}
+		if in.typeOf[t.Symbol] == "" {
+			in.typeOf[t.Symbol] = "Other"
+		}
+		if t.Price > 0 && (t.Type == TxnBuy || t.Type == TxnSell || t.Type == TxnTransferIn || t.Type == TxnTransferOut) {
+			tradePrices[t.Symbol] = append(tradePrices[t.Symbol], PricePoint{Time: d, Close: t.Price})
+		}
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for symbol, first := range firstTrade {
+		if fetch {
+			if err := ensurePriceHistory(ctx, dbPool, symbol, first.AddDate(0, 0, -7)); err != nil {
+				log.Printf("Returns: history for %s unavailable: %v", symbol, err)
+			}
+		}
+		series, err := loadPriceHistory(ctx, dbPool, symbol, first.AddDate(0, 0, -8))
+		if err != nil {
+			return in, err
+		}
+		// Stored closes are split-adjusted; value them against as-traded quantities
+		var symbolTxns []Transaction
+		for _, t := range txns {
+			if t.Symbol == symbol {
+				symbolTxns = append(symbolTxns, t)
+			}
+		}
+		closes := unadjustCloses(series.Points, symbolTxns)
+		in.prices[symbol] = mergeObservations(closes, tradePrices[symbol], current[symbol], today)
+	}
+	return in, nil
+}
+
+// mergeObservations combines stored closes with trade prices (used only on
+// days without a close, so gaps in history still value the position) and
+// today's price.
+func mergeObservations(closes, trades []PricePoint, current float64, today time.Time) []PricePoint {
+	byDay := map[time.Time]float64{}
+	for _, p := range trades {
+		byDay[p.Time] = p.Close
+	}
+	for _, p := range closes {
+		byDay[p.Time] = p.Close
+	}
+	if current > 0 {
+		byDay[today] = current
+	}
+	out := make([]PricePoint, 0, len(byDay))
+	for d, c := range byDay {
+		out = append(out, PricePoint{Time: d, Close: c})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out
+}
+
+// analyticsRequest is the common input of the analytics endpoints: the
+// user's ledger and prices, FX into the reporting currency and the range.
+type analyticsRequest struct {
+	settings UserSettings
+	base     string
+	rng      string
+	in       portfolioInputs
+	fx       *fxTable
+	start    time.Time
+	today    time.Time
+}
+
+// loadAnalyticsRequest reads ?range= and ?currency= (defaulting to the
+// user's base currency) and loads the portfolio. On failure it has already
+// written the error response.
+func loadAnalyticsRequest(c *gin.Context, dbPool *pgxpool.Pool, defaultRange string) (*analyticsRequest, bool) {
+	ctx := context.Background()
+	userID := currentUserID(c)
+
+	settings, err := loadUserSettings(ctx, dbPool, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+		return nil, false
+	}
+	req := &analyticsRequest{settings: settings, base: settings.BaseCurrency, rng: strings.ToUpper(c.DefaultQuery("range", defaultRange))}
+	if override := strings.ToUpper(c.Query("currency")); override != "" {
+		if !validCurrencyCode(override) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a three-letter currency code"})
+			return nil, false
+		}
+		req.base = override
+	}
+
+	req.in, err = loadPortfolioInputs(ctx, dbPool, userID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load portfolio"})
+		return nil, false
+	}
+	req.today = time.Now().UTC().Truncate(24 * time.Hour)
+	if len(req.in.txns) == 0 {
+		return req, true
+	}
+
+	inception, _ := time.Parse(dateLayout, req.in.txns[0].Date)
+	req.start, err = returnsRangeStart(req.rng, req.today, inception)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	currencies := []string{}
+	for _, cur := range req.in.currencyOf {
+		currencies = append(currencies, cur)
+	}
+	req.fx, err = loadFXTable(ctx, dbPool, currencies, req.base)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load FX rates"})
+		return nil, false
+	}
+	return req, true
+}
+
+// --- ROUTES ---
+
+func registerAnalyticsRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/analytics/returns?range=1Y&currency= - XIRR and TWR per asset, asset type and portfolio
+	api.GET("/analytics/returns", func(c *gin.Context) {
+		req, ok := loadAnalyticsRequest(c, dbPool, "ALL")
+		if !ok {
+			return
+		}
+		if len(req.in.txns) == 0 {
+			c.JSON(http.StatusOK, gin.H{"baseCurrency": req.base, "portfolio": nil, "byType": []ReturnStats{}, "assets": []ReturnStats{}})
+			return
+		}
+		in, fx, start, today, base := req.in, req.fx, req.start, req.today, req.base
+		native := func(string, time.Time) float64 { return 1 }
+
+		bySymbol := map[string][]Transaction{}
+		byType := map[string][]Transaction{}
+		for _, t := range in.txns {
+			bySymbol[t.Symbol] = append(bySymbol[t.Symbol], t)
+			byType[in.typeOf[t.Symbol]] = append(byType[in.typeOf[t.Symbol]], t)
+		}
+
+		assets := []ReturnStats{}
+		for symbol, txns := range bySymbol {
+			opening, points := valuationSeries(txns, in.prices, in.currencyOf, native, start, today)
+			stats := returnStats(symbol, in.currencyOf[symbol], opening, points, start)
+			if stats.StartValue == 0 && stats.EndValue == 0 && stats.NetContributions == 0 {
+				continue // No position and no activity in the range
+			}
+			assets = append(assets, stats)
+		}
+		sort.Slice(assets, func(i, j int) bool { return assets[i].Name < assets[j].Name })
+
+		types := []ReturnStats{}
+		for assetType, txns := range byType {
+			opening, points := valuationSeries(txns, in.prices, in.currencyOf, fx.rate, start, today)
+			types = append(types, returnStats(assetType, base, opening, points, start))
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+
+		opening, points := valuationSeries(in.txns, in.prices, in.currencyOf, fx.rate, start, today)
+		portfolio := returnStats("Portfolio", base, opening, points, start)
+
+		c.JSON(http.StatusOK, gin.H{
+			"baseCurrency": base,
+			"range":        req.rng,
+			"from":         start.Format(dateLayout),
+			"to":           today.Format(dateLayout),
+			"portfolio":    portfolio,
+			"byType":       types,
+			"assets":       assets,
+			"missingRates": fx.missing,
+		})
+	})
+}
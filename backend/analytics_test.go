@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestXIRR(t *testing.T) {
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	days := func(n int) time.Time { return t0.AddDate(0, 0, n) }
+
+	tests := []struct {
+		name    string
+		flows   []cashFlow
+		want    float64
+		wantErr bool
+	}{
+		{
+			name:  "ten percent over a year",
+			flows: []cashFlow{{t0, -1000}, {days(365), 1100}},
+			want:  0.10,
+		},
+		{
+			name:  "ten percent compounded over two years",
+			flows: []cashFlow{{t0, -1000}, {days(730), 1210}},
+			want:  0.10,
+		},
+		{
+			name:  "loss",
+			flows: []cashFlow{{t0, -1000}, {days(365), 900}},
+			want:  -0.10,
+		},
+		{
+			name:  "two contributions",
+			flows: []cashFlow{{t0, -1000}, {days(365), -1000}, {days(730), 2310}},
+			want:  0.10,
+		},
+		{
+			name:  "flat",
+			flows: []cashFlow{{t0, -500}, {days(200), 500}},
+			want:  0,
+		},
+		{
+			name:    "single flow",
+			flows:   []cashFlow{{t0, -1000}},
+			wantErr: true,
+		},
+		{
+			name:    "contributions only",
+			flows:   []cashFlow{{t0, -1000}, {days(365), -100}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := xirr(tt.flows)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("xirr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("xirr() = %.8f, want %.8f", got, tt.want)
+			}
+		})
+	}
+}
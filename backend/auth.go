@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errExpiredToken = errors.New("token expired")
+	errReusedToken  = errors.New("refresh token reuse detected")
+)
+
+// authSecret signs access tokens. It is loaded from AUTH_SECRET in main().
+var authSecret []byte
+
+type accessClaims struct {
+	UserID    int   `json:"uid"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// signAccessToken produces "<payload>.<signature>" where both halves are
+// base64url encoded and the signature is HMAC-SHA256 over the payload.
+func signAccessToken(userID int) (string, error) {
+	payload, err := json.Marshal(accessClaims{UserID: userID, ExpiresAt: time.Now().Add(accessTokenTTL).Unix()})
+	if err != nil {
+		return "", err
+	}
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + base64.RawURLEncoding.EncodeToString(signPayload(body)), nil
+}
+
+func parseAccessToken(token string) (int, error) {
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, errInvalidToken
+	}
+	gotSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotSig, signPayload(body)) {
+		return 0, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	var claims accessClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.UserID == 0 {
+		return 0, errInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return 0, errExpiredToken
+	}
+	return claims.UserID, nil
+}
+
+func signPayload(body string) []byte {
+	mac := hmac.New(sha256.New, authSecret)
+	mac.Write([]byte(body))
+	return mac.Sum(nil)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashRefreshToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken stores a new refresh token in the given family. Only the
+// SHA-256 of the token is persisted; the raw value is returned to the client once.
+func issueRefreshToken(ctx context.Context, q dbQuerier, userID int, familyID string) (string, error) {
+	raw, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(ctx,
+		"INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at) VALUES ($1, $2, $3, $4)",
+		userID, hashRefreshToken(raw), familyID, time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// issueSession creates a fresh access token and starts a new refresh token family.
+func issueSession(dbPool *pgxpool.Pool, userID int) (gin.H, error) {
+	access, err := signAccessToken(userID)
+	if err != nil {
+		return nil, err
+	}
+	familyID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := issueRefreshToken(context.Background(), dbPool, userID, familyID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"accessToken":  access,
+		"refreshToken": refresh,
+		"expiresIn":    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// rotateRefreshToken exchanges a refresh token for a new access/refresh pair.
+// The presented token is revoked; presenting an already-revoked token revokes
+// its whole family, since that means the token was stolen or replayed.
+func rotateRefreshToken(dbPool *pgxpool.Pool, raw string) (int, gin.H, error) {
+	ctx := context.Background()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id, userID int
+	var familyID string
+	var expiresAt time.Time
+	var revokedAt *time.Time
+	err = tx.QueryRow(ctx,
+		"SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash=$1 FOR UPDATE",
+		hashRefreshToken(raw)).Scan(&id, &userID, &familyID, &expiresAt, &revokedAt)
+	if err == pgx.ErrNoRows {
+		return 0, nil, errInvalidToken
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if revokedAt != nil {
+		// Commit the family revocation even though the request itself fails
+		if _, err := tx.Exec(ctx, "UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=$1 AND revoked_at IS NULL", familyID); err != nil {
+			return 0, nil, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, errReusedToken
+	}
+	if time.Now().After(expiresAt) {
+		return 0, nil, errExpiredToken
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE refresh_tokens SET revoked_at=NOW() WHERE id=$1", id); err != nil {
+		return 0, nil, err
+	}
+	refresh, err := issueRefreshToken(ctx, tx, userID, familyID)
+	if err != nil {
+		return 0, nil, err
+	}
+	access, err := signAccessToken(userID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, err
+	}
+
+	return userID, gin.H{
+		"accessToken":  access,
+		"refreshToken": refresh,
+		"expiresIn":    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// revokeRefreshFamily logs a device out by revoking every token descended
+// from the same login.
+func revokeRefreshFamily(dbPool *pgxpool.Pool, raw string) error {
+	_, err := dbPool.Exec(context.Background(),
+		`UPDATE refresh_tokens SET revoked_at=NOW()
+		 WHERE revoked_at IS NULL AND family_id=(SELECT family_id FROM refresh_tokens WHERE token_hash=$1)`,
+		hashRefreshToken(raw))
+	return err
+}
+
+// authRequired validates the Bearer access token and stores the caller's
+// user ID in the context for handlers to read with currentUserID.
+func authRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized. Please log in."})
+			return
+		}
+
+		userID, err := parseAccessToken(token)
+		if err == errExpiredToken {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session expired", "code": "token_expired"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
+			return
+		}
+
+		c.Set("userID", userID)
+		c.Next()
+	}
+}
+
+// adminRequired allows only users flagged is_admin. It must run after authRequired.
+func adminRequired(dbPool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var isAdmin bool
+		err := dbPool.QueryRow(context.Background(), "SELECT is_admin FROM users WHERE id=$1", currentUserID(c)).Scan(&isAdmin)
+		if err != nil || !isAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentUserID returns the authenticated user set by authRequired.
+func currentUserID(c *gin.Context) int {
+	return c.GetInt("userID")
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Backup archives identify themselves by format and version. Bump the
+// version when a field changes meaning; restores accept any version up to
+// the current one.
+const (
+	backupFormat   = "portfolio-backup"
+	backupVersion  = 1
+	maxBackupBytes = 50 << 20
+)
+
+// Conflict modes for restoring into an account that already has data
+const (
+	RestoreSkip      = "skip"      // keep what the account has; add only what is missing
+	RestoreOverwrite = "overwrite" // the archive wins for settings, targets, mappings and holding details
+	RestoreReplace   = "replace"   // delete the account's data first
+)
+
+// Backup is everything a user owns, in deployment-independent form.
+// Transaction IDs are only meaningful within the archive, where lot
+// selections, corporate action logs and import fingerprints refer to them.
+// Quotes, price history and snapshots are rebuilt after a restore.
+type Backup struct {
+	Format             string               `json:"format"`
+	Version            int                  `json:"version"`
+	ExportedAt         time.Time            `json:"exportedAt"`
+	Settings           UserSettings         `json:"settings"`
+	Holdings           []BackupHolding      `json:"holdings"`
+	Transactions       []Transaction        `json:"transactions"`
+	LotSelections      []BackupLotSelection `json:"lotSelections"`
+	Targets            TargetSet            `json:"targets"`
+	SymbolMappings     []SymbolMapping      `json:"symbolMappings"`
+	CorporateActions   []CorporateAction    `json:"corporateActions"`
+	DividendPostings   []BackupPosting      `json:"dividendPostings"`
+	ImportFingerprints []BackupImportedRow  `json:"importFingerprints"`
+}
+
+// BackupHolding is an assets row. Quantity and average price are derived
+// from the ledger and only informational in the archive.
+type BackupHolding struct {
+	Symbol      string   `json:"symbol"`
+	Nickname    string   `json:"nickname"`
+	Type        string   `json:"type"`
+	Currency    string   `json:"currency"`
+	Tag         string   `json:"tag"`
+	Drip        bool     `json:"drip"`
+	Withholding *float64 `json:"withholding,omitempty"`
+	Quantity    float64  `json:"quantity"`
+	AvgPrice    float64  `json:"avgPrice"`
+	LastPrice   float64  `json:"lastPrice"`
+}
+
+type BackupLotSelection struct {
+	SellTxnID int     `json:"sellTxnId"`
+	LotTxnID  int     `json:"lotTxnId"`
+	Quantity  float64 `json:"quantity"`
+}
+
+// BackupPosting records a provider dividend already booked, so a restored
+// account does not book it again.
+type BackupPosting struct {
+	Symbol string `json:"symbol"`
+	ExDate string `json:"exDate"`
+}
+
+// BackupImportedRow keeps statement imports idempotent across a restore.
+type BackupImportedRow struct {
+	Fingerprint string    `json:"fingerprint"`
+	Format      string    `json:"format"`
+	TxnID       int       `json:"txnId,omitempty"`
+	ImportedAt  time.Time `json:"importedAt"`
+}
+
+// RestoreCount is how many archive entries of one kind were written or
+// left alone because the account already had them.
+type RestoreCount struct {
+	Restored int `json:"restored"`
+	Skipped  int `json:"skipped"`
+}
+
+// errRestoreConflict marks archive data that cannot be merged into the
+// account as it stands, e.g. a sell of shares the merged ledger lacks.
+var errRestoreConflict = errors.New("backup conflicts with the account")
+
+// --- EXPORT ---
+
+func exportBackup(ctx context.Context, q dbQuerier, userID int) (*Backup, error) {
+	b := &Backup{
+		Format: backupFormat, Version: backupVersion, ExportedAt: time.Now().UTC(),
+		Holdings: []BackupHolding{}, LotSelections: []BackupLotSelection{}, SymbolMappings: []SymbolMapping{},
+		CorporateActions: []CorporateAction{}, DividendPostings: []BackupPosting{}, ImportFingerprints: []BackupImportedRow{},
+	}
+	var err error
+	if b.Settings, err = loadUserSettings(ctx, q, userID); err != nil {
+		return nil, err
+	}
+	if b.Targets, err = loadTargetSet(ctx, q, userID); err != nil {
+		return nil, err
+	}
+	if b.Transactions, err = loadTransactions(ctx, q, userID, ""); err != nil {
+		return nil, err
+	}
+	if b.Transactions == nil {
+		b.Transactions = []Transaction{}
+	}
+	for i := range b.Transactions {
+		b.Transactions[i].UserID = 0
+	}
+
+	mappings, err := loadSymbolMappings(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	for source, symbol := range mappings {
+		b.SymbolMappings = append(b.SymbolMappings, SymbolMapping{Source: source, Symbol: symbol})
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT name, COALESCE(nickname, ''), asset_type, currency, COALESCE(tag, ''), drip, withholding, quantity, avg_price, current_price
+		FROM assets WHERE user_id=$1 ORDER BY name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var h BackupHolding
+		if err := rows.Scan(&h.Symbol, &h.Nickname, &h.Type, &h.Currency, &h.Tag, &h.Drip, &h.Withholding, &h.Quantity, &h.AvgPrice, &h.LastPrice); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b.Holdings = append(b.Holdings, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	selections, err := loadLotSelections(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	for sellID, sels := range selections {
+		for _, s := range sels {
+			b.LotSelections = append(b.LotSelections, BackupLotSelection{SellTxnID: sellID, LotTxnID: s.LotID, Quantity: s.Quantity})
+		}
+	}
+
+	// The user's manual actions, plus provider actions already applied to
+	// them so the restored ledger is not adjusted twice
+	rows, err = q.Query(ctx, `
+		SELECT a.user_id IS NOT NULL, a.symbol, a.action_type, to_char(a.ex_date, 'YYYY-MM-DD'), a.ratio,
+			COALESCE(a.new_symbol, ''), a.source, COALESCE(a.notes, ''),
+			l.quantity_before, l.quantity_after, l.txn_ids, COALESCE(l.notes, ''), l.applied_at
+		FROM corporate_actions a
+		LEFT JOIN corporate_action_log l ON l.action_id=a.id AND l.user_id=$1
+		WHERE a.user_id=$1 OR (a.user_id IS NULL AND l.action_id IS NOT NULL)
+		ORDER BY a.ex_date, a.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var a CorporateAction
+		var before, after *float64
+		var txnIDs []int
+		var notes string
+		var appliedAt *time.Time
+		if err := rows.Scan(&a.Manual, &a.Symbol, &a.Type, &a.ExDate, &a.Ratio, &a.NewSymbol, &a.Source, &a.Notes,
+			&before, &after, &txnIDs, &notes, &appliedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if appliedAt != nil {
+			a.Applied = &ActionApplication{QuantityBefore: *before, QuantityAfter: *after, TxnIDs: txnIDs, Notes: notes, AppliedAt: *appliedAt}
+		}
+		b.CorporateActions = append(b.CorporateActions, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(ctx, "SELECT symbol, to_char(ex_date, 'YYYY-MM-DD') FROM dividend_postings WHERE user_id=$1 ORDER BY ex_date, symbol", userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var p BackupPosting
+		if err := rows.Scan(&p.Symbol, &p.ExDate); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b.DividendPostings = append(b.DividendPostings, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(ctx, "SELECT fingerprint, format, COALESCE(txn_id, 0), imported_at FROM import_rows WHERE user_id=$1 ORDER BY imported_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r BackupImportedRow
+		if err := rows.Scan(&r.Fingerprint, &r.Format, &r.TxnID, &r.ImportedAt); err != nil {
+			return nil, err
+		}
+		b.ImportFingerprints = append(b.ImportFingerprints, r)
+	}
+	return b, rows.Err()
+}
+
+// --- RESTORE ---
+
+// validateBackup checks the archive as a whole before anything is written:
+// its version, settings and targets, and that each symbol's ledger replays.
+func validateBackup(b *Backup) error {
+	if b.Format != backupFormat {
+		return fmt.Errorf("not a portfolio backup (format %q)", b.Format)
+	}
+	if b.Version < 1 || b.Version > backupVersion {
+		return fmt.Errorf("backup version %d is not supported; this server reads versions 1 to %d", b.Version, backupVersion)
+	}
+
+	s := &b.Settings
+	s.LotMethod = strings.ToLower(s.LotMethod)
+	if !validLotMethod(s.LotMethod) {
+		return fmt.Errorf("settings: lotMethod must be one of fifo, lifo, hifo, specific")
+	}
+	s.BaseCurrency = strings.ToUpper(s.BaseCurrency)
+	if !validCurrencyCode(s.BaseCurrency) {
+		return fmt.Errorf("settings: baseCurrency must be a three-letter currency code")
+	}
+	benchmarks, err := normalizeBenchmarks(s.Benchmarks)
+	if err != nil {
+		return fmt.Errorf("settings: %w", err)
+	}
+	s.Benchmarks = benchmarks
+	if s.DividendWithholding < 0 || s.DividendWithholding >= 100 {
+		return fmt.Errorf("settings: dividendWithholding must be a percentage from 0 to under 100")
+	}
+	for _, h := range b.Holdings {
+		if w := h.Withholding; w != nil && (*w < 0 || *w >= 100) {
+			return fmt.Errorf("holding %s: withholding must be a percentage from 0 to under 100", h.Symbol)
+		}
+	}
+	if len(b.Targets.Targets) > 0 {
+		if err := validateTargetSet(&b.Targets); err != nil {
+			return fmt.Errorf("targets: %w", err)
+		}
+	}
+
+	// Restores insert in ledger order
+	sort.SliceStable(b.Transactions, func(i, j int) bool {
+		if b.Transactions[i].Date != b.Transactions[j].Date {
+			return b.Transactions[i].Date < b.Transactions[j].Date
+		}
+		return b.Transactions[i].ID < b.Transactions[j].ID
+	})
+	bySymbol := map[string][]Transaction{}
+	seen := map[int]bool{}
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		if err := validateTransaction(t); err != nil {
+			return fmt.Errorf("transaction %d: %w", t.ID, err)
+		}
+		if seen[t.ID] {
+			return fmt.Errorf("transaction id %d appears twice", t.ID)
+		}
+		seen[t.ID] = true
+		bySymbol[t.Symbol] = append(bySymbol[t.Symbol], *t)
+	}
+	for symbol, txns := range bySymbol {
+		if _, err := replayLedger(txns); err != nil {
+			return fmt.Errorf("ledger for %s: %w", symbol, err)
+		}
+	}
+	for i := range b.CorporateActions {
+		if err := validateCorporateAction(&b.CorporateActions[i]); err != nil {
+			return fmt.Errorf("corporate action on %s: %w", b.CorporateActions[i].Symbol, err)
+		}
+	}
+	return nil
+}
+
+// clearAccount deletes everything restoreBackup writes, for RestoreReplace.
+func clearAccount(ctx context.Context, q dbQuerier, userID int) error {
+	for _, stmt := range []string{
+		"DELETE FROM corporate_action_log WHERE user_id=$1",
+		"DELETE FROM corporate_actions WHERE user_id=$1",
+		"DELETE FROM transactions WHERE user_id=$1",
+		"DELETE FROM import_rows WHERE user_id=$1",
+		"DELETE FROM dividend_postings WHERE user_id=$1",
+		"DELETE FROM portfolio_snapshots WHERE user_id=$1",
+		"DELETE FROM assets WHERE user_id=$1",
+		"DELETE FROM allocation_targets WHERE user_id=$1",
+		"DELETE FROM symbol_mappings WHERE user_id=$1",
+		"DELETE FROM user_settings WHERE user_id=$1",
+	} {
+		if _, err := q.Exec(ctx, stmt, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ledgerKey(t Transaction) string {
+	return fmt.Sprintf("%s|%s|%s|%.8f|%.8f|%.8f", t.Symbol, t.Type, t.Date, t.Quantity, t.Price, t.Fees)
+}
+
+// restoreBackup writes a validated archive into the user's account inside
+// the caller's transaction. Ledger entries identical to one already in the
+// account are matched rather than duplicated, so restoring the same archive
+// twice changes nothing.
+func restoreBackup(ctx context.Context, tx pgx.Tx, userID int, b *Backup, conflict string) (map[string]RestoreCount, error) {
+	report := map[string]RestoreCount{}
+	count := func(section string, restored bool) {
+		c := report[section]
+		if restored {
+			c.Restored++
+		} else {
+			c.Skipped++
+		}
+		report[section] = c
+	}
+	if conflict == RestoreReplace {
+		if err := clearAccount(ctx, tx, userID); err != nil {
+			return nil, err
+		}
+	}
+	overwrite := conflict != RestoreSkip
+
+	// Settings
+	var hasSettings bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM user_settings WHERE user_id=$1)", userID).Scan(&hasSettings); err != nil {
+		return nil, err
+	}
+	if overwrite || !hasSettings {
+		s := b.Settings
+		_, err := tx.Exec(ctx,
+			`INSERT INTO user_settings (user_id, lot_method, base_currency, benchmarks, dividend_withholding) VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (user_id) DO UPDATE SET lot_method=EXCLUDED.lot_method, base_currency=EXCLUDED.base_currency,
+			 	benchmarks=EXCLUDED.benchmarks, dividend_withholding=EXCLUDED.dividend_withholding`,
+			userID, s.LotMethod, s.BaseCurrency, s.Benchmarks, s.DividendWithholding)
+		if err != nil {
+			return nil, err
+		}
+	}
+	count("settings", overwrite || !hasSettings)
+
+	// Holdings, before the ledger so entries find their assets row
+	for _, h := range b.Holdings {
+		var id int
+		err := tx.QueryRow(ctx, "SELECT id FROM assets WHERE name=$1 AND user_id=$2 LIMIT 1", h.Symbol, userID).Scan(&id)
+		switch {
+		case err == pgx.ErrNoRows:
+			_, err = tx.Exec(ctx, `
+				INSERT INTO assets (user_id, name, nickname, asset_type, quantity, avg_price, current_price, previous_close, currency, tag, drip, withholding)
+				VALUES ($1, $2, $3, $4, 0, 0, $5, $5, $6, NULLIF($7, ''), $8, $9)`,
+				userID, h.Symbol, h.Nickname, h.Type, h.LastPrice, h.Currency, h.Tag, h.Drip, h.Withholding)
+			count("holdings", true)
+		case err == nil && overwrite:
+			_, err = tx.Exec(ctx, "UPDATE assets SET nickname=$1, asset_type=$2, tag=NULLIF($3, ''), drip=$4, withholding=$5 WHERE id=$6",
+				h.Nickname, h.Type, h.Tag, h.Drip, h.Withholding, id)
+			count("holdings", true)
+		case err == nil:
+			count("holdings", false)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Ledger
+	existing, err := loadTransactions(ctx, tx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	unmatched := map[string][]int{}
+	for _, t := range existing {
+		unmatched[ledgerKey(t)] = append(unmatched[ledgerKey(t)], t.ID)
+	}
+	ids := map[int]int{} // archive ID -> account ID
+	inserted := map[int]bool{}
+	touched := map[string]bool{}
+	earliest := ""
+	for _, t := range b.Transactions {
+		key := ledgerKey(t)
+		if match := unmatched[key]; len(match) > 0 {
+			ids[t.ID], unmatched[key] = match[0], match[1:]
+			count("transactions", false)
+			continue
+		}
+		if _, err := ensureHoldingRow(ctx, tx, userID, t.Symbol, "", t.AssetType, t.Currency, t.Price); err != nil {
+			return nil, err
+		}
+		var id int
+		err := tx.QueryRow(ctx,
+			`INSERT INTO transactions (user_id, symbol, txn_type, trade_date, quantity, price, fees, currency, notes)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+			userID, t.Symbol, t.Type, t.Date, t.Quantity, t.Price, t.Fees, t.Currency, t.Notes).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids[t.ID], inserted[id], touched[t.Symbol] = id, true, true
+		if earliest == "" || t.Date < earliest {
+			earliest = t.Date
+		}
+		count("transactions", true)
+	}
+	if earliest != "" {
+		if err := invalidateSnapshots(ctx, tx, userID, earliest); err != nil {
+			return nil, err
+		}
+	}
+	for symbol := range touched {
+		if err := rebuildHolding(ctx, tx, userID, symbol); err != nil {
+			return nil, fmt.Errorf("%w: ledger for %s: %v", errRestoreConflict, symbol, err)
+		}
+	}
+
+	// Lot selections belong to sells this restore created
+	for _, s := range b.LotSelections {
+		sellID, lotID := ids[s.SellTxnID], ids[s.LotTxnID]
+		if !inserted[sellID] || lotID == 0 {
+			count("lotSelections", false)
+			continue
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO lot_selections (sell_txn_id, lot_txn_id, quantity) VALUES ($1, $2, $3)", sellID, lotID, s.Quantity); err != nil {
+			return nil, err
+		}
+		count("lotSelections", true)
+	}
+
+	// Targets are one set, so they are restored or kept as a whole
+	if len(b.Targets.Targets) > 0 {
+		var hasTargets bool
+		if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM allocation_targets WHERE user_id=$1)", userID).Scan(&hasTargets); err != nil {
+			return nil, err
+		}
+		if overwrite || !hasTargets {
+			if _, err := tx.Exec(ctx, "DELETE FROM allocation_targets WHERE user_id=$1", userID); err != nil {
+				return nil, err
+			}
+			for _, t := range b.Targets.Targets {
+				_, err := tx.Exec(ctx, "INSERT INTO allocation_targets (user_id, kind, key, weight, tolerance) VALUES ($1, $2, $3, $4, $5)",
+					userID, b.Targets.Kind, t.Key, t.Weight, t.Tolerance)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		count("targets", overwrite || !hasTargets)
+	}
+
+	for _, m := range b.SymbolMappings {
+		upsert := "DO NOTHING"
+		if overwrite {
+			upsert = "DO UPDATE SET symbol=EXCLUDED.symbol"
+		}
+		tag, err := tx.Exec(ctx, `INSERT INTO symbol_mappings (user_id, source, symbol) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, source) `+upsert, userID, strings.ToUpper(m.Source), m.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		count("symbolMappings", tag.RowsAffected() > 0)
+	}
+
+	// Corporate actions: provider ones are linked to this deployment's copy
+	// when it has the same action, so their application log carries over.
+	// Anything else is recreated for this user only; a backup never adds
+	// the global rows that apply to every holder.
+	for _, a := range b.CorporateActions {
+		var id int
+		restored := false
+		err = pgx.ErrNoRows
+		if !a.Manual {
+			err = tx.QueryRow(ctx, `
+				SELECT id FROM corporate_actions
+				WHERE user_id IS NULL AND symbol=$1 AND action_type=$2 AND ex_date=$3
+					AND abs(ratio - $4) < 1e-9 AND COALESCE(new_symbol, '')=$5`,
+				a.Symbol, a.Type, a.ExDate, a.Ratio, a.NewSymbol).Scan(&id)
+		}
+		if err == pgx.ErrNoRows {
+			err = tx.QueryRow(ctx, "SELECT id FROM corporate_actions WHERE user_id=$1 AND symbol=$2 AND action_type=$3 AND ex_date=$4",
+				userID, a.Symbol, a.Type, a.ExDate).Scan(&id)
+		}
+		if err == pgx.ErrNoRows {
+			restored = true
+			err = tx.QueryRow(ctx, `
+				INSERT INTO corporate_actions (user_id, symbol, action_type, ex_date, ratio, new_symbol, source, notes)
+				VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8) RETURNING id`,
+				userID, a.Symbol, a.Type, a.ExDate, a.Ratio, a.NewSymbol, a.Source, a.Notes).Scan(&id)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if a.Applied != nil {
+			txnIDs := []int{}
+			for _, old := range a.Applied.TxnIDs {
+				if id, ok := ids[old]; ok {
+					txnIDs = append(txnIDs, id)
+				}
+			}
+			tag, err := tx.Exec(ctx, `
+				INSERT INTO corporate_action_log (action_id, user_id, quantity_before, quantity_after, txn_ids, notes, applied_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING`,
+				id, userID, a.Applied.QuantityBefore, a.Applied.QuantityAfter, txnIDs, a.Applied.Notes, a.Applied.AppliedAt)
+			if err != nil {
+				return nil, err
+			}
+			restored = restored || tag.RowsAffected() > 0
+		}
+		count("corporateActions", restored)
+	}
+
+	for _, p := range b.DividendPostings {
+		tag, err := tx.Exec(ctx, "INSERT INTO dividend_postings (user_id, symbol, ex_date) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", userID, p.Symbol, p.ExDate)
+		if err != nil {
+			return nil, err
+		}
+		count("dividendPostings", tag.RowsAffected() > 0)
+	}
+
+	for _, r := range b.ImportFingerprints {
+		var txnID *int
+		if id, ok := ids[r.TxnID]; ok {
+			txnID = &id
+		}
+		tag, err := tx.Exec(ctx, `INSERT INTO import_rows (user_id, fingerprint, format, txn_id, imported_at) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, fingerprint) DO NOTHING`, userID, r.Fingerprint, r.Format, txnID, r.ImportedAt)
+		if err != nil {
+			return nil, err
+		}
+		count("importFingerprints", tag.RowsAffected() > 0)
+	}
+	return report, nil
+}
+
+// readBackupRequest accepts the archive as a multipart "file" field or as
+// the raw JSON request body.
+func readBackupRequest(c *gin.Context) (*Backup, error) {
+	var r io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxBackupBytes)
+	if c.ContentType() == "multipart/form-data" {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("file is required")
+		}
+		if fh.Size > maxBackupBytes {
+			return nil, fmt.Errorf("file is larger than %d MB", maxBackupBytes>>20)
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var b Backup
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, fmt.Errorf("could not read backup: %w", err)
+	}
+	return &b, nil
+}
+
+// --- ROUTES ---
+
+func registerBackupRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/export - Download everything the user owns as a versioned JSON archive
+	api.GET("/export", func(c *gin.Context) {
+		b, err := exportBackup(context.Background(), dbPool, currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="portfolio-backup-%s.json"`, b.ExportedAt.Format(dateLayout)))
+		c.JSON(http.StatusOK, b)
+	})
+
+	// POST /api/import/backup?conflict=skip|overwrite|replace - Restore an archive from GET /api/export
+	api.POST("/import/backup", func(c *gin.Context) {
+		conflict := c.DefaultQuery("conflict", RestoreSkip)
+		if conflict != RestoreSkip && conflict != RestoreOverwrite && conflict != RestoreReplace {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "conflict must be one of skip, overwrite, replace"})
+			return
+		}
+		b, err := readBackupRequest(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateBackup(b); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+
+		ctx := context.Background()
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		report, err := restoreBackup(ctx, tx, userID, b, conflict)
+		if errors.Is(err, errRestoreConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println("Restore failed:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore backup"})
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore backup"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Backup restored", "conflict": conflict, "report": report})
+	})
+}
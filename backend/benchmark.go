@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const maxBenchmarks = 5
+
+// defaultBenchmarks picks a home-market index when the user has chosen none.
+var defaultBenchmarks = map[string]string{
+	"INR": "^NSEI",
+	"SGD": "^STI",
+	"USD": "^GSPC",
+}
+
+func defaultBenchmark(baseCurrency string) string {
+	if b, ok := defaultBenchmarks[baseCurrency]; ok {
+		return b
+	}
+	return "^GSPC"
+}
+
+// normalizeBenchmarks cleans the user's benchmark list. Symbols are stored as
+// given (upper-cased); checkBenchmarks verifies they have price history.
+func normalizeBenchmarks(symbols []string) ([]string, error) {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, s := range symbols {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	if len(out) > maxBenchmarks {
+		return nil, fmt.Errorf("at most %d benchmarks can be selected", maxBenchmarks)
+	}
+	return out, nil
+}
+
+// checkBenchmarks rejects symbols with neither stored closes nor history
+// from the market providers.
+func checkBenchmarks(ctx context.Context, q dbQuerier, symbols []string) error {
+	for _, s := range symbols {
+		var stored bool
+		if err := q.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM price_history WHERE symbol=$1)", s).Scan(&stored); err != nil {
+			return err
+		}
+		if stored {
+			continue
+		}
+		series, err := market.History(ctx, s, "1mo")
+		if err != nil || len(series.Points) == 0 {
+			return fmt.Errorf("no price history for benchmark %s", s)
+		}
+	}
+	return nil
+}
+
+// BenchmarkPoint is the cumulative return of portfolio and benchmark since
+// the start of the range, both rebased to 0 on the first common day.
+type BenchmarkPoint struct {
+	Date      string  `json:"date"`
+	Portfolio float64 `json:"portfolio"`
+	Benchmark float64 `json:"benchmark"`
+}
+
+// BenchmarkStats compare daily portfolio returns with the benchmark's.
+// Alpha is Jensen's alpha over the base currency's risk-free rate and, like
+// tracking error, is annualised.
+type BenchmarkStats struct {
+	PortfolioReturn float64 `json:"portfolioReturn"`
+	BenchmarkReturn float64 `json:"benchmarkReturn"`
+	ExcessReturn    float64 `json:"excessReturn"`
+	Alpha           float64 `json:"alpha"`
+	Beta            float64 `json:"beta"`
+	TrackingError   float64 `json:"trackingError"`
+	RiskFreeRate    float64 `json:"riskFreeRate"`
+	Observations    int     `json:"observations"`
+}
+
+// compareToBenchmark aligns the portfolio's growth index to the benchmark's
+// trading days (carrying the last portfolio value over days it has none)
+// and computes comparison statistics from the paired daily returns. Both
+// series must be in the same currency; riskFree is an annual rate.
+func compareToBenchmark(index, bench []PricePoint, riskFree float64) ([]BenchmarkPoint, BenchmarkStats) {
+	var stats BenchmarkStats
+	var pLevels, bLevels []float64
+	var dates []time.Time
+	for _, b := range bench {
+		if len(index) == 0 || b.Time.Before(index[0].Time) || b.Close <= 0 {
+			continue
+		}
+		p, ok := closeOn(index, b.Time)
+		if !ok || p <= 0 {
+			continue
+		}
+		pLevels = append(pLevels, p)
+		bLevels = append(bLevels, b.Close)
+		dates = append(dates, b.Time)
+	}
+
+	series := make([]BenchmarkPoint, 0, len(dates))
+	for i, d := range dates {
+		series = append(series, BenchmarkPoint{
+			Date:      d.Format(dateLayout),
+			Portfolio: pLevels[i]/pLevels[0] - 1,
+			Benchmark: bLevels[i]/bLevels[0] - 1,
+		})
+	}
+	if len(series) < 2 {
+		return series, stats
+	}
+
+	last := series[len(series)-1]
+	stats.PortfolioReturn, stats.BenchmarkReturn = last.Portfolio, last.Benchmark
+	stats.ExcessReturn = stats.PortfolioReturn - stats.BenchmarkReturn
+
+	pRet, bRet := periodReturns(pLevels), periodReturns(bLevels)
+	stats.Observations = len(pRet)
+	if v := covariance(bRet, bRet); v > 0 {
+		stats.Beta = covariance(pRet, bRet) / v
+	}
+	// Jensen's alpha: Rp - [Rf + beta(Rb - Rf)], from daily means
+	rf := riskFree / tradingDaysPerYear
+	stats.RiskFreeRate = riskFree
+	stats.Alpha = (mean(pRet) - rf - stats.Beta*(mean(bRet)-rf)) * tradingDaysPerYear
+	diff := make([]float64, len(pRet))
+	for i := range pRet {
+		diff[i] = pRet[i] - bRet[i]
+	}
+	stats.TrackingError = stdDev(diff) * math.Sqrt(tradingDaysPerYear)
+	return series, stats
+}
+
+// --- ROUTES ---
+
+func registerBenchmarkRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/analytics/benchmark?symbol=^NSEI&range=1Y - Portfolio vs benchmark cumulative returns and statistics
+	api.GET("/analytics/benchmark", func(c *gin.Context) {
+		ctx := context.Background()
+		req, ok := loadAnalyticsRequest(c, dbPool, "1Y")
+		if !ok {
+			return
+		}
+
+		symbol := strings.ToUpper(strings.TrimSpace(c.Query("symbol")))
+		if symbol == "" {
+			symbol = defaultBenchmark(req.base)
+			if len(req.settings.Benchmarks) > 0 {
+				symbol = req.settings.Benchmarks[0]
+			}
+		}
+		if len(req.in.txns) == 0 {
+			c.JSON(http.StatusOK, gin.H{"benchmark": symbol, "baseCurrency": req.base, "series": []BenchmarkPoint{}})
+			return
+		}
+
+		if err := ensurePriceHistory(ctx, dbPool, symbol, req.start.AddDate(0, 0, -7)); err != nil {
+			log.Printf("Benchmark history for %s unavailable: %v", symbol, err)
+		}
+		bench, err := loadPriceHistory(ctx, dbPool, symbol, req.start.AddDate(0, 0, -1))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load benchmark history"})
+			return
+		}
+		if len(bench.Points) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No price history for benchmark " + symbol})
+			return
+		}
+
+		// Compare like with like: the benchmark in the portfolio's base currency
+		benchCurrency := normalizeCurrency(bench.Currency)
+		missing := req.fx.missing
+		if benchCurrency != "" && benchCurrency != req.base {
+			fx, err := loadFXTable(ctx, dbPool, []string{benchCurrency}, req.base)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+				return
+			}
+			converted := make([]PricePoint, 0, len(bench.Points))
+			for _, p := range bench.Points {
+				if rate := fx.rate(benchCurrency, p.Time); rate > 0 {
+					converted = append(converted, PricePoint{Time: p.Time, Close: p.Close * rate})
+				}
+			}
+			bench.Points = converted
+			missing = append(missing, fx.missing...)
+		}
+
+		opening, points := valuationSeries(req.in.txns, req.in.prices, req.in.currencyOf, req.fx.rate, req.start, req.today)
+		index, _ := twrIndex(opening, points)
+		series, stats := compareToBenchmark(index, bench.Points, riskFreeRates[req.base])
+
+		c.JSON(http.StatusOK, gin.H{
+			"benchmark":         symbol,
+			"benchmarkCurrency": bench.Currency,
+			"baseCurrency":      req.base,
+			"range":             req.rng,
+			"from":              req.start.Format(dateLayout),
+			"to":                req.today.Format(dateLayout),
+			"series":            series,
+			"stats":             stats,
+			"missingRates":      missing,
+		})
+	})
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Plain-text accounting formats for GET /api/export/books
+const (
+	BooksBeancount = "beancount"
+	BooksLedger    = "ledger"
+)
+
+// Accounts the exported books post to. Cash is not tracked by the app, so
+// the cash accounts' balances are the net amounts paid in and taken out.
+const (
+	booksInvestments = "Assets:Investments"
+	booksCash        = "Assets:Cash"
+	booksDividends   = "Income:Dividends"
+	booksGains       = "Income:CapitalGains"
+	booksFees        = "Expenses:Fees"
+	booksWithholding = "Expenses:Taxes:Withholding"
+	booksTransfers   = "Equity:Transfers"
+)
+
+// commodityName turns a ticker or currency into a name both Beancount and
+// Ledger accept: upper case, starting with a letter, ending with a letter or
+// digit, at most 24 characters of A-Z 0-9 ' . _ -. Yahoo's pence quotes
+// (GBp) become GBX so they do not collide with pounds.
+func commodityName(symbol string) string {
+	if symbol == "GBp" {
+		return "GBX"
+	}
+	var b strings.Builder
+	for _, r := range strings.ToUpper(symbol) {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-', r == '\'':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	name := strings.Trim(b.String(), "-._'")
+	if name == "" || name[0] < 'A' || name[0] > 'Z' {
+		name = "X" + name
+	}
+	if len(name) > 24 {
+		name = name[:24]
+	}
+	return strings.TrimRight(name, "-._'")
+}
+
+// accountPart is a commodity name as an account component, which only
+// allows letters, digits and dashes.
+func accountPart(commodity string) string {
+	return strings.NewReplacer(".", "-", "_", "-", "'", "").Replace(commodity)
+}
+
+// booksNumber formats an amount with at most the given decimals and no
+// trailing zeros, so the same value always prints the same way.
+func booksNumber(v float64, decimals int) string {
+	p := math.Pow(10, float64(decimals))
+	v = math.Round(v*p) / p
+	if v == 0 {
+		v = 0 // no "-0"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// booksWriter renders directives in either format. The two differ in
+// syntax only: Ledger quotes commodities containing non-letters, writes lot
+// dates in brackets and has no open or option directives.
+type booksWriter struct {
+	format string
+	out    strings.Builder
+}
+
+func (w *booksWriter) beancount() bool { return w.format == BooksBeancount }
+
+func (w *booksWriter) date(d string) string {
+	if w.beancount() {
+		return d
+	}
+	return strings.ReplaceAll(d, "-", "/")
+}
+
+func (w *booksWriter) commodity(name string) string {
+	if w.beancount() {
+		return name
+	}
+	for _, r := range name {
+		if r < 'A' || r > 'Z' {
+			return `"` + name + `"`
+		}
+	}
+	return name
+}
+
+// booksString flattens free text for a quoted string or comment.
+func booksString(s string) string {
+	return strings.NewReplacer(`"`, "'", `\`, "/").Replace(strings.Join(strings.Fields(s), " "))
+}
+
+// lotSpec is the cost annotation of a posting that opens or closes a lot.
+type lotSpec struct {
+	cost     float64
+	currency string
+	date     string
+}
+
+type booksPosting struct {
+	account   string
+	amount    float64
+	commodity string // "" elides the amount for the format to balance
+	lot       *lotSpec
+	price     float64
+	priceCur  string
+}
+
+func (w *booksWriter) transaction(date, payee, narration string, meta map[string]string, postings []booksPosting) {
+	o := &w.out
+	if w.beancount() {
+		fmt.Fprintf(o, "%s * \"%s\" \"%s\"\n", date, booksString(payee), booksString(narration))
+		keys := make([]string, 0, len(meta))
+		for k := range meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(o, "  %s: \"%s\"\n", k, booksString(meta[k]))
+		}
+	} else {
+		fmt.Fprintf(o, "%s * %s\n", w.date(date), booksString(payee+" - "+narration))
+		keys := make([]string, 0, len(meta))
+		for k := range meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(o, "    ; %s: %s\n", k, booksString(meta[k]))
+		}
+	}
+	for _, p := range postings {
+		indent := "  "
+		if !w.beancount() {
+			indent = "    "
+		}
+		if p.commodity == "" {
+			fmt.Fprintf(o, "%s%s\n", indent, p.account)
+			continue
+		}
+		line := fmt.Sprintf("%s%-38s  %s %s", indent, p.account, booksNumber(p.amount, 8), w.commodity(p.commodity))
+		if l := p.lot; l != nil {
+			switch {
+			case w.beancount():
+				line += fmt.Sprintf(" {%s %s, %s}", booksNumber(l.cost, 8), l.currency, l.date)
+			default:
+				line += fmt.Sprintf(" {%s %s} [%s]", booksNumber(l.cost, 8), w.commodity(l.currency), w.date(l.date))
+			}
+		}
+		if p.priceCur != "" {
+			line += fmt.Sprintf(" @ %s %s", booksNumber(p.price, 8), w.commodity(p.priceCur))
+		}
+		o.WriteString(line + "\n")
+	}
+	o.WriteString("\n")
+}
+
+func (w *booksWriter) priceDirective(date, commodity string, value float64, currency string) {
+	if w.beancount() {
+		fmt.Fprintf(&w.out, "%s price %s %s %s\n", date, commodity, booksNumber(value, 8), currency)
+	} else {
+		fmt.Fprintf(&w.out, "P %s %s %s %s\n", w.date(date), w.commodity(commodity), booksNumber(value, 8), w.commodity(currency))
+	}
+}
+
+// booksHolding is what the export needs from an assets row.
+type booksHolding struct {
+	nickname string
+	currency string
+	name     string // commodity name
+}
+
+// exportBooks renders the user's whole ledger: commodity declarations,
+// account openings, one transaction per ledger entry with lots matched the
+// way the app matches them, and price directives from stored closes
+// ("daily", "monthly" month-ends, or "none").
+func exportBooks(ctx context.Context, q dbQuerier, userID int, format, prices string) (string, error) {
+	settings, err := loadUserSettings(ctx, q, userID)
+	if err != nil {
+		return "", err
+	}
+	selections, err := loadLotSelections(ctx, q, userID)
+	if err != nil {
+		return "", err
+	}
+	txns, err := loadTransactions(ctx, q, userID, "")
+	if err != nil {
+		return "", err
+	}
+
+	holdings := map[string]*booksHolding{}
+	rows, err := q.Query(ctx, "SELECT name, COALESCE(nickname, ''), currency FROM assets WHERE user_id=$1", userID)
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+		h := &booksHolding{}
+		var symbol string
+		if err := rows.Scan(&symbol, &h.nickname, &h.currency); err != nil {
+			rows.Close()
+			return "", err
+		}
+		holdings[symbol] = h
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	bySymbol := map[string][]Transaction{}
+	var symbols []string
+	currencies := map[string]bool{}
+	for _, t := range txns {
+		if _, ok := bySymbol[t.Symbol]; !ok {
+			symbols = append(symbols, t.Symbol)
+		}
+		bySymbol[t.Symbol] = append(bySymbol[t.Symbol], t)
+		currencies[commodityName(t.Currency)] = true
+	}
+	sort.Strings(symbols)
+
+	// Commodity names must stay unique after mapping
+	used := map[string]string{}
+	for c := range currencies {
+		used[c] = c
+	}
+	for _, symbol := range symbols {
+		h := holdings[symbol]
+		if h == nil {
+			h = &booksHolding{currency: bySymbol[symbol][0].Currency}
+			holdings[symbol] = h
+		}
+		name := commodityName(symbol)
+		for i := 2; used[name] != "" && used[name] != symbol; i++ {
+			name = commodityName(fmt.Sprintf("%s-%d", symbol, i))
+		}
+		used[name] = symbol
+		h.name = name
+	}
+
+	w := &booksWriter{format: format}
+	start := time.Now().Format(dateLayout)
+	if len(txns) > 0 {
+		start = txns[0].Date
+	}
+
+	// Header: options, commodities, accounts
+	if w.beancount() {
+		fmt.Fprintf(&w.out, "option \"title\" \"Portfolio\"\noption \"operating_currency\" \"%s\"\n", settings.BaseCurrency)
+		// Reductions name their lot by cost and date, so whatever the user's
+		// lot method, FIFO only ever chooses between identical lots
+		w.out.WriteString("option \"booking_method\" \"FIFO\"\noption \"inferred_tolerance_default\" \"*:0.000001\"\n\n")
+	} else {
+		fmt.Fprintf(&w.out, "; Portfolio export, operating currency %s\n\n", settings.BaseCurrency)
+	}
+	var curList []string
+	for c := range currencies {
+		curList = append(curList, c)
+	}
+	sort.Strings(curList)
+	for _, c := range curList {
+		if w.beancount() {
+			fmt.Fprintf(&w.out, "%s commodity %s\n", start, c)
+		} else {
+			fmt.Fprintf(&w.out, "commodity %s\n", w.commodity(c))
+		}
+	}
+	for _, symbol := range symbols {
+		h := holdings[symbol]
+		if w.beancount() {
+			fmt.Fprintf(&w.out, "%s commodity %s\n  symbol: \"%s\"\n", start, h.name, booksString(symbol))
+			if h.nickname != "" {
+				fmt.Fprintf(&w.out, "  name: \"%s\"\n", booksString(h.nickname))
+			}
+		} else {
+			fmt.Fprintf(&w.out, "commodity %s\n    note %s\n", w.commodity(h.name), booksString(strings.TrimSpace(symbol+" "+h.nickname)))
+		}
+	}
+	w.out.WriteString("\n")
+
+	accounts := []string{booksFees, booksWithholding, booksTransfers}
+	for _, c := range curList {
+		accounts = append(accounts, booksCash+":"+accountPart(c))
+	}
+	for _, symbol := range symbols {
+		part := accountPart(holdings[symbol].name)
+		accounts = append(accounts, booksInvestments+":"+part, booksDividends+":"+part, booksGains+":"+part)
+	}
+	sort.Strings(accounts)
+	for _, a := range accounts {
+		if w.beancount() {
+			fmt.Fprintf(&w.out, "%s open %s\n", start, a)
+		} else {
+			fmt.Fprintf(&w.out, "account %s\n", a)
+		}
+	}
+	w.out.WriteString("\n")
+
+	// Entries are rendered per symbol (lots are per symbol) and then
+	// written in date order
+	type entry struct {
+		date     string
+		id       int
+		payee    string
+		text     string
+		meta     map[string]string
+		postings []booksPosting
+	}
+	var entries []entry
+	for _, symbol := range symbols {
+		st := bySymbol[symbol]
+		h := holdings[symbol]
+		_, closed, err := matchLots(st, settings.LotMethod, selections)
+		if err != nil {
+			return "", err
+		}
+		closedBy := map[int][]ClosedLot{}
+		for _, l := range closed {
+			closedBy[l.SellID] = append(closedBy[l.SellID], l)
+		}
+		inv := booksInvestments + ":" + accountPart(h.name)
+		payee := symbol
+		if h.nickname != "" {
+			payee = h.nickname
+		}
+
+		for i, t := range st {
+			cur := commodityName(t.Currency)
+			cash := booksCash + ":" + accountPart(cur)
+			e := entry{date: t.Date, id: t.ID, payee: payee, meta: map[string]string{"txn-id": strconv.Itoa(t.ID)}}
+			if t.Notes != "" {
+				e.meta["note"] = t.Notes
+			}
+			qty := booksNumber(t.Quantity, 8) + " " + h.name
+			switch t.Type {
+			case TxnBuy, TxnTransferIn:
+				cost := t.Quantity*t.Price + t.Fees
+				lot := &lotSpec{cost: cost / t.Quantity, currency: cur, date: t.Date}
+				e.postings = append(e.postings, booksPosting{account: inv, amount: t.Quantity, commodity: h.name, lot: lot})
+				if t.Type == TxnBuy {
+					e.text = "Buy " + qty
+					e.postings = append(e.postings, booksPosting{account: cash, amount: -cost, commodity: cur})
+				} else {
+					e.text = "Transfer in " + qty
+					e.postings = append(e.postings, booksPosting{account: booksTransfers})
+				}
+
+			case TxnSell, TxnTransferOut:
+				for _, l := range closedBy[t.ID] {
+					p := booksPosting{account: inv, amount: -l.Quantity, commodity: h.name,
+						lot: &lotSpec{cost: l.CostBasis / l.Quantity, currency: cur, date: l.OpenDate}}
+					if t.Type == TxnSell {
+						p.price, p.priceCur = t.Price, cur
+					}
+					e.postings = append(e.postings, p)
+				}
+				if t.Type == TxnSell {
+					e.text = "Sell " + qty
+					if t.Fees > 0 {
+						e.postings = append(e.postings, booksPosting{account: booksFees, amount: t.Fees, commodity: cur})
+					}
+					e.postings = append(e.postings,
+						booksPosting{account: cash, amount: t.Quantity*t.Price - t.Fees, commodity: cur},
+						booksPosting{account: booksGains + ":" + accountPart(h.name)})
+				} else {
+					e.text = "Transfer out " + qty
+					e.postings = append(e.postings, booksPosting{account: booksTransfers})
+				}
+
+			case TxnDividend:
+				gross := cashAmount(t)
+				e.text = "Dividend"
+				e.postings = append(e.postings, booksPosting{account: cash, amount: gross - t.Fees, commodity: cur})
+				if t.Fees > 0 {
+					e.postings = append(e.postings, booksPosting{account: booksWithholding, amount: t.Fees, commodity: cur})
+				}
+				e.postings = append(e.postings, booksPosting{account: booksDividends + ":" + accountPart(h.name), amount: -gross, commodity: cur})
+
+			case TxnFee:
+				amount := cashAmount(t)
+				e.text = "Fee"
+				e.postings = append(e.postings,
+					booksPosting{account: booksFees, amount: amount, commodity: cur},
+					booksPosting{account: cash, amount: -amount, commodity: cur})
+
+			case TxnSplit:
+				// Neither format splits lots, so each open lot is exchanged
+				// for one with the new quantity and cost, keeping its date
+				open, _, err := matchLots(st[:i], settings.LotMethod, selections)
+				if err != nil {
+					return "", err
+				}
+				e.text = fmt.Sprintf("Split %s for 1", booksNumber(t.Quantity, 8))
+				for _, l := range open {
+					e.postings = append(e.postings,
+						booksPosting{account: inv, amount: -l.Quantity, commodity: h.name,
+							lot: &lotSpec{cost: l.CostPerUnit, currency: cur, date: l.OpenDate}},
+						booksPosting{account: inv, amount: l.Quantity * t.Quantity, commodity: h.name,
+							lot: &lotSpec{cost: l.CostPerUnit / t.Quantity, currency: cur, date: l.OpenDate}})
+				}
+				if len(open) == 0 {
+					continue
+				}
+			}
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].date != entries[j].date {
+			return entries[i].date < entries[j].date
+		}
+		return entries[i].id < entries[j].id
+	})
+	for _, e := range entries {
+		w.transaction(e.date, e.payee, e.text, e.meta, e.postings)
+	}
+
+	if prices == "none" {
+		return w.out.String(), nil
+	}
+	for _, symbol := range symbols {
+		st := bySymbol[symbol]
+		from, err := time.Parse(dateLayout, st[0].Date)
+		if err != nil {
+			return "", err
+		}
+		series, err := loadPriceHistory(ctx, q, symbol, from.AddDate(0, 0, -1))
+		if err != nil {
+			return "", err
+		}
+		// Stored closes are split-adjusted; the ledger is as traded
+		points := unadjustCloses(series.Points, st)
+		if prices == "monthly" {
+			points = thinToMonthEnds(points)
+		}
+		currency := series.Currency
+		if currency == "" {
+			currency = holdings[symbol].currency
+		}
+		for _, p := range points {
+			w.priceDirective(p.Time.Format(dateLayout), holdings[symbol].name, p.Close, commodityName(currency))
+		}
+	}
+	return w.out.String(), nil
+}
+
+// --- ROUTES ---
+
+func registerBooksRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/export/books?format=beancount|ledger&prices=daily|monthly|none - Download the ledger as plain-text accounting books
+	api.GET("/export/books", func(c *gin.Context) {
+		format := c.DefaultQuery("format", BooksBeancount)
+		if format != BooksBeancount && format != BooksLedger {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be beancount or ledger"})
+			return
+		}
+		prices := c.DefaultQuery("prices", "daily")
+		if prices != "daily" && prices != "monthly" && prices != "none" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "prices must be daily, monthly or none"})
+			return
+		}
+		books, err := exportBooks(context.Background(), dbPool, currentUserID(c), format, prices)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build export"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="portfolio.%s"`, format))
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(books))
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestCommodityName(t *testing.T) {
+	tests := []struct {
+		symbol, want string
+	}{
+		{"AAPL", "AAPL"},
+		{"usd", "USD"},
+		{"INFY.NS", "INFY.NS"},
+		{"BRK-B", "BRK-B"},
+		{"^GSPC", "GSPC"},
+		{"EUR=X", "EUR-X"},
+		{"AMFI:119551", "AMFI-119551"},
+		{"0700.HK", "X0700.HK"},
+		{"GBp", "GBX"},
+		{"GBP", "GBP"},
+		{"", "X"},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZ", "ABCDEFGHIJKLMNOPQRSTUVWX"},
+		{"ABCDEFGHIJKLMNOPQRSTUVW.XYZ", "ABCDEFGHIJKLMNOPQRSTUVW"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			if got := commodityName(tt.symbol); got != tt.want {
+				t.Errorf("commodityName(%q) = %q, want %q", tt.symbol, got, tt.want)
+			}
+		})
+	}
+}
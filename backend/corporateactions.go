@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Corporate action types. Ratio is always shares after per share before:
+// 2 for a 2-for-1 split, 0.1 for a 1-for-10 reverse split, 2 for a 1:1
+// bonus issue, and new-symbol units per old unit for mergers.
+const (
+	ActionSplit        = "split"
+	ActionBonus        = "bonus"
+	ActionMerger       = "merger" // includes AMFI scheme mergers
+	ActionSymbolChange = "symbol_change"
+)
+
+// CorporateAction is an event that changes what an investor holds. Provider
+// actions apply to every holder; manual ones only to the user who entered them.
+type CorporateAction struct {
+	ID        int                `json:"id"`
+	Manual    bool               `json:"manual"`
+	Symbol    string             `json:"symbol"`
+	Type      string             `json:"type"`
+	ExDate    string             `json:"exDate"`
+	Ratio     float64            `json:"ratio"`
+	NewSymbol string             `json:"newSymbol,omitempty"`
+	Source    string             `json:"source"`
+	Notes     string             `json:"notes"`
+	Applied   *ActionApplication `json:"applied,omitempty"`
+}
+
+// ActionApplication is the audit record of one action applied to a user.
+type ActionApplication struct {
+	QuantityBefore float64   `json:"quantityBefore"`
+	QuantityAfter  float64   `json:"quantityAfter"`
+	TxnIDs         []int     `json:"txnIds"`
+	Notes          string    `json:"notes"`
+	AppliedAt      time.Time `json:"appliedAt"`
+}
+
+func validateCorporateAction(a *CorporateAction) error {
+	a.Symbol = strings.TrimSpace(a.Symbol)
+	a.NewSymbol = strings.TrimSpace(a.NewSymbol)
+	a.Type = strings.ToLower(strings.TrimSpace(a.Type))
+	if a.Symbol == "" {
+		return fmt.Errorf("symbol is required")
+	}
+	if _, err := time.Parse(dateLayout, a.ExDate); err != nil {
+		return fmt.Errorf("exDate must be YYYY-MM-DD")
+	}
+	switch a.Type {
+	case ActionSplit, ActionBonus:
+		if a.Ratio <= 0 {
+			return fmt.Errorf("ratio must be positive")
+		}
+		a.NewSymbol = ""
+	case ActionSymbolChange:
+		a.Ratio = 1
+		fallthrough
+	case ActionMerger:
+		if a.Ratio <= 0 {
+			return fmt.Errorf("ratio must be positive")
+		}
+		if a.NewSymbol == "" || strings.EqualFold(a.NewSymbol, a.Symbol) {
+			return fmt.Errorf("%s needs a different newSymbol", a.Type)
+		}
+	default:
+		return fmt.Errorf("type must be one of split, bonus, merger, symbol_change")
+	}
+	return nil
+}
+
+// unadjustCloses converts split-adjusted closes back to as-traded prices
+// using the splits recorded in one symbol's ledger.
+func unadjustCloses(points []PricePoint, txns []Transaction) []PricePoint {
+	out := make([]PricePoint, len(points))
+	for i, p := range points {
+		out[i] = PricePoint{Time: p.Time, Close: p.Close * splitFactorAfter(txns, p.Time.Format(dateLayout))}
+	}
+	return out
+}
+
+// storeCorporateActions records provider actions, skipping ones already
+// known. A new split drops the stored closes before its ex-date so they are
+// fetched again split-adjusted rather than showing a fake crash on charts.
+func storeCorporateActions(ctx context.Context, q dbQuerier, actions []CorporateAction) (int, error) {
+	added := 0
+	for _, a := range actions {
+		tag, err := q.Exec(ctx, `
+			INSERT INTO corporate_actions (symbol, action_type, ex_date, ratio, new_symbol, source, notes)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)
+			ON CONFLICT (symbol, action_type, ex_date) WHERE user_id IS NULL DO NOTHING`,
+			a.Symbol, a.Type, a.ExDate, a.Ratio, a.NewSymbol, a.Source, a.Notes)
+		if err != nil {
+			return added, err
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+		added++
+		if a.Type == ActionSplit || a.Type == ActionBonus {
+			if _, err := q.Exec(ctx, "DELETE FROM price_history WHERE symbol=$1 AND date < $2", a.Symbol, a.ExDate); err != nil {
+				return added, err
+			}
+			if _, err := q.Exec(ctx, "DELETE FROM price_history_coverage WHERE symbol=$1", a.Symbol); err != nil {
+				return added, err
+			}
+		}
+	}
+	return added, nil
+}
+
+// pendingCorporateActions lists actions on symbols in the user's ledger that
+// have not been applied to the user yet, in ex-date order.
+func pendingCorporateActions(ctx context.Context, q dbQuerier, userID int) ([]CorporateAction, error) {
+	rows, err := q.Query(ctx, `
+		SELECT a.id, a.user_id IS NOT NULL, a.symbol, a.action_type, to_char(a.ex_date, 'YYYY-MM-DD'), a.ratio,
+			COALESCE(a.new_symbol, ''), a.source, COALESCE(a.notes, '')
+		FROM corporate_actions a
+		WHERE (a.user_id IS NULL OR a.user_id=$1)
+			AND a.symbol IN (SELECT DISTINCT symbol FROM transactions WHERE user_id=$1)
+			AND NOT EXISTS (SELECT 1 FROM corporate_action_log l WHERE l.action_id=a.id AND l.user_id=$1)
+		ORDER BY a.ex_date, a.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []CorporateAction
+	for rows.Next() {
+		var a CorporateAction
+		if err := rows.Scan(&a.ID, &a.Manual, &a.Symbol, &a.Type, &a.ExDate, &a.Ratio, &a.NewSymbol, &a.Source, &a.Notes); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// applyCorporateAction books one action into the user's ledger. Splits and
+// bonus issues become a split entry; mergers and symbol changes move each
+// open lot to the new symbol at its original cost. It reports false when the
+// user held nothing before the ex-date, leaving the action pending.
+func applyCorporateAction(ctx context.Context, q dbQuerier, userID int, a CorporateAction, settings UserSettings, selections map[int][]LotSelection) (bool, error) {
+	txns, err := loadTransactions(ctx, q, userID, a.Symbol)
+	if err != nil {
+		return false, err
+	}
+	var prior []Transaction
+	for _, t := range txns {
+		if t.Date < a.ExDate {
+			prior = append(prior, t)
+		}
+	}
+	open, _, err := matchLots(prior, settings.LotMethod, selections)
+	if err != nil {
+		return false, err
+	}
+	shares, cost := 0.0, 0.0
+	for _, lot := range open {
+		shares += lot.Quantity
+		cost += lot.Quantity * lot.CostPerUnit
+	}
+	if shares <= 1e-9 {
+		return false, nil
+	}
+
+	entry := ActionApplication{QuantityBefore: shares, QuantityAfter: shares * a.Ratio, TxnIDs: []int{}}
+	switch a.Type {
+	case ActionSplit, ActionBonus:
+		for _, t := range txns {
+			if t.Type == TxnSplit && t.Date == a.ExDate {
+				entry.Notes = "Split already recorded in the ledger"
+			}
+		}
+		if entry.Notes != "" {
+			break
+		}
+		split := Transaction{
+			UserID: userID, Symbol: a.Symbol, Type: TxnSplit, Date: a.ExDate, Quantity: a.Ratio,
+			Notes: fmt.Sprintf("%s %g:1 (%s)", a.Type, a.Ratio, a.Source),
+		}
+		if err := insertTransaction(ctx, q, &split, ""); err != nil {
+			return false, err
+		}
+		entry.TxnIDs = append(entry.TxnIDs, split.ID)
+
+	case ActionMerger, ActionSymbolChange:
+		var assetType, nickname, currency string
+		if err := q.QueryRow(ctx, "SELECT COALESCE(asset_type, ''), COALESCE(nickname, ''), currency FROM assets WHERE name=$1 AND user_id=$2 LIMIT 1", a.Symbol, userID).Scan(&assetType, &nickname, &currency); err != nil {
+			return false, err
+		}
+		if a.Type == ActionMerger {
+			nickname = ""
+		}
+		// The outgoing leg is priced at the cost the incoming lots carry, so
+		// the pair nets to zero as a cash flow and returns see no new money
+		out := Transaction{
+			UserID: userID, Symbol: a.Symbol, Type: TxnTransferOut, Date: a.ExDate, Quantity: shares,
+			Price: cost / shares, Currency: currency,
+			Notes: fmt.Sprintf("%s into %s", a.Type, a.NewSymbol),
+		}
+		if err := insertTransaction(ctx, q, &out, ""); err != nil {
+			return false, err
+		}
+		entry.TxnIDs = append(entry.TxnIDs, out.ID)
+		for _, lot := range open {
+			in := Transaction{
+				UserID: userID, Symbol: a.NewSymbol, Type: TxnTransferIn, Date: a.ExDate,
+				Quantity: lot.Quantity * a.Ratio, Price: lot.CostPerUnit / a.Ratio, AssetType: assetType, Currency: currency,
+				Notes: fmt.Sprintf("%s from %s, lot opened %s", a.Type, a.Symbol, lot.OpenDate),
+			}
+			if err := insertTransaction(ctx, q, &in, nickname); err != nil {
+				return false, err
+			}
+			entry.TxnIDs = append(entry.TxnIDs, in.ID)
+		}
+		// Rebalancing tag and dividend settings follow the holding
+		_, err := q.Exec(ctx, `
+			UPDATE assets n SET tag=COALESCE(n.tag, o.tag), drip=o.drip, withholding=o.withholding
+			FROM assets o WHERE n.user_id=$1 AND n.name=$2 AND o.user_id=$1 AND o.name=$3`,
+			userID, a.NewSymbol, a.Symbol)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO corporate_action_log (action_id, user_id, quantity_before, quantity_after, txn_ids, notes)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		a.ID, userID, entry.QuantityBefore, entry.QuantityAfter, entry.TxnIDs, entry.Notes)
+	return err == nil, err
+}
+
+// applyCorporateActions applies every pending action for the user in one
+// transaction. Mergers can bring new symbols into the ledger, so it repeats
+// until a pass applies nothing.
+func applyCorporateActions(ctx context.Context, dbPool *pgxpool.Pool, userID int) (int, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	settings, err := loadUserSettings(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	for {
+		pending, err := pendingCorporateActions(ctx, tx, userID)
+		if err != nil {
+			return 0, err
+		}
+		// Selections are reloaded each pass as applied actions add ledger entries
+		selections, err := loadLotSelections(ctx, tx, userID)
+		if err != nil {
+			return 0, err
+		}
+		progress := false
+		for _, a := range pending {
+			ok, err := applyCorporateAction(ctx, tx, userID, a, settings, selections)
+			if err != nil {
+				return 0, fmt.Errorf("%s %s on %s: %w", a.Type, a.Symbol, a.ExDate, err)
+			}
+			if ok {
+				applied++
+				progress = true
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+	return applied, tx.Commit(ctx)
+}
+
+// CorporateActionReport summarises one fetch-and-apply run.
+type CorporateActionReport struct {
+	Symbols int               `json:"symbols"`
+	New     int               `json:"new"`
+	Applied int               `json:"applied"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+var corporateActionMu sync.Mutex
+
+// syncCorporateActions fetches actions for every tracked symbol with a
+// provider, then applies anything pending to every user with a ledger.
+func syncCorporateActions(ctx context.Context, dbPool *pgxpool.Pool) (CorporateActionReport, error) {
+	corporateActionMu.Lock()
+	defer corporateActionMu.Unlock()
+
+	report := CorporateActionReport{Errors: map[string]string{}}
+	tracked, err := trackedSymbols(ctx, dbPool)
+	if err != nil {
+		return report, err
+	}
+	for symbol, first := range tracked {
+		if _, ok := market.actions[symbolScheme(symbol)]; !ok || isFXSymbol(symbol) {
+			continue
+		}
+		report.Symbols++
+		actions, err := market.CorporateActions(ctx, symbol, first.AddDate(0, 0, -1))
+		if err != nil {
+			report.Errors[symbol] = err.Error()
+			continue
+		}
+		added, err := storeCorporateActions(ctx, dbPool, actions)
+		report.New += added
+		if err != nil {
+			report.Errors[symbol] = err.Error()
+		}
+	}
+
+	rows, err := dbPool.Query(ctx, "SELECT DISTINCT user_id FROM transactions ORDER BY user_id")
+	if err != nil {
+		return report, err
+	}
+	var users []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return report, err
+		}
+		users = append(users, id)
+	}
+	rows.Close()
+
+	for _, userID := range users {
+		applied, err := applyCorporateActions(ctx, dbPool, userID)
+		if err != nil {
+			log.Printf("Corporate actions: user %d: %v", userID, err)
+			report.Errors[fmt.Sprintf("user %d", userID)] = err.Error()
+			continue
+		}
+		report.Applied += applied
+	}
+	return report, nil
+}
+
+// --- ROUTES ---
+
+func registerCorporateActionRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/corporate-actions?symbol= - Actions on the user's symbols and how each was applied
+	api.GET("/corporate-actions", func(c *gin.Context) {
+		userID := currentUserID(c)
+		rows, err := dbPool.Query(context.Background(), `
+			SELECT a.id, a.user_id IS NOT NULL, a.symbol, a.action_type, to_char(a.ex_date, 'YYYY-MM-DD'), a.ratio,
+				COALESCE(a.new_symbol, ''), a.source, COALESCE(a.notes, ''),
+				l.quantity_before, l.quantity_after, l.txn_ids, COALESCE(l.notes, ''), l.applied_at
+			FROM corporate_actions a
+			LEFT JOIN corporate_action_log l ON l.action_id=a.id AND l.user_id=$1
+			WHERE (a.user_id IS NULL OR a.user_id=$1)
+				AND a.symbol IN (SELECT DISTINCT symbol FROM transactions WHERE user_id=$1)
+				AND ($2 = '' OR a.symbol=$2)
+			ORDER BY a.ex_date DESC, a.id DESC`, userID, c.Query("symbol"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		defer rows.Close()
+
+		actions := []CorporateAction{}
+		for rows.Next() {
+			var a CorporateAction
+			var before, after *float64
+			var txnIDs []int
+			var notes string
+			var appliedAt *time.Time
+			if err := rows.Scan(&a.ID, &a.Manual, &a.Symbol, &a.Type, &a.ExDate, &a.Ratio, &a.NewSymbol, &a.Source, &a.Notes,
+				&before, &after, &txnIDs, &notes, &appliedAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+				return
+			}
+			if appliedAt != nil {
+				a.Applied = &ActionApplication{QuantityBefore: *before, QuantityAfter: *after, TxnIDs: txnIDs, Notes: notes, AppliedAt: *appliedAt}
+			}
+			actions = append(actions, a)
+		}
+		c.JSON(http.StatusOK, actions)
+	})
+
+	// POST /api/corporate-actions - Enter an action by hand and apply it to the user's holdings
+	api.POST("/corporate-actions", func(c *gin.Context) {
+		var a CorporateAction
+		if err := c.ShouldBindJSON(&a); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateCorporateAction(&a); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+		ctx := context.Background()
+
+		var held bool
+		if err := dbPool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM transactions WHERE user_id=$1 AND symbol=$2)", userID, a.Symbol).Scan(&held); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !held {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No transactions for " + a.Symbol})
+			return
+		}
+
+		a.Manual, a.Source = true, "manual"
+		err := dbPool.QueryRow(ctx, `
+			INSERT INTO corporate_actions (user_id, symbol, action_type, ex_date, ratio, new_symbol, source, notes)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8) RETURNING id`,
+			userID, a.Symbol, a.Type, a.ExDate, a.Ratio, a.NewSymbol, a.Source, a.Notes).Scan(&a.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save corporate action"})
+			return
+		}
+
+		applied, err := applyCorporateActions(ctx, dbPool, userID)
+		if err != nil {
+			// The action stays pending and is retried by the scheduler
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Saved but could not be applied: " + err.Error(), "action": a})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"action": a, "applied": applied})
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// minCorrelationObservations is the fewest paired returns we report a
+	// correlation for; below it the estimate is mostly noise.
+	minCorrelationObservations = 20
+
+	// redundantCorrelation flags pairs that move together closely enough
+	// that holding both adds little diversification.
+	redundantCorrelation = 0.9
+)
+
+// CorrelatedPair is one off-diagonal entry of the correlation matrix.
+type CorrelatedPair struct {
+	A            string  `json:"a"`
+	B            string  `json:"b"`
+	Correlation  float64 `json:"correlation"`
+	Observations int     `json:"observations"`
+	Redundant    bool    `json:"redundant"`
+}
+
+// weekEnds keeps the last close of each ISO week, which smooths over the
+// hours between exchanges' closes that make daily cross-market returns
+// look less correlated than they are.
+func weekEnds(points []PricePoint) []PricePoint {
+	var out []PricePoint
+	for i, p := range points {
+		y, w := p.Time.ISOWeek()
+		if i == len(points)-1 {
+			out = append(out, p)
+			break
+		}
+		ny, nw := points[i+1].Time.ISOWeek()
+		if ny != y || nw != w {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// alignedReturns pairs two price series on the dates both traded and returns
+// the simple returns between consecutive common dates. Holidays on either
+// calendar (NSE vs NYSE, or AMFI NAV days) are skipped rather than filled,
+// so a missing day never shows up as a zero return.
+func alignedReturns(a, b []PricePoint) ([]float64, []float64) {
+	byDay := make(map[time.Time]float64, len(b))
+	for _, p := range b {
+		byDay[p.Time] = p.Close
+	}
+	var la, lb []float64
+	for _, p := range a {
+		if bc, ok := byDay[p.Time]; ok && p.Close > 0 && bc > 0 {
+			la = append(la, p.Close)
+			lb = append(lb, bc)
+		}
+	}
+	return periodReturns(la), periodReturns(lb)
+}
+
+func correlation(xs, ys []float64) float64 {
+	sx, sy := stdDev(xs), stdDev(ys)
+	if sx == 0 || sy == 0 {
+		return 0
+	}
+	return covariance(xs, ys) / (sx * sy)
+}
+
+// --- ROUTES ---
+
+func registerCorrelationRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/analytics/correlation?range=1Y&frequency=daily|weekly&top=5 - Correlation matrix and diversification
+	api.GET("/analytics/correlation", func(c *gin.Context) {
+		ctx := context.Background()
+		req, ok := loadAnalyticsRequest(c, dbPool, "1Y")
+		if !ok {
+			return
+		}
+		frequency := c.DefaultQuery("frequency", "daily")
+		if frequency != "daily" && frequency != "weekly" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "frequency must be daily or weekly"})
+			return
+		}
+		top, err := strconv.Atoi(c.DefaultQuery("top", "5"))
+		if err != nil || top < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "top must be a non-negative number"})
+			return
+		}
+
+		rows, err := dbPool.Query(ctx,
+			"SELECT name, quantity * current_price, currency FROM assets WHERE user_id=$1 AND quantity > 0 ORDER BY name", currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		symbols := []string{}
+		values := map[string]float64{}
+		total := 0.0
+		for rows.Next() {
+			var symbol, currency string
+			var value float64
+			if err := rows.Scan(&symbol, &value, &currency); err != nil {
+				rows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if req.fx != nil {
+				value *= req.fx.rate(currency, req.today)
+			}
+			symbols = append(symbols, symbol)
+			values[symbol] = value
+			total += value
+		}
+		rows.Close()
+
+		series := map[string][]PricePoint{}
+		for _, s := range symbols {
+			ps, err := loadPriceHistory(ctx, dbPool, s, req.start.AddDate(0, 0, -1))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load price history"})
+				return
+			}
+			if frequency == "weekly" {
+				ps.Points = weekEnds(ps.Points)
+			}
+			series[s] = ps.Points
+		}
+
+		n := len(symbols)
+		matrix := make([][]*float64, n)
+		vols := make([]float64, n)
+		pairs := []CorrelatedPair{}
+		for i := range symbols {
+			matrix[i] = make([]*float64, n)
+			one := 1.0
+			matrix[i][i] = &one
+			closes := make([]float64, len(series[symbols[i]]))
+			for k, p := range series[symbols[i]] {
+				closes[k] = p.Close
+			}
+			vols[i] = stdDev(periodReturns(closes))
+		}
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				ri, rj := alignedReturns(series[symbols[i]], series[symbols[j]])
+				if len(ri) < minCorrelationObservations {
+					continue
+				}
+				rho := correlation(ri, rj)
+				matrix[i][j], matrix[j][i] = &rho, &rho
+				pairs = append(pairs, CorrelatedPair{A: symbols[i], B: symbols[j], Correlation: rho, Observations: len(ri), Redundant: rho >= redundantCorrelation})
+			}
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Correlation > pairs[j].Correlation })
+		if len(pairs) > top {
+			pairs = pairs[:top]
+		}
+
+		// Diversification ratio: weighted average volatility over portfolio
+		// volatility, with the covariance rebuilt from pairwise correlations.
+		// Pairs without enough overlap are treated as uncorrelated.
+		weights := map[string]float64{}
+		weightedVol, variance := 0.0, 0.0
+		for i, s := range symbols {
+			if total > 0 {
+				weights[s] = values[s] / total
+			}
+			weightedVol += weights[s] * vols[i]
+			for j, t := range symbols {
+				rho := 0.0
+				if matrix[i][j] != nil {
+					rho = *matrix[i][j]
+				}
+				variance += weights[s] * weights[t] * rho * vols[i] * vols[j]
+			}
+		}
+		var ratio *float64
+		if variance > 0 {
+			r := weightedVol / math.Sqrt(variance)
+			ratio = &r
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"range":                req.rng,
+			"frequency":            frequency,
+			"from":                 req.start.Format(dateLayout),
+			"to":                   req.today.Format(dateLayout),
+			"symbols":              symbols,
+			"matrix":               matrix,
+			"weights":              weights,
+			"diversificationRatio": ratio,
+			"topPairs":             pairs,
+		})
+	})
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// dividendLookback is how far back events are fetched for a symbol whose
+// first trade is recent, so forecasts still see a full trailing year.
+const dividendLookback = 400 * 24 * time.Hour
+
+func storeDividendEvents(ctx context.Context, q dbQuerier, events []DividendEvent) error {
+	for _, e := range events {
+		_, err := q.Exec(ctx, `
+			INSERT INTO dividend_events (symbol, ex_date, amount, currency, source) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (symbol, ex_date) DO UPDATE SET amount=EXCLUDED.amount, currency=EXCLUDED.currency, source=EXCLUDED.source`,
+			e.Symbol, e.ExDate, e.Amount, e.Currency, e.Source)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadDividendEvents returns stored events for the symbols with an ex-date
+// on or after from, oldest first.
+func loadDividendEvents(ctx context.Context, q dbQuerier, symbols []string, from string) ([]DividendEvent, error) {
+	rows, err := q.Query(ctx, `
+		SELECT symbol, to_char(ex_date, 'YYYY-MM-DD'), amount, currency, source
+		FROM dividend_events WHERE symbol = ANY($1) AND ex_date >= $2 ORDER BY ex_date, symbol`, symbols, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []DividendEvent
+	for rows.Next() {
+		var e DividendEvent
+		if err := rows.Scan(&e.Symbol, &e.ExDate, &e.Amount, &e.Currency, &e.Source); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// sharesBefore is the position at the close before date, which is what an
+// ex-date entitlement is based on. An inconsistent ledger counts as zero.
+func sharesBefore(txns []Transaction, date string) float64 {
+	var prior []Transaction
+	for _, t := range txns {
+		if t.Date < date {
+			prior = append(prior, t)
+		}
+	}
+	h, err := replayLedger(prior)
+	if err != nil {
+		return 0
+	}
+	return h.Quantity
+}
+
+// splitFactorAfter is the product of the ledger's split ratios dated after
+// date. Providers report history split-adjusted, so a close or per-share
+// dividend from before a split times this factor is the as-traded figure
+// that matches the ledger's quantity on that date.
+func splitFactorAfter(txns []Transaction, date string) float64 {
+	factor := 1.0
+	for _, t := range txns {
+		if t.Type == TxnSplit && t.Date > date {
+			factor *= t.Quantity
+		}
+	}
+	return factor
+}
+
+// DividendSyncReport summarises one import run.
+type DividendSyncReport struct {
+	Symbols    int               `json:"symbols"`
+	Events     int               `json:"events"`
+	Posted     int               `json:"posted"`
+	Reinvested int               `json:"reinvested"`
+	Errors     map[string]string `json:"errors,omitempty"`
+	// Reinvestments left undone for want of an ex-date close, as "SYMBOL EX-DATE"
+	Unreinvested []string `json:"unreinvested,omitempty"`
+}
+
+var dividendSyncMu sync.Mutex
+
+// syncDividends fetches distribution history for every tracked symbol with a
+// dividend provider and books new events into each holder's ledger.
+func syncDividends(ctx context.Context, dbPool *pgxpool.Pool) (DividendSyncReport, error) {
+	dividendSyncMu.Lock()
+	defer dividendSyncMu.Unlock()
+
+	report := DividendSyncReport{Errors: map[string]string{}}
+	tracked, err := trackedSymbols(ctx, dbPool)
+	if err != nil {
+		return report, err
+	}
+	earliest := time.Now().Add(-dividendLookback)
+	for symbol, first := range tracked {
+		if _, ok := market.dividends[symbolScheme(symbol)]; !ok || isFXSymbol(symbol) {
+			continue
+		}
+		report.Symbols++
+		from := first.AddDate(0, 0, -1)
+		if from.After(earliest) {
+			from = earliest
+		}
+		events, err := market.Dividends(ctx, symbol, from)
+		if err == nil {
+			err = storeDividendEvents(ctx, dbPool, events)
+		}
+		if err != nil {
+			report.Errors[symbol] = err.Error()
+			continue
+		}
+		report.Events += len(events)
+	}
+
+	rows, err := dbPool.Query(ctx, "SELECT DISTINCT user_id, symbol FROM transactions ORDER BY user_id, symbol")
+	if err != nil {
+		return report, err
+	}
+	type holder struct {
+		userID int
+		symbol string
+	}
+	var holders []holder
+	for rows.Next() {
+		var h holder
+		if err := rows.Scan(&h.userID, &h.symbol); err != nil {
+			rows.Close()
+			return report, err
+		}
+		holders = append(holders, h)
+	}
+	rows.Close()
+
+	for _, h := range holders {
+		res, err := postDividends(ctx, dbPool, h.userID, h.symbol)
+		if err != nil {
+			log.Printf("Dividends: posting %s for user %d failed: %v", h.symbol, h.userID, err)
+			report.Errors[h.symbol] = err.Error()
+			continue
+		}
+		report.Posted += res.posted
+		report.Reinvested += res.reinvested
+		report.Unreinvested = append(report.Unreinvested, res.unreinvested...)
+	}
+	return report, nil
+}
+
+// dividendPosting is what one postDividends run booked.
+type dividendPosting struct {
+	posted       int
+	reinvested   int
+	unreinvested []string
+}
+
+// dividendPayWindow is how many days after an ex-date a dividend entered by
+// hand or imported from a statement may be dated, since those usually carry
+// the pay date.
+const dividendPayWindow = 45
+
+// hasDividendFor reports whether the ledger already holds a dividend for the
+// event on exDate: one dated from the ex-date through dividendPayWindow days
+// later, and before next, the symbol's following ex-date (if any).
+func hasDividendFor(txns []Transaction, exDate, next string) bool {
+	d, err := time.Parse(dateLayout, exDate)
+	if err != nil {
+		return false
+	}
+	until := d.AddDate(0, 0, dividendPayWindow).Format(dateLayout)
+	for _, t := range txns {
+		if t.Type == TxnDividend && t.Date >= exDate && t.Date <= until && (next == "" || t.Date < next) {
+			return true
+		}
+	}
+	return false
+}
+
+// postDividends books every stored event the user was entitled to and has
+// not booked before. Each becomes a dividend entry whose Quantity is the
+// shares held, Price the amount per share and Fees the tax withheld at the
+// holding's rate, or the user's default when the holding has none. With
+// DRIP enabled on the holding the net amount is reinvested at the ex-date
+// close, as long as the dividend is paid in the holding's currency; events
+// with no stored close are booked as cash and reported as unreinvested.
+func postDividends(ctx context.Context, dbPool *pgxpool.Pool, userID int, symbol string) (dividendPosting, error) {
+	var res dividendPosting
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback(ctx)
+
+	txns, err := loadTransactions(ctx, tx, userID, symbol)
+	if err != nil || len(txns) == 0 {
+		return res, err
+	}
+	events, err := loadDividendEvents(ctx, tx, []string{symbol}, txns[0].Date)
+	if err != nil || len(events) == 0 {
+		return res, err
+	}
+	settings, err := loadUserSettings(ctx, tx, userID)
+	if err != nil {
+		return res, err
+	}
+	var drip bool
+	var holdingCurrency string
+	var withholding *float64
+	err = tx.QueryRow(ctx, "SELECT drip, currency, withholding FROM assets WHERE name=$1 AND user_id=$2 LIMIT 1", symbol, userID).Scan(&drip, &holdingCurrency, &withholding)
+	if err != nil {
+		return res, err
+	}
+	rate := settings.DividendWithholding
+	if withholding != nil {
+		rate = *withholding
+	}
+	var closes []PricePoint
+	if drip {
+		start, _ := time.Parse(dateLayout, events[0].ExDate)
+		series, err := loadPriceHistory(ctx, tx, symbol, start.AddDate(0, 0, -7))
+		if err != nil {
+			return res, err
+		}
+		closes = series.Points
+	}
+
+	for i, e := range events {
+		shares := sharesBefore(txns, e.ExDate)
+		if shares <= 0 {
+			// Not held on the ex-date; left unbooked in case earlier trades are added later
+			continue
+		}
+		next := ""
+		if i+1 < len(events) {
+			next = events[i+1].ExDate
+		}
+		if hasDividendFor(txns, e.ExDate, next) {
+			// Already recorded by hand or by a statement import
+			continue
+		}
+		tag, err := tx.Exec(ctx, "INSERT INTO dividend_postings (user_id, symbol, ex_date) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", userID, symbol, e.ExDate)
+		if err != nil {
+			return res, err
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+
+		// Provider amounts are adjusted for later splits; the ledger's shares are not
+		perShare := e.Amount * splitFactorAfter(txns, e.ExDate)
+		gross := shares * perShare
+		div := Transaction{
+			UserID:   userID,
+			Symbol:   symbol,
+			Type:     TxnDividend,
+			Date:     e.ExDate,
+			Quantity: shares,
+			Price:    perShare,
+			Fees:     gross * rate / 100,
+			Currency: e.Currency,
+			Notes:    fmt.Sprintf("Dividend %g/share (%s)", perShare, e.Source),
+		}
+		if err := insertTransaction(ctx, tx, &div, ""); err != nil {
+			return res, err
+		}
+		txns = append(txns, div)
+		sort.SliceStable(txns, func(i, j int) bool { return txns[i].Date < txns[j].Date })
+		res.posted++
+
+		if !drip || normalizeCurrency(e.Currency) != normalizeCurrency(holdingCurrency) {
+			continue
+		}
+		exDate, _ := time.Parse(dateLayout, e.ExDate)
+		price, ok := closeOn(closes, exDate)
+		price *= splitFactorAfter(txns, e.ExDate)
+		if !ok || price <= 0 {
+			// Today's price would misstate the units bought, so leave it as cash
+			res.unreinvested = append(res.unreinvested, symbol+" "+e.ExDate)
+			continue
+		}
+		buy := Transaction{
+			UserID:   userID,
+			Symbol:   symbol,
+			Type:     TxnBuy,
+			Date:     e.ExDate,
+			Quantity: (gross - div.Fees) / price,
+			Price:    price,
+			Currency: holdingCurrency,
+			Notes:    "DRIP reinvestment",
+		}
+		if err := insertTransaction(ctx, tx, &buy, ""); err != nil {
+			return res, err
+		}
+		txns = append(txns, buy)
+		sort.SliceStable(txns, func(i, j int) bool { return txns[i].Date < txns[j].Date })
+		res.reinvested++
+	}
+
+	return res, tx.Commit(ctx)
+}
+
+// DividendIncome is gross, withheld and net distributions for one period or
+// symbol in the reporting currency.
+type DividendIncome struct {
+	Key         string  `json:"key"`
+	Gross       float64 `json:"gross"`
+	Withholding float64 `json:"withholding"`
+	Net         float64 `json:"net"`
+	Payments    int     `json:"payments"`
+}
+
+func (d *DividendIncome) add(gross, withheld float64) {
+	d.Gross += gross
+	d.Withholding += withheld
+	d.Net += gross - withheld
+	d.Payments++
+}
+
+func sortedIncome(m map[string]*DividendIncome, less func(a, b *DividendIncome) bool) []*DividendIncome {
+	out := make([]*DividendIncome, 0, len(m))
+	for _, d := range m {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return less(out[i], out[j]) })
+	return out
+}
+
+// ForecastHolding is one holding's expected distributions over the next year.
+type ForecastHolding struct {
+	Symbol        string  `json:"symbol"`
+	Quantity      float64 `json:"quantity"`
+	Currency      string  `json:"currency"`
+	PerShare      float64 `json:"perShare"` // trailing 12 months, native currency
+	Yield         float64 `json:"yield"`    // trailing per-share / current price
+	Gross         float64 `json:"gross"`    // reporting currency
+	Net           float64 `json:"net"`
+	Distributions int     `json:"distributions"`
+	Source        string  `json:"source"` // provider or ledger
+}
+
+// --- ROUTES ---
+
+func registerDividendRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/dividends/income?period=month|year&currency= - Distributions received, grouped by period and symbol
+	api.GET("/dividends/income", func(c *gin.Context) {
+		ctx := context.Background()
+		userID := currentUserID(c)
+
+		period := c.DefaultQuery("period", "month")
+		keyLen := map[string]int{"month": 7, "year": 4}[period]
+		if keyLen == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "period must be month or year"})
+			return
+		}
+		settings, err := loadUserSettings(ctx, dbPool, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		base := settings.BaseCurrency
+		if override := strings.ToUpper(c.Query("currency")); override != "" {
+			if !validCurrencyCode(override) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a three-letter currency code"})
+				return
+			}
+			base = override
+		}
+
+		txns, err := loadTransactions(ctx, dbPool, userID, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		fx := newFXConverter(ctx, dbPool, base)
+		yearAgo := time.Now().AddDate(-1, 0, 0).Format(dateLayout)
+		total := &DividendIncome{Key: "total"}
+		trailing := &DividendIncome{Key: "trailing12m"}
+		byPeriod := map[string]*DividendIncome{}
+		bySymbol := map[string]*DividendIncome{}
+		for _, t := range txns {
+			if t.Type != TxnDividend {
+				continue
+			}
+			rate, ok := fx.on(normalizeCurrency(t.Currency), t.Date)
+			if !ok {
+				continue
+			}
+			gross, withheld := cashAmount(t)*rate, t.Fees*rate
+			key := t.Date[:keyLen]
+			if byPeriod[key] == nil {
+				byPeriod[key] = &DividendIncome{Key: key}
+			}
+			if bySymbol[t.Symbol] == nil {
+				bySymbol[t.Symbol] = &DividendIncome{Key: t.Symbol}
+			}
+			byPeriod[key].add(gross, withheld)
+			bySymbol[t.Symbol].add(gross, withheld)
+			total.add(gross, withheld)
+			if t.Date > yearAgo {
+				trailing.add(gross, withheld)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"baseCurrency": base,
+			"period":       period,
+			"total":        total,
+			"trailing12m":  trailing,
+			"periods":      sortedIncome(byPeriod, func(a, b *DividendIncome) bool { return a.Key < b.Key }),
+			"bySymbol":     sortedIncome(bySymbol, func(a, b *DividendIncome) bool { return a.Net > b.Net }),
+			"missingRates": fx.missingRates(),
+		})
+	})
+
+	// GET /api/dividends/forecast?currency= - Next 12 months of income, projected from trailing distributions
+	api.GET("/dividends/forecast", func(c *gin.Context) {
+		ctx := context.Background()
+		userID := currentUserID(c)
+
+		settings, err := loadUserSettings(ctx, dbPool, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		base := settings.BaseCurrency
+		if override := strings.ToUpper(c.Query("currency")); override != "" {
+			if !validCurrencyCode(override) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a three-letter currency code"})
+				return
+			}
+			base = override
+		}
+
+		rows, err := dbPool.Query(ctx, "SELECT name, quantity, current_price, currency, withholding FROM assets WHERE user_id=$1 AND quantity > 0 ORDER BY name", userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		holdings := map[string]*ForecastHolding{}
+		prices := map[string]float64{}
+		withholding := map[string]float64{}
+		var symbols []string
+		for rows.Next() {
+			h := &ForecastHolding{}
+			var price float64
+			var rate *float64
+			if err := rows.Scan(&h.Symbol, &h.Quantity, &price, &h.Currency, &rate); err != nil {
+				rows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			holdings[h.Symbol] = h
+			prices[h.Symbol] = price
+			withholding[h.Symbol] = settings.DividendWithholding
+			if rate != nil {
+				withholding[h.Symbol] = *rate
+			}
+			symbols = append(symbols, h.Symbol)
+		}
+		rows.Close()
+
+		yearAgo := time.Now().AddDate(-1, 0, 0).Format(dateLayout)
+		events, err := loadDividendEvents(ctx, dbPool, symbols, yearAgo)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		// Symbols the provider has nothing for (e.g. mutual fund IDCW) are
+		// projected from the user's own recorded dividends instead
+		fromProvider := map[string]bool{}
+		for _, e := range events {
+			fromProvider[e.Symbol] = true
+		}
+		txns, err := loadTransactions(ctx, dbPool, userID, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		for _, t := range txns {
+			h := holdings[t.Symbol]
+			if t.Type != TxnDividend || h == nil || fromProvider[t.Symbol] || t.Date <= yearAgo {
+				continue
+			}
+			perShare := t.Price
+			if t.Quantity == 0 {
+				perShare = t.Price / h.Quantity
+			}
+			events = append(events, DividendEvent{Symbol: t.Symbol, ExDate: t.Date, Amount: perShare, Currency: t.Currency, Source: "ledger"})
+		}
+
+		fx := newFXConverter(ctx, dbPool, base)
+		byMonth := map[string]*DividendIncome{}
+		total := &DividendIncome{Key: "total"}
+		for _, e := range events {
+			h := holdings[e.Symbol]
+			rate, ok := fx.now(normalizeCurrency(e.Currency))
+			if !ok {
+				continue
+			}
+			exDate, err := time.Parse(dateLayout, e.ExDate)
+			if err != nil {
+				continue
+			}
+			gross := e.Amount * h.Quantity * rate
+			withheld := gross * withholding[e.Symbol] / 100
+			month := exDate.AddDate(1, 0, 0).Format("2006-01")
+			if byMonth[month] == nil {
+				byMonth[month] = &DividendIncome{Key: month}
+			}
+			byMonth[month].add(gross, withheld)
+			total.add(gross, withheld)
+
+			h.PerShare += e.Amount
+			h.Gross += gross
+			h.Net += gross - withheld
+			h.Distributions++
+			h.Source = e.Source
+		}
+
+		forecast := []*ForecastHolding{}
+		for _, s := range symbols {
+			h := holdings[s]
+			if h.Distributions == 0 {
+				continue
+			}
+			if prices[s] > 0 {
+				h.Yield = h.PerShare / prices[s]
+			}
+			forecast = append(forecast, h)
+		}
+		sort.Slice(forecast, func(i, j int) bool { return forecast[i].Net > forecast[j].Net })
+
+		c.JSON(http.StatusOK, gin.H{
+			"baseCurrency": base,
+			"withholding":  settings.DividendWithholding,
+			"total":        total,
+			"months":       sortedIncome(byMonth, func(a, b *DividendIncome) bool { return a.Key < b.Key }),
+			"holdings":     forecast,
+			"missingRates": fx.missingRates(),
+		})
+	})
+}
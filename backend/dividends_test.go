@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestSplitFactorAfter(t *testing.T) {
+	txns := []Transaction{
+		{Type: TxnBuy, Date: "2022-06-01", Quantity: 10, Price: 100},
+		{Type: TxnSplit, Date: "2023-06-01", Quantity: 2},
+		{Type: TxnDividend, Date: "2023-09-01", Quantity: 20, Price: 1},
+		{Type: TxnSplit, Date: "2024-01-01", Quantity: 5},
+	}
+	tests := []struct {
+		name string
+		txns []Transaction
+		date string
+		want float64
+	}{
+		{name: "before both splits", txns: txns, date: "2023-01-01", want: 10},
+		{name: "on a split date", txns: txns, date: "2023-06-01", want: 5},
+		{name: "between splits", txns: txns, date: "2023-09-01", want: 5},
+		{name: "after every split", txns: txns, date: "2024-02-01", want: 1},
+		{name: "reverse split", txns: []Transaction{{Type: TxnSplit, Date: "2024-01-01", Quantity: 0.1}}, date: "2023-01-01", want: 0.1},
+		{name: "no splits", txns: txns[:1], date: "2020-01-01", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitFactorAfter(tt.txns, tt.date); got != tt.want {
+				t.Errorf("splitFactorAfter(%s) = %g, want %g", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasDividendFor(t *testing.T) {
+	buy := Transaction{Type: TxnBuy, Date: "2024-01-02", Quantity: 10, Price: 100}
+	div := func(date string) []Transaction {
+		return []Transaction{buy, {Type: TxnDividend, Date: date, Quantity: 10, Price: 0.5}}
+	}
+	tests := []struct {
+		name         string
+		txns         []Transaction
+		exDate, next string
+		want         bool
+	}{
+		{name: "posted on the ex-date", txns: div("2024-02-09"), exDate: "2024-02-09", want: true},
+		{name: "imported on the pay date", txns: div("2024-02-15"), exDate: "2024-02-09", next: "2024-05-10", want: true},
+		{name: "last day of the window", txns: div("2024-03-25"), exDate: "2024-02-09", want: true},
+		{name: "past the window", txns: div("2024-03-26"), exDate: "2024-02-09", want: false},
+		{name: "before the ex-date", txns: div("2024-02-08"), exDate: "2024-02-09", want: false},
+		{name: "on the next ex-date", txns: div("2024-03-01"), exDate: "2024-02-09", next: "2024-03-01", want: false},
+		{name: "no dividend", txns: []Transaction{buy}, exDate: "2024-02-09", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasDividendFor(tt.txns, tt.exDate, tt.next); got != tt.want {
+				t.Errorf("hasDividendFor(%s, %q) = %t, want %t", tt.exDate, tt.next, got, tt.want)
+			}
+		})
+	}
+}
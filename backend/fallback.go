@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	breakerFailureThreshold = 5
+	breakerCooldown         = 2 * time.Minute
+)
+
+// ProviderStatus is the public health snapshot of one provider.
+type ProviderStatus struct {
+	Name                string    `json:"name"`
+	State               string    `json:"state"` // closed, open or half-open
+	Successes           int64     `json:"successes"`
+	Failures            int64     `json:"failures"`
+	SuccessRate         float64   `json:"successRate"`
+	AvgLatencyMs        float64   `json:"avgLatencyMs"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastSuccess         time.Time `json:"lastSuccess,omitempty"`
+	LastFailure         time.Time `json:"lastFailure,omitempty"`
+	OpenUntil           time.Time `json:"openUntil,omitempty"`
+}
+
+// providerHealth tracks call outcomes and acts as a circuit breaker: after
+// breakerFailureThreshold consecutive failures the provider is skipped until
+// the cooldown passes, then a single trial call is let through (half-open).
+type providerHealth struct {
+	mu                  sync.Mutex
+	name                string
+	successes           int64
+	failures            int64
+	totalLatency        time.Duration
+	consecutiveFailures int
+	lastError           string
+	lastSuccess         time.Time
+	lastFailure         time.Time
+	openUntil           time.Time
+	trialInFlight       bool
+}
+
+func (h *providerHealth) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.consecutiveFailures < breakerFailureThreshold {
+		return true
+	}
+	if time.Now().Before(h.openUntil) || h.trialInFlight {
+		return false
+	}
+	h.trialInFlight = true
+	return true
+}
+
+// record counts one call. Lookup errors (symbol not covered or not found)
+// are neither successes nor failures; they only end a half-open trial.
+func (h *providerHealth) record(latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.trialInFlight = false
+	if isLookupError(err) {
+		return
+	}
+	h.totalLatency += latency
+	if err == nil {
+		h.successes++
+		h.consecutiveFailures = 0
+		h.lastSuccess = time.Now()
+		return
+	}
+
+	h.failures++
+	h.consecutiveFailures++
+	h.lastError = err.Error()
+	h.lastFailure = time.Now()
+	if h.consecutiveFailures >= breakerFailureThreshold {
+		if h.consecutiveFailures == breakerFailureThreshold {
+			log.Printf("Provider %s circuit opened after %d failures: %v", h.name, h.consecutiveFailures, err)
+		}
+		h.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+func (h *providerHealth) status() ProviderStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := ProviderStatus{
+		Name: h.name, State: "closed",
+		Successes: h.successes, Failures: h.failures,
+		ConsecutiveFailures: h.consecutiveFailures, LastError: h.lastError,
+		LastSuccess: h.lastSuccess, LastFailure: h.lastFailure,
+	}
+	if total := h.successes + h.failures; total > 0 {
+		s.SuccessRate = float64(h.successes) / float64(total)
+		s.AvgLatencyMs = float64(h.totalLatency.Milliseconds()) / float64(total)
+	}
+	if h.consecutiveFailures >= breakerFailureThreshold {
+		s.State = "half-open"
+		if time.Now().Before(h.openUntil) {
+			s.State = "open"
+			s.OpenUntil = h.openUntil
+		}
+	}
+	return s
+}
+
+// healthRegistry hands out one providerHealth per provider name.
+type healthRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*providerHealth
+}
+
+func (r *healthRegistry) get(name string) *providerHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.entries == nil {
+		r.entries = map[string]*providerHealth{}
+	}
+	h, ok := r.entries[name]
+	if !ok {
+		h = &providerHealth{name: name}
+		r.entries[name] = h
+	}
+	return h
+}
+
+func (r *healthRegistry) statuses() []ProviderStatus {
+	r.mu.Lock()
+	entries := make([]*providerHealth, 0, len(r.entries))
+	for _, h := range r.entries {
+		entries = append(entries, h)
+	}
+	r.mu.Unlock()
+
+	out := make([]ProviderStatus, 0, len(entries))
+	for _, h := range entries {
+		out = append(out, h.status())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// FallbackQuotes tries each provider in order, skipping those whose breaker
+// is open, and returns the first successful quote. Calls are paced by the
+// provider's rate limiter, if any. Successful live quotes are remembered in
+// the last-known store so it can serve as the final fallback.
+type FallbackQuotes struct {
+	chain  []QuoteProvider
+	health *healthRegistry
+	limits map[string]*rateLimiter
+	store  *LastKnownProvider
+}
+
+func (f *FallbackQuotes) Name() string {
+	names := make([]string, len(f.chain))
+	for i, p := range f.chain {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ">")
+}
+
+func (f *FallbackQuotes) Quote(ctx context.Context, symbol string) (Quote, error) {
+	var errs []error
+	for _, p := range f.chain {
+		h := f.health.get(p.Name())
+		if !h.allow() {
+			errs = append(errs, fmt.Errorf("%s: circuit open", p.Name()))
+			continue
+		}
+
+		if err := f.limits[p.Name()].Wait(ctx); err != nil {
+			h.record(0, err)
+			return Quote{}, err
+		}
+		start := time.Now()
+		q, err := p.Quote(ctx, symbol)
+		if err == nil && q.Price <= 0 {
+			err = lookupErrorf("no price returned")
+		}
+		h.record(time.Since(start), err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		}
+
+		if f.store != nil && p != QuoteProvider(f.store) {
+			f.store.Remember(ctx, q)
+		}
+		return q, nil
+	}
+	return Quote{}, errors.Join(errs...)
+}
+
+// parseFallbackConfig reads chains like
+// "default=yahoo,stooq,lastknown;FX=yahoo,lastknown;AMFI=amfi,lastknown".
+// The key is a symbol scheme ("default" for unprefixed symbols) or "FX".
+func parseFallbackConfig(cfg string) map[string][]string {
+	chains := map[string][]string{}
+	for _, part := range strings.Split(cfg, ";") {
+		key, list, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if key == "DEFAULT" {
+			key = ""
+		}
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				chains[key] = append(chains[key], name)
+			}
+		}
+	}
+	return chains
+}
+
+func isFXSymbol(symbol string) bool {
+	return strings.HasSuffix(symbol, "=X")
+}
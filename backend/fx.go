@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// fx_rates stores every rate against USD (base "USD", quote "INR" means INR
+// per USD, the same orientation as Yahoo's "INR=X"). Any other pair is the
+// cross of two USD legs.
+const fxPivot = "USD"
+
+// defaultRateCurrencies are always served by /api/rates, since the frontend
+// currency selector offers them even before the user holds anything.
+var defaultRateCurrencies = []string{"USD", "INR", "SGD"}
+
+var errNoFXRate = errors.New("no FX rate available")
+
+func fxSymbol(currency string) string {
+	return currency + "=X"
+}
+
+// minorUnits are currencies quoted in hundredths of another, as Yahoo does
+// for London (GBp) and Johannesburg (ZAc) listings. Their rates are derived
+// from the major currency's.
+var minorUnits = map[string]struct {
+	major string
+	per   float64
+}{
+	"GBX": {"GBP", 100},
+	"ZAC": {"ZAR", 100},
+	"ILA": {"ILS", 100},
+}
+
+// normalizeCurrency upper-cases a currency code, except that Yahoo's
+// minor-unit spellings (GBp, ZAc) become GBX and ZAC rather than colliding
+// with their major currencies.
+func normalizeCurrency(currency string) string {
+	switch currency = strings.TrimSpace(currency); currency {
+	case "GBp":
+		return "GBX"
+	case "ZAc":
+		return "ZAC"
+	}
+	return strings.ToUpper(currency)
+}
+
+// majorCurrency returns the currency whose FX rates apply to currency and
+// how many units of currency make one of it.
+func majorCurrency(currency string) (string, float64) {
+	if m, ok := minorUnits[currency]; ok {
+		return m.major, m.per
+	}
+	return currency, 1
+}
+
+// storedCurrency determines a symbol's trading currency without calling a
+// provider: from what we already store for it, else the exchange registry.
+func storedCurrency(ctx context.Context, q dbQuerier, symbol string) string {
+	var currency string
+	err := q.QueryRow(ctx, `
+		SELECT currency FROM last_quotes WHERE symbol=$1 AND currency <> ''
+		UNION ALL
+		SELECT currency FROM price_history WHERE symbol=$1 AND currency <> ''
+		LIMIT 1`, symbol).Scan(&currency)
+	if err == nil {
+		return normalizeCurrency(currency)
+	}
+	if e := exchangeForSymbol(symbol); e != nil && e.Currency != "" {
+		return e.Currency
+	}
+	return fxPivot
+}
+
+// resolveCurrency determines the currency of a user's trade in symbol: the
+// holding's currency if they have one, else what we store for the symbol,
+// else the provider's quote metadata, else the exchange registry. It may make
+// a live provider call, so resolve before opening a database transaction.
+func resolveCurrency(ctx context.Context, q dbQuerier, userID int, symbol string) string {
+	var currency string
+	err := q.QueryRow(ctx, `
+		SELECT currency FROM assets WHERE name=$1 AND user_id=$2 AND currency <> ''
+		UNION ALL
+		SELECT currency FROM last_quotes WHERE symbol=$1 AND currency <> ''
+		UNION ALL
+		SELECT currency FROM price_history WHERE symbol=$1 AND currency <> ''
+		LIMIT 1`, symbol, userID).Scan(&currency)
+	if err == nil {
+		return normalizeCurrency(currency)
+	}
+
+	quoteCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if quote, err := market.Quote(quoteCtx, symbol); err == nil && quote.Currency != "" {
+		return normalizeCurrency(quote.Currency)
+	}
+	return storedCurrency(ctx, q, symbol)
+}
+
+// heldCurrencies lists every non-USD currency whose rates a holding, the
+// ledger or a reporting currency needs, with the earliest date it is needed
+// from. Minor units count as their major currency. A reporting currency is
+// needed from its user's first trade, since cost basis is converted at
+// trade-date rates.
+func heldCurrencies(ctx context.Context, q dbQuerier) (map[string]time.Time, error) {
+	rows, err := q.Query(ctx, `
+		SELECT c, MIN(first) FROM (
+			SELECT currency AS c, CURRENT_DATE AS first FROM assets
+			UNION ALL
+			SELECT currency, MIN(trade_date) FROM transactions GROUP BY currency
+			UNION ALL
+			SELECT s.base_currency, COALESCE((SELECT MIN(trade_date) FROM transactions t WHERE t.user_id = s.user_id), CURRENT_DATE)
+			FROM user_settings s
+		) u
+		WHERE c <> '' AND c <> $1
+		GROUP BY c`, fxPivot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	currencies := map[string]time.Time{}
+	for rows.Next() {
+		var c string
+		var first time.Time
+		if err := rows.Scan(&c, &first); err != nil {
+			return nil, err
+		}
+		major, _ := majorCurrency(normalizeCurrency(c))
+		if prev, ok := currencies[major]; major == fxPivot || (ok && prev.Before(first)) {
+			continue
+		}
+		currencies[major] = first
+	}
+	return currencies, rows.Err()
+}
+
+// syncFXRates backfills daily USD rates for every held currency from its
+// earliest trade (at least minDays back) and records today's live rate.
+// Daily closes go through price_history like any other symbol, so coverage
+// tracking keeps repeat runs cheap.
+func syncFXRates(ctx context.Context, dbPool *pgxpool.Pool, minDays int) error {
+	currencies, err := heldCurrencies(ctx, dbPool)
+	if err != nil {
+		return err
+	}
+	for _, c := range defaultRateCurrencies {
+		if _, ok := currencies[c]; !ok && c != fxPivot {
+			currencies[c] = time.Now().UTC()
+		}
+	}
+
+	var failed []string
+	for currency, firstTrade := range currencies {
+		from := time.Now().UTC().AddDate(0, 0, -minDays)
+		if firstTrade.Before(from) {
+			from = firstTrade.AddDate(0, 0, -7)
+		}
+		if err := syncFXCurrency(ctx, dbPool, currency, from); err != nil {
+			log.Printf("FX sync %s failed: %v", currency, err)
+			failed = append(failed, currency)
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("FX sync failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func syncFXCurrency(ctx context.Context, dbPool *pgxpool.Pool, currency string, from time.Time) error {
+	symbol := fxSymbol(currency)
+	reqCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if err := ensurePriceHistory(reqCtx, dbPool, symbol, from); err != nil {
+		return err
+	}
+	_, err := dbPool.Exec(ctx, `
+		INSERT INTO fx_rates (date, base, quote, rate, source)
+		SELECT date, $2, $3, close, source FROM price_history WHERE symbol=$1 AND date >= $4 AND close > 0
+		ON CONFLICT (date, base, quote) DO UPDATE SET rate=EXCLUDED.rate, source=EXCLUDED.source`,
+		symbol, fxPivot, currency, from)
+	if err != nil {
+		return err
+	}
+
+	if q, err := market.Quote(reqCtx, symbol); err == nil && q.Price > 0 {
+		return storeFXRate(ctx, dbPool, time.Now().UTC(), currency, q.Price, q.Source)
+	}
+	return nil
+}
+
+func storeFXRate(ctx context.Context, q dbQuerier, date time.Time, currency string, rate float64, source string) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO fx_rates (date, base, quote, rate, source) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (date, base, quote) DO UPDATE SET rate=EXCLUDED.rate, source=EXCLUDED.source`,
+		date.Format(dateLayout), fxPivot, currency, rate, source)
+	return err
+}
+
+// usdRate returns units of currency per USD on date, using the latest stored
+// rate on or before it. Dates before the first stored rate use the earliest
+// one, so old trades still convert when history starts late.
+func usdRate(ctx context.Context, q dbQuerier, currency string, date time.Time) (float64, error) {
+	currency, per := majorCurrency(normalizeCurrency(currency))
+	if currency == fxPivot || currency == "" {
+		return per, nil
+	}
+	var rate float64
+	err := q.QueryRow(ctx, `
+		(SELECT rate FROM fx_rates WHERE base=$1 AND quote=$2 AND date <= $3 ORDER BY date DESC LIMIT 1)
+		UNION ALL
+		(SELECT rate FROM fx_rates WHERE base=$1 AND quote=$2 ORDER BY date LIMIT 1)
+		LIMIT 1`, fxPivot, currency, date.Format(dateLayout)).Scan(&rate)
+	if err == pgx.ErrNoRows {
+		return 0, fmt.Errorf("%w for %s", errNoFXRate, currency)
+	}
+	return rate * per, err
+}
+
+// fxRateOn converts one unit of from into to using the rates of date. It is
+// what cost basis uses, so a purchase is valued at the rate of its trade date.
+func fxRateOn(ctx context.Context, q dbQuerier, from, to string, date time.Time) (float64, error) {
+	if normalizeCurrency(from) == normalizeCurrency(to) {
+		return 1, nil
+	}
+	fromRate, err := usdRate(ctx, q, from, date)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := usdRate(ctx, q, to, date)
+	if err != nil {
+		return 0, err
+	}
+	return toRate / fromRate, nil
+}
+
+// liveUSDRate prefers a live quote and falls back to the stored rate.
+func liveUSDRate(ctx context.Context, q dbQuerier, currency string) (float64, error) {
+	major, per := majorCurrency(normalizeCurrency(currency))
+	if major == fxPivot {
+		return per, nil
+	}
+	if quote, err := market.Quote(ctx, fxSymbol(major)); err == nil && quote.Price > 0 {
+		return quote.Price * per, nil
+	}
+	return usdRate(ctx, q, currency, time.Now().UTC())
+}
+
+// fxTable keeps stored USD rates for a set of currencies in memory, so long
+// daily series can be converted without a query per day. Currencies with no
+// stored history fall back to a single live rate; ones with neither are
+// listed in missing.
+type fxTable struct {
+	to      string
+	rates   map[string][]PricePoint
+	missing []string
+}
+
+func loadFXTable(ctx context.Context, q dbQuerier, currencies []string, to string) (*fxTable, error) {
+	t := &fxTable{to: normalizeCurrency(to), rates: map[string][]PricePoint{}}
+	all := append(append([]string{}, currencies...), to)
+	for _, cur := range all {
+		cur = normalizeCurrency(cur)
+		if _, done := t.rates[cur]; done || cur == fxPivot {
+			continue
+		}
+		major, per := majorCurrency(cur)
+		rows, err := q.Query(ctx, "SELECT date, rate FROM fx_rates WHERE base=$1 AND quote=$2 ORDER BY date", fxPivot, major)
+		if err != nil {
+			return nil, err
+		}
+		points := []PricePoint{}
+		for rows.Next() {
+			var p PricePoint
+			if err := rows.Scan(&p.Time, &p.Close); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			p.Close *= per
+			points = append(points, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		if len(points) == 0 {
+			if rate, err := liveUSDRate(ctx, q, cur); err == nil && rate > 0 {
+				points = append(points, PricePoint{Time: time.Now().UTC(), Close: rate})
+			} else {
+				t.missing = append(t.missing, cur)
+			}
+		}
+		t.rates[cur] = points
+	}
+	sort.Strings(t.missing)
+	return t, nil
+}
+
+func (t *fxTable) usd(currency string, date time.Time) (float64, bool) {
+	currency = normalizeCurrency(currency)
+	if currency == fxPivot || currency == "" {
+		return 1, true
+	}
+	points := t.rates[currency]
+	if len(points) == 0 {
+		return 0, false
+	}
+	if p, ok := closeOn(points, date); ok {
+		return p, true
+	}
+	return points[0].Close, true
+}
+
+// rate converts one unit of currency into the table's target currency on
+// date; 0 when either leg has no rate.
+func (t *fxTable) rate(currency string, date time.Time) float64 {
+	if normalizeCurrency(currency) == t.to {
+		return 1
+	}
+	from, ok1 := t.usd(currency, date)
+	to, ok2 := t.usd(t.to, date)
+	if !ok1 || !ok2 || from <= 0 {
+		return 0
+	}
+	return to / from
+}
+
+// closeOn returns the last close on or before date from a chronological series.
+func closeOn(points []PricePoint, date time.Time) (float64, bool) {
+	i := sort.Search(len(points), func(i int) bool { return points[i].Time.After(date) })
+	if i == 0 {
+		return 0, false
+	}
+	return points[i-1].Close, true
+}
+
+// --- ROUTES ---
+
+// userRateCurrencies is what /api/rates serves a user by default: the
+// defaults, the currencies of their own holdings and ledger, and their
+// reporting currency.
+func userRateCurrencies(ctx context.Context, q dbQuerier, userID int) (map[string]bool, error) {
+	allowed := map[string]bool{fxPivot: true}
+	for _, cur := range defaultRateCurrencies {
+		allowed[cur] = true
+	}
+	rows, err := q.Query(ctx, `
+		SELECT currency FROM assets WHERE user_id=$1
+		UNION SELECT currency FROM transactions WHERE user_id=$1
+		UNION SELECT base_currency FROM user_settings WHERE user_id=$1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cur string
+		if err := rows.Scan(&cur); err != nil {
+			return nil, err
+		}
+		if cur != "" {
+			allowed[normalizeCurrency(cur)] = true
+		}
+	}
+	return allowed, rows.Err()
+}
+
+// storedRateCurrency reports whether the FX sync keeps rates for currency.
+// Only such currencies may be requested beyond the user's own, so a request
+// never triggers an upstream call for an arbitrary code.
+func storedRateCurrency(ctx context.Context, q dbQuerier, currency string) bool {
+	major, _ := majorCurrency(currency)
+	var exists bool
+	q.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM fx_rates WHERE base=$1 AND quote=$2)", fxPivot, major).Scan(&exists)
+	return exists
+}
+
+func registerFXRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/rates?base=USD&date=YYYY-MM-DD&symbols=EUR,GBP - Units of each of the user's currencies per one base unit
+	api.GET("/rates", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID := currentUserID(c)
+		base := normalizeCurrency(c.DefaultQuery("base", fxPivot))
+
+		currencies, err := userRateCurrencies(ctx, dbPool, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load currencies"})
+			return
+		}
+
+		var date time.Time
+		if d := c.Query("date"); d != "" {
+			parsed, err := time.Parse(dateLayout, d)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+				return
+			}
+			date = parsed
+		}
+
+		for _, cur := range append(strings.Split(c.Query("symbols"), ","), base) {
+			if cur = normalizeCurrency(cur); cur == "" || currencies[cur] {
+				continue
+			}
+			if !storedRateCurrency(ctx, dbPool, cur) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency " + cur})
+				return
+			}
+			currencies[cur] = true
+		}
+
+		perUSD := map[string]float64{}
+		for cur := range currencies {
+			var rate float64
+			var err error
+			if date.IsZero() {
+				rate, err = liveUSDRate(ctx, dbPool, cur)
+			} else {
+				rate, err = usdRate(ctx, dbPool, cur, date)
+			}
+			if err != nil {
+				log.Printf("Warning: no %s rate: %v", cur, err)
+				continue
+			}
+			perUSD[cur] = rate
+		}
+
+		baseRate, ok := perUSD[base]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No rate for base currency " + base})
+			return
+		}
+		rates := gin.H{}
+		for cur, rate := range perUSD {
+			rates[cur] = rate / baseRate
+		}
+		c.JSON(http.StatusOK, rates)
+	})
+}
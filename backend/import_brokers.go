@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// exchangeTicker turns an Indian exchange code into its Yahoo ticker.
+func exchangeTicker(symbol, exchange string) string {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	switch strings.ToUpper(strings.TrimSpace(exchange)) {
+	case "BSE":
+		return symbol + ".BO"
+	case "NSE", "":
+		return symbol + ".NS"
+	}
+	return ""
+}
+
+// nseSeries are the series Kite appends to a symbol outside the regular EQ
+// market, e.g. "ABC-BE" for trade-to-trade.
+var nseSeries = map[string]bool{
+	"BE": true, "BZ": true, "BL": true, "BT": true, "IL": true, "IT": true,
+	"SM": true, "ST": true, "SZ": true, "GB": true, "GS": true, "RR": true, "EQ": true,
+}
+
+// stripSeriesSuffix drops a trailing series from a Kite symbol. Hyphens that
+// belong to the symbol itself, as in BAJAJ-AUTO or NAM-INDIA, are kept.
+func stripSeriesSuffix(symbol string) string {
+	if i := strings.LastIndex(symbol, "-"); i > 0 && nseSeries[strings.ToUpper(strings.TrimSpace(symbol[i+1:]))] {
+		return symbol[:i]
+	}
+	return symbol
+}
+
+// ZerodhaTradebookParser reads the tradebook CSV from Zerodha Console, for
+// both equity (NSE/BSE) and Coin mutual fund segments.
+type ZerodhaTradebookParser struct{}
+
+func (p *ZerodhaTradebookParser) Name() string { return "zerodha-tradebook" }
+
+func (p *ZerodhaTradebookParser) Description() string {
+	return "Zerodha Console tradebook CSV (equity and Coin mutual funds)"
+}
+
+func (p *ZerodhaTradebookParser) Parse(data []byte, opts ImportOptions) ([]ImportRow, error) {
+	t, err := readCSVTable(data, "symbol", "trade_date", "trade_type", "quantity", "price")
+	if err != nil {
+		return nil, err
+	}
+	var rows []ImportRow
+	for i, rec := range t.rows {
+		r := ImportRow{
+			Line:         t.first + i,
+			SourceSymbol: t.get(rec, "symbol"),
+			ISIN:         t.get(rec, "isin"),
+			Type:         normalizeTxnType(t.get(rec, "trade_type")),
+			Currency:     "INR",
+			AssetType:    "Stock",
+		}
+		if id := t.get(rec, "trade_id"); id != "" {
+			r.ExternalID = id + "/" + t.get(rec, "order_id")
+			r.Notes = "Zerodha trade " + id
+		}
+		if strings.EqualFold(t.get(rec, "segment"), "MF") {
+			r.AssetType = "Mutual Fund"
+		} else {
+			r.Symbol = exchangeTicker(r.SourceSymbol, t.get(rec, "exchange"))
+		}
+		fillRow(&r, t, rec, "trade_date", nil, "quantity", "price")
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+// fillRow parses the date, quantity and price columns shared by most
+// exports, recording any problem on the row. Nil layouts accept any common
+// statement layout.
+func fillRow(r *ImportRow, t *csvTable, rec []string, dateCol string, layouts []string, qtyCol, priceCol string) {
+	var errs []string
+	var err error
+	if layouts == nil {
+		layouts = statementDateLayouts
+	}
+	if r.Date, err = parseDateIn(t.get(rec, dateCol), layouts); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if r.Quantity, err = t.amount(rec, qtyCol); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if r.Price, err = t.amount(rec, priceCol); err != nil {
+		errs = append(errs, err.Error())
+	}
+	r.Quantity = math.Abs(r.Quantity)
+	r.Price = math.Abs(r.Price)
+	if r.Type == "" {
+		errs = append(errs, "unrecognised trade type")
+	}
+	if len(errs) > 0 && r.Status == "" {
+		r.Status, r.Error = ImportInvalid, strings.Join(errs, "; ")
+	}
+}
+
+// ZerodhaHoldingsParser reads a Kite or Console holdings export. Holdings
+// have no trade history, so each becomes an opening-balance buy at the
+// average cost, dated asOf or today.
+type ZerodhaHoldingsParser struct{}
+
+func (p *ZerodhaHoldingsParser) Name() string { return "zerodha-holdings" }
+
+func (p *ZerodhaHoldingsParser) Description() string {
+	return "Zerodha Kite/Console holdings CSV, imported as opening balances dated asOf"
+}
+
+func (p *ZerodhaHoldingsParser) Parse(data []byte, opts ImportOptions) ([]ImportRow, error) {
+	// Kite uses "Instrument, Qty., Avg. cost"; Console uses "Symbol, Quantity Available, Average Price"
+	var t *csvTable
+	var err error
+	for _, cols := range [][]string{{"instrument", "qty.", "avg. cost"}, {"symbol", "quantity available", "average price"}} {
+		if t, err = readCSVTable(data, cols...); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("not a Zerodha holdings export: %w", err)
+	}
+
+	asOf := opts.AsOf
+	if asOf == "" {
+		asOf = time.Now().Format(dateLayout)
+	}
+	var rows []ImportRow
+	for i, rec := range t.rows {
+		source := t.get(rec, "instrument", "symbol")
+		r := ImportRow{
+			Line:         t.first + i,
+			SourceSymbol: source,
+			ISIN:         t.get(rec, "isin"),
+			Type:         TxnBuy,
+			Date:         asOf,
+			Currency:     "INR",
+			AssetType:    "Stock",
+			Notes:        "Opening balance (Zerodha holdings)",
+		}
+		r.Symbol = exchangeTicker(stripSeriesSuffix(source), t.get(rec, "exchange"))
+		var errs []string
+		if r.Quantity, err = t.amount(rec, "qty.", "quantity available", "quantity"); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if r.Price, err = t.amount(rec, "avg. cost", "average price"); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if len(errs) > 0 {
+			r.Status, r.Error = ImportInvalid, strings.Join(errs, "; ")
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+// GrowwParser reads Groww's stock order history and mutual fund transaction
+// statements, telling them apart by their columns.
+type GrowwParser struct{}
+
+func (p *GrowwParser) Name() string { return "groww" }
+
+func (p *GrowwParser) Description() string {
+	return "Groww stock order history or mutual fund transactions CSV"
+}
+
+func (p *GrowwParser) Parse(data []byte, opts ImportOptions) ([]ImportRow, error) {
+	if t, err := readCSVTable(data, "symbol", "type", "quantity", "value", "execution date and time"); err == nil {
+		return p.stocks(t), nil
+	}
+	t, err := readCSVTable(data, "scheme name", "transaction type", "units", "nav", "date")
+	if err != nil {
+		return nil, fmt.Errorf("not a Groww stocks or mutual funds export: %w", err)
+	}
+	return p.funds(t), nil
+}
+
+func (p *GrowwParser) stocks(t *csvTable) []ImportRow {
+	var rows []ImportRow
+	for i, rec := range t.rows {
+		r := ImportRow{
+			Line:         t.first + i,
+			SourceSymbol: t.get(rec, "symbol"),
+			ISIN:         t.get(rec, "isin"),
+			Type:         normalizeTxnType(t.get(rec, "type")),
+			Currency:     "INR",
+			AssetType:    "Stock",
+			ExternalID:   t.get(rec, "exchange order id"),
+			Notes:        "Groww order " + t.get(rec, "exchange order id"),
+		}
+		r.Symbol = exchangeTicker(r.SourceSymbol, t.get(rec, "exchange"))
+		if status := t.get(rec, "order status"); status != "" && !strings.EqualFold(status, "executed") {
+			r.Status, r.Error = ImportIgnored, "order "+strings.ToLower(status)
+			rows = append(rows, r)
+			continue
+		}
+		// Groww gives the order value, not a price
+		fillRow(&r, t, rec, "execution date and time", nil, "quantity", "value")
+		if r.Quantity > 0 {
+			r.Price /= r.Quantity
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+func (p *GrowwParser) funds(t *csvTable) []ImportRow {
+	var rows []ImportRow
+	for i, rec := range t.rows {
+		r := ImportRow{
+			Line:         t.first + i,
+			SourceSymbol: t.get(rec, "scheme name"),
+			ISIN:         t.get(rec, "isin"),
+			Type:         normalizeTxnType(t.get(rec, "transaction type")),
+			Currency:     "INR",
+			AssetType:    "Mutual Fund",
+			Notes:        "Groww " + strings.ToLower(t.get(rec, "transaction type")),
+		}
+		// Fund statements write dates day first, e.g. 05/03/2024 for 5 March
+		fillRow(&r, t, rec, "date", dayFirstDateLayouts, "units", "nav")
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// usBrokerRow maps a US brokerage transaction action onto a ledger entry.
+// Cash distributions carry their amount as Price with zero quantity; actions
+// that do not change holdings (cash transfers, interest, journal entries)
+// are ignored.
+func usBrokerRow(r *ImportRow, action string, qty, price, amount, fees float64) {
+	lower := strings.ToLower(action)
+	switch {
+	// Checked before reinvestment: Schwab's "Reinvest Dividend" is the cash
+	// leg, and the share purchase is a separate "Reinvest Shares" line
+	case strings.Contains(lower, "dividend") || strings.Contains(lower, "capital gain") || strings.Contains(lower, "distribution"):
+		r.Type, r.Quantity, r.Price = TxnDividend, 0, math.Abs(amount)
+	case strings.Contains(lower, "reinvest"), strings.HasPrefix(lower, "buy"), strings.Contains(lower, "bought"):
+		r.Type, r.Quantity, r.Price = TxnBuy, math.Abs(qty), math.Abs(price)
+	case strings.HasPrefix(lower, "sell"), strings.Contains(lower, "sold"):
+		r.Type, r.Quantity, r.Price = TxnSell, math.Abs(qty), math.Abs(price)
+	case strings.Contains(lower, "transfer") && qty != 0:
+		r.Type, r.Quantity, r.Price = TxnTransferIn, math.Abs(qty), math.Abs(price)
+		if qty < 0 {
+			r.Type = TxnTransferOut
+		}
+	default:
+		r.Status, r.Error = ImportIgnored, "not a holding change: "+action
+		return
+	}
+	r.Fees = math.Abs(fees)
+	if r.Price == 0 && r.Quantity > 0 && amount != 0 {
+		r.Price = (math.Abs(amount) - r.Fees) / r.Quantity
+	}
+	if r.Symbol == "" {
+		r.Status, r.Error = ImportIgnored, "no symbol"
+	}
+}
+
+// VanguardParser reads the transaction section of a Vanguard brokerage
+// download (the file starts with a holdings section, which is skipped).
+type VanguardParser struct{}
+
+func (p *VanguardParser) Name() string { return "vanguard" }
+
+func (p *VanguardParser) Description() string {
+	return "Vanguard brokerage transactions download (OfxDownload.csv)"
+}
+
+func (p *VanguardParser) Parse(data []byte, opts ImportOptions) ([]ImportRow, error) {
+	t, err := readCSVTable(data, "trade date", "transaction type", "symbol", "shares", "share price")
+	if err != nil {
+		return nil, fmt.Errorf("not a Vanguard transactions export: %w", err)
+	}
+	var rows []ImportRow
+	for i, rec := range t.rows {
+		symbol := strings.ToUpper(t.get(rec, "symbol"))
+		r := ImportRow{
+			Line:         t.first + i,
+			SourceSymbol: symbol,
+			Symbol:       symbol,
+			Currency:     "USD",
+			AssetType:    "Stock",
+			Notes:        t.get(rec, "transaction description"),
+		}
+		var errs []string
+		if r.Date, err = parseStatementDate(t.get(rec, "trade date"), ""); err != nil {
+			errs = append(errs, err.Error())
+		}
+		qty, err1 := t.amount(rec, "shares")
+		price, err2 := t.amount(rec, "share price")
+		amount, err3 := t.amount(rec, "net amount", "principal amount")
+		fees, err4 := t.amount(rec, "commission fees", "commissions and fees")
+		for _, e := range []error{err1, err2, err3, err4} {
+			if e != nil {
+				errs = append(errs, e.Error())
+			}
+		}
+		if len(errs) > 0 {
+			r.Status, r.Error = ImportInvalid, strings.Join(errs, "; ")
+		} else {
+			usBrokerRow(&r, t.get(rec, "transaction type"), qty, price, amount, fees)
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+// SchwabParser reads a Charles Schwab account history CSV.
+type SchwabParser struct{}
+
+func (p *SchwabParser) Name() string { return "schwab" }
+
+func (p *SchwabParser) Description() string {
+	return "Charles Schwab transaction history CSV"
+}
+
+func (p *SchwabParser) Parse(data []byte, opts ImportOptions) ([]ImportRow, error) {
+	t, err := readCSVTable(data, "date", "action", "symbol", "quantity", "price", "amount")
+	if err != nil {
+		return nil, fmt.Errorf("not a Schwab transactions export: %w", err)
+	}
+	var rows []ImportRow
+	for i, rec := range t.rows {
+		// Schwab closes the file with a "Transactions Total" line
+		if strings.HasPrefix(strings.ToLower(t.get(rec, "date")), "transactions total") {
+			continue
+		}
+		symbol := strings.ToUpper(t.get(rec, "symbol"))
+		r := ImportRow{
+			Line:         t.first + i,
+			SourceSymbol: symbol,
+			Symbol:       symbol,
+			Currency:     "USD",
+			AssetType:    "Stock",
+			Notes:        t.get(rec, "description"),
+		}
+		var errs []string
+		if r.Date, err = parseStatementDate(t.get(rec, "date"), ""); err != nil {
+			errs = append(errs, err.Error())
+		}
+		qty, err1 := t.amount(rec, "quantity")
+		price, err2 := t.amount(rec, "price")
+		amount, err3 := t.amount(rec, "amount")
+		fees, err4 := t.amount(rec, "fees & comm")
+		for _, e := range []error{err1, err2, err3, err4} {
+			if e != nil {
+				errs = append(errs, e.Error())
+			}
+		}
+		if len(errs) > 0 {
+			r.Status, r.Error = ImportInvalid, strings.Join(errs, "; ")
+		} else {
+			usBrokerRow(&r, t.get(rec, "action"), qty, price, amount, fees)
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// CASParser reads a CAMS/KFintech consolidated account statement, either as
+// text extracted from the PDF or as the JSON produced by casparser and
+// similar tools. Every transaction becomes its own ledger row, so SIP
+// instalments keep their dates and NAVs instead of collapsing into one lot.
+type CASParser struct{}
+
+func (p *CASParser) Name() string { return "cas" }
+
+func (p *CASParser) Description() string {
+	return "CAMS/KFintech consolidated account statement (PDF text or casparser JSON)"
+}
+
+func (p *CASParser) Parse(data []byte, opts ImportOptions) ([]ImportRow, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return p.parseJSON(trimmed)
+	}
+	return p.parseText(string(data))
+}
+
+// casText accepts a JSON string, number or null, since CAS tools disagree on
+// how to encode scheme codes.
+type casText string
+
+func (s *casText) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch x := v.(type) {
+	case string:
+		*s = casText(x)
+	case float64:
+		*s = casText(fmt.Sprintf("%.0f", x))
+	}
+	return nil
+}
+
+type casJSON struct {
+	Folios []struct {
+		Folio   string `json:"folio"`
+		Schemes []struct {
+			Scheme       string  `json:"scheme"`
+			ISIN         string  `json:"isin"`
+			AMFI         casText `json:"amfi"`
+			Transactions []struct {
+				Date        string   `json:"date"`
+				Description string   `json:"description"`
+				Amount      *float64 `json:"amount"`
+				Units       *float64 `json:"units"`
+				NAV         *float64 `json:"nav"`
+				Type        string   `json:"type"`
+			} `json:"transactions"`
+		} `json:"schemes"`
+	} `json:"folios"`
+}
+
+func (p *CASParser) parseJSON(data []byte) ([]ImportRow, error) {
+	var cas casJSON
+	if err := json.Unmarshal(data, &cas); err != nil {
+		return nil, fmt.Errorf("not a CAS JSON file: %w", err)
+	}
+	if len(cas.Folios) == 0 {
+		return nil, fmt.Errorf("no folios in CAS JSON")
+	}
+
+	var rows []ImportRow
+	line := 0
+	for _, f := range cas.Folios {
+		for _, s := range f.Schemes {
+			symbol := ""
+			if s.AMFI != "" {
+				symbol = "AMFI:" + string(s.AMFI)
+			}
+			for _, t := range s.Transactions {
+				line++
+				e := casEntry{
+					folio: f.Folio, scheme: s.Scheme, isin: s.ISIN, symbol: symbol,
+					description: t.Description, kind: strings.ToUpper(t.Type),
+					amount: deref(t.Amount), units: deref(t.Units), nav: deref(t.NAV),
+				}
+				rows = append(rows, e.rows(line, t.Date)...)
+			}
+		}
+	}
+	return rows, nil
+}
+
+func deref(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+var (
+	casFolioRe  = regexp.MustCompile(`(?i)folio\s*no\s*[:.]?\s*([0-9A-Za-z/]+(?:\s*/\s*[0-9A-Za-z]+)?)`)
+	casISINRe   = regexp.MustCompile(`ISIN\s*:\s*([A-Z]{2}[A-Z0-9]{9}[0-9])`)
+	casTxnRe    = regexp.MustCompile(`^(\d{2}-[A-Za-z]{3}-\d{4})\s+(.+)$`)
+	casNumberRe = regexp.MustCompile(`^\(?-?[\d,]+\.\d+\)?$`)
+	casCodeRe   = regexp.MustCompile(`^[A-Z0-9]+-`)
+)
+
+// parseText walks the text of a CAS PDF. A "Folio No" line opens a folio, a
+// line with "ISIN:" opens a scheme, and dated lines ending in numbers are
+// transactions: amount, units, NAV and unit balance, or just an amount for
+// stamp duty and tax lines.
+func (p *CASParser) parseText(text string) ([]ImportRow, error) {
+	var rows []ImportRow
+	var current casEntry
+	schemes := 0
+	for i, raw := range strings.Split(text, "\n") {
+		ln := strings.TrimSpace(raw)
+		if m := casFolioRe.FindStringSubmatch(ln); m != nil {
+			current = casEntry{folio: strings.Join(strings.Fields(m[1]), " ")}
+			continue
+		}
+		if m := casISINRe.FindStringSubmatch(ln); m != nil {
+			name := ln[:strings.Index(ln, "ISIN")]
+			name = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(name), "-"))
+			current = casEntry{folio: current.folio, isin: m[1], scheme: casCodeRe.ReplaceAllString(name, "")}
+			schemes++
+			continue
+		}
+		m := casTxnRe.FindStringSubmatch(ln)
+		if m == nil || current.isin == "" {
+			continue
+		}
+
+		fields := strings.Fields(m[2])
+		var numbers []float64
+		for len(fields) > 0 && len(numbers) < 4 && casNumberRe.MatchString(fields[len(fields)-1]) {
+			v, err := parseAmount(fields[len(fields)-1])
+			if err != nil {
+				break
+			}
+			numbers = append([]float64{v}, numbers...)
+			fields = fields[:len(fields)-1]
+		}
+		e := current
+		e.description = strings.Trim(strings.Join(fields, " "), "* ")
+		switch len(numbers) {
+		case 1:
+			e.amount = numbers[0]
+		case 3, 4: // a fourth number is the unit balance
+			e.amount, e.units, e.nav = numbers[0], numbers[1], numbers[2]
+		default:
+			continue
+		}
+		if strings.Contains(strings.ToLower(e.description), "balance") {
+			continue // opening and closing unit balances
+		}
+		e.kind = casKind(e.description, e.units)
+		rows = append(rows, e.rows(i+1, m[1])...)
+	}
+	if schemes == 0 {
+		return nil, fmt.Errorf("no schemes found; is this the text of a detailed CAS?")
+	}
+	return rows, nil
+}
+
+// casKind classifies a text transaction using casparser's type names.
+func casKind(description string, units float64) string {
+	d := strings.ToLower(description)
+	dividend := strings.Contains(d, "dividend") || strings.Contains(d, "idcw")
+	switch {
+	case strings.Contains(d, "stamp duty"):
+		return "STAMP_DUTY_TAX"
+	case strings.Contains(d, "stt"):
+		return "STT_TAX"
+	case strings.Contains(d, "tds"):
+		return "TDS_TAX"
+	case dividend && strings.Contains(d, "reinvest"):
+		return "DIVIDEND_REINVEST"
+	case dividend:
+		return "DIVIDEND_PAYOUT"
+	case strings.Contains(d, "reversal") || strings.Contains(d, "rejection"):
+		return "REVERSAL"
+	case strings.Contains(d, "switch") && strings.Contains(d, "merger"):
+		if units < 0 {
+			return "SWITCH_OUT_MERGER"
+		}
+		return "SWITCH_IN_MERGER"
+	case strings.Contains(d, "switch"):
+		if units < 0 {
+			return "SWITCH_OUT"
+		}
+		return "SWITCH_IN"
+	case strings.Contains(d, "redemption") || strings.Contains(d, "redeem") || strings.Contains(d, "withdrawal"):
+		return "REDEMPTION"
+	case strings.Contains(d, "sip") || strings.Contains(d, "systematic"):
+		return "PURCHASE_SIP"
+	case strings.Contains(d, "purchase") || strings.Contains(d, "investment"):
+		return "PURCHASE"
+	case units < 0:
+		return "REDEMPTION"
+	}
+	return "UNKNOWN"
+}
+
+// casEntry is one CAS transaction with its folio and scheme.
+type casEntry struct {
+	folio, scheme, isin, symbol string
+	description, kind           string
+	amount, units, nav          float64
+}
+
+// rows converts the entry into ledger rows. Switches are trades for tax
+// purposes, so they become buys and sells. Merger switches become a transfer
+// pair at the merger NAV, which nets to nothing as a cash flow; the new lot
+// opens at that NAV, since the statement does not give the original cost.
+// A reinvested dividend is both the cash dividend and the purchase it
+// funded. A reversal undoes an earlier line in the statement, so reversed
+// units become a sell and restored units a buy at the same NAV.
+func (e casEntry) rows(line int, date string) []ImportRow {
+	r := ImportRow{
+		Line:         line,
+		SourceSymbol: e.scheme,
+		ISIN:         e.isin,
+		Symbol:       e.symbol,
+		AssetType:    "Mutual Fund",
+		Currency:     "INR",
+		Notes:        fmt.Sprintf("CAS folio %s: %s", e.folio, e.description),
+	}
+	d, err := parseStatementDate(date, "")
+	if err != nil {
+		r.Status, r.Error = ImportInvalid, err.Error()
+		return []ImportRow{r}
+	}
+	r.Date = d
+
+	units := math.Abs(e.units)
+	price := e.nav
+	if price == 0 && units > 0 {
+		price = math.Abs(e.amount) / units
+	}
+	trade := func(txnType string) ImportRow {
+		t := r
+		t.Type, t.Quantity, t.Price = txnType, units, price
+		return t
+	}
+	cash := func(txnType string) ImportRow {
+		t := r
+		t.Type, t.Price = txnType, math.Abs(e.amount)
+		return t
+	}
+
+	switch e.kind {
+	case "PURCHASE", "PURCHASE_SIP", "SWITCH_IN":
+		return []ImportRow{trade(TxnBuy)}
+	case "REDEMPTION", "SWITCH_OUT":
+		return []ImportRow{trade(TxnSell)}
+	case "SWITCH_IN_MERGER":
+		return []ImportRow{trade(TxnTransferIn)}
+	case "SWITCH_OUT_MERGER":
+		return []ImportRow{trade(TxnTransferOut)}
+	case "REVERSAL":
+		if units == 0 {
+			break
+		}
+		if e.units < 0 {
+			return []ImportRow{trade(TxnSell)}
+		}
+		return []ImportRow{trade(TxnBuy)}
+	case "DIVIDEND_PAYOUT":
+		return []ImportRow{cash(TxnDividend)}
+	case "DIVIDEND_REINVEST", "DIVIDEND_REINVESTMENT":
+		return []ImportRow{cash(TxnDividend), trade(TxnBuy)}
+	case "STAMP_DUTY_TAX", "STT_TAX", "TDS_TAX":
+		return []ImportRow{cash(TxnFee)}
+	}
+	r.Status, r.Error = ImportIgnored, "not imported: "+strings.ToLower(e.kind)+" "+e.description
+	return []ImportRow{r}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCASEntryRows(t *testing.T) {
+	entry := func(kind string, amount, units, nav float64) casEntry {
+		return casEntry{
+			folio: "123/45", scheme: "Example Flexi Cap Fund - Direct Growth", isin: "INF000000001", symbol: "AMFI:100",
+			description: kind, kind: kind, amount: amount, units: units, nav: nav,
+		}
+	}
+	type row struct {
+		typ             string
+		quantity, price float64
+		status          string
+	}
+
+	tests := []struct {
+		name  string
+		entry casEntry
+		date  string
+		want  []row
+	}{
+		{name: "purchase", entry: entry("PURCHASE", 1000, 40, 25), want: []row{{TxnBuy, 40, 25, ""}}},
+		{name: "sip without nav", entry: entry("PURCHASE_SIP", 500, 20, 0), want: []row{{TxnBuy, 20, 25, ""}}},
+		{name: "redemption", entry: entry("REDEMPTION", -550, -20, 27.5), want: []row{{TxnSell, 20, 27.5, ""}}},
+		{name: "switch out is a sale", entry: entry("SWITCH_OUT", -550, -20, 27.5), want: []row{{TxnSell, 20, 27.5, ""}}},
+		{name: "merger switch in", entry: entry("SWITCH_IN_MERGER", 1000, 50, 20), want: []row{{TxnTransferIn, 50, 20, ""}}},
+		{name: "merger switch out", entry: entry("SWITCH_OUT_MERGER", -1000, -40, 25), want: []row{{TxnTransferOut, 40, 25, ""}}},
+		{name: "dividend payout", entry: entry("DIVIDEND_PAYOUT", 75, 0, 0), want: []row{{TxnDividend, 0, 75, ""}}},
+		{
+			name:  "dividend reinvested",
+			entry: entry("DIVIDEND_REINVEST", 50, 2, 25),
+			want:  []row{{TxnDividend, 0, 50, ""}, {TxnBuy, 2, 25, ""}},
+		},
+		{name: "stamp duty", entry: entry("STAMP_DUTY_TAX", 0.05, 0, 0), want: []row{{TxnFee, 0, 0.05, ""}}},
+		{name: "reversal of a purchase", entry: entry("REVERSAL", -1000, -40, 25), want: []row{{TxnSell, 40, 25, ""}}},
+		{name: "reversal of a redemption", entry: entry("REVERSAL", 550, 20, 27.5), want: []row{{TxnBuy, 20, 27.5, ""}}},
+		{name: "reversal without units", entry: entry("REVERSAL", 0, 0, 0), want: []row{{"", 0, 0, ImportIgnored}}},
+		{name: "unknown", entry: entry("UNKNOWN", 0, 0, 0), want: []row{{"", 0, 0, ImportIgnored}}},
+		{name: "bad date", entry: entry("PURCHASE", 1000, 40, 25), date: "sometime", want: []row{{"", 0, 0, ImportInvalid}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date := tt.date
+			if date == "" {
+				date = "05-Jan-2024"
+			}
+			got := tt.entry.rows(7, date)
+			if len(got) != len(tt.want) {
+				t.Fatalf("rows() = %+v, want %d rows", got, len(tt.want))
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if g.Type != w.typ || math.Abs(g.Quantity-w.quantity) > 1e-9 || math.Abs(g.Price-w.price) > 1e-9 || g.Status != w.status {
+					t.Errorf("row %d = %s %g @ %g [%s], want %s %g @ %g [%s]", i, g.Type, g.Quantity, g.Price, g.Status, w.typ, w.quantity, w.price, w.status)
+				}
+				if g.Line != 7 || g.Symbol != "AMFI:100" || g.Currency != "INR" {
+					t.Errorf("row %d = line %d %s %s, want line 7 AMFI:100 INR", i, g.Line, g.Symbol, g.Currency)
+				}
+				if w.status == "" && g.Date != "2024-01-05" {
+					t.Errorf("row %d date = %q, want 2024-01-05", i, g.Date)
+				}
+			}
+		})
+	}
+}
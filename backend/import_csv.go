@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// csvTable is a CSV file located by its header row. Broker exports often
+// start with title or account lines, so the header is found by content
+// rather than assumed to be the first line.
+type csvTable struct {
+	header map[string]int
+	rows   [][]string
+	first  int // 1-based file line of rows[0]
+}
+
+func normalizeHeader(h string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(h), "\ufeff\""))
+}
+
+// readCSVTable finds the first line containing every required column
+// (case-insensitive) and returns the rows after it up to the first blank
+// line, which is where multi-section exports start their next table.
+func readCSVTable(data []byte, required ...string) (*csvTable, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSV: %w", err)
+	}
+
+	for i, rec := range records {
+		header := map[string]int{}
+		for j, h := range rec {
+			if _, dup := header[normalizeHeader(h)]; !dup {
+				header[normalizeHeader(h)] = j
+			}
+		}
+		found := true
+		for _, col := range required {
+			if _, ok := header[strings.ToLower(col)]; !ok {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+
+		t := &csvTable{header: header, first: i + 2}
+		for _, row := range records[i+1:] {
+			if blankRecord(row) {
+				break
+			}
+			t.rows = append(t.rows, row)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("no header row with columns %s", strings.Join(required, ", "))
+}
+
+func blankRecord(rec []string) bool {
+	for _, f := range rec {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the first of the named columns present in the header.
+func (t *csvTable) get(row []string, cols ...string) string {
+	for _, col := range cols {
+		if i, ok := t.header[strings.ToLower(col)]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+	}
+	return ""
+}
+
+// amount parses a numeric column; a blank cell is zero.
+func (t *csvTable) amount(row []string, cols ...string) (float64, error) {
+	return parseAmount(t.get(row, cols...))
+}
+
+// GenericCSVParser reads any CSV given a column mapping. Fields: date,
+// symbol, type, quantity, price, fees, amount, currency, notes, id, isin,
+// assetType. Type defaults to buy; price may be derived from amount.
+type GenericCSVParser struct{}
+
+func (p *GenericCSVParser) Name() string { return "generic" }
+
+func (p *GenericCSVParser) Description() string {
+	return "Any CSV with a column mapping, e.g. {\"date\":\"Trade Date\",\"symbol\":\"Ticker\",\"quantity\":\"Qty\",\"price\":\"Price\"}"
+}
+
+func (p *GenericCSVParser) Parse(data []byte, opts ImportOptions) ([]ImportRow, error) {
+	cols := map[string]string{}
+	for field, col := range opts.Columns {
+		cols[strings.ToLower(field)] = col
+	}
+	for _, field := range []string{"date", "symbol", "quantity"} {
+		if cols[field] == "" {
+			return nil, fmt.Errorf("columns must map %q", field)
+		}
+	}
+	required := []string{cols["date"], cols["symbol"], cols["quantity"]}
+	t, err := readCSVTable(data, required...)
+	if err != nil {
+		return nil, err
+	}
+	col := func(row []string, field string) string {
+		if cols[field] == "" {
+			return ""
+		}
+		return t.get(row, cols[field])
+	}
+
+	var rows []ImportRow
+	for i, rec := range t.rows {
+		r := ImportRow{
+			Line:         t.first + i,
+			SourceSymbol: col(rec, "symbol"),
+			Symbol:       col(rec, "symbol"),
+			ISIN:         col(rec, "isin"),
+			AssetType:    col(rec, "assettype"),
+			Currency:     col(rec, "currency"),
+			Notes:        col(rec, "notes"),
+			ExternalID:   col(rec, "id"),
+			Type:         TxnBuy,
+		}
+		if r.AssetType == "" {
+			r.AssetType = "Stock"
+		}
+		if v := col(rec, "type"); v != "" {
+			if r.Type = normalizeTxnType(v); r.Type == "" {
+				r.Status, r.Error = ImportIgnored, "unrecognised type "+v
+				rows = append(rows, r)
+				continue
+			}
+		}
+		var errs []string
+		var err error
+		if r.Date, err = parseStatementDate(col(rec, "date"), opts.DateFormat); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if r.Quantity, err = parseAmount(col(rec, "quantity")); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if r.Price, err = parseAmount(col(rec, "price")); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if r.Fees, err = parseAmount(col(rec, "fees")); err != nil {
+			errs = append(errs, err.Error())
+		}
+		amount, err := parseAmount(col(rec, "amount"))
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		// Exports often sign sells as negative quantities
+		if r.Quantity < 0 {
+			r.Quantity = -r.Quantity
+			if r.Type == TxnBuy && col(rec, "type") == "" {
+				r.Type = TxnSell
+			}
+		}
+		if r.Price == 0 && amount != 0 && r.Quantity > 0 {
+			r.Price = math.Abs(amount) / r.Quantity
+		} else if r.Price == 0 && amount != 0 {
+			r.Price = math.Abs(amount)
+		}
+		if len(errs) > 0 {
+			r.Status, r.Error = ImportInvalid, strings.Join(errs, "; ")
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
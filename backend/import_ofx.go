@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"time"
+)
+
+// ofxNode is an element of an OFX document. Leaf elements carry a value;
+// aggregates carry children.
+type ofxNode struct {
+	name     string
+	value    string
+	children []*ofxNode
+}
+
+// parseOFX reads both OFX 1.x SGML, where leaf elements are usually left
+// unclosed, and OFX 2.x XML. Headers before <OFX> are skipped.
+func parseOFX(data []byte) (*ofxNode, error) {
+	s := string(data)
+	start := strings.Index(strings.ToUpper(s), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("no <OFX> element; is this an OFX/QFX file?")
+	}
+	s = s[start:]
+
+	root := &ofxNode{}
+	stack := []*ofxNode{root}
+	for {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			break
+		}
+		gt := strings.IndexByte(s[lt:], '>')
+		if gt < 0 {
+			return nil, fmt.Errorf("unterminated tag")
+		}
+		tag := strings.ToUpper(strings.TrimSpace(s[lt+1 : lt+gt]))
+		s = s[lt+gt+1:]
+		if strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") {
+			continue
+		}
+		if name, ok := strings.CutPrefix(tag, "/"); ok {
+			// Close the nearest open aggregate of that name; closing tags of
+			// leaves were consumed with their value
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+
+		parent := stack[len(stack)-1]
+		node := &ofxNode{name: strings.TrimSuffix(tag, "/")}
+		parent.children = append(parent.children, node)
+		if strings.HasSuffix(tag, "/") {
+			continue
+		}
+		next := strings.IndexByte(s, '<')
+		if next < 0 {
+			next = len(s)
+		}
+		if text := strings.TrimSpace(s[:next]); text != "" {
+			node.value = html.UnescapeString(text)
+			s = s[next:]
+			if closing := "</" + tag + ">"; len(s) >= len(closing) && strings.EqualFold(s[:len(closing)], closing) {
+				s = s[len(closing):]
+			}
+		} else {
+			stack = append(stack, node)
+		}
+	}
+	if ofx := root.child("OFX"); ofx != nil {
+		return ofx, nil
+	}
+	return nil, fmt.Errorf("empty OFX document")
+}
+
+func (n *ofxNode) child(name string) *ofxNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// items returns the children of n, which may be absent.
+func (n *ofxNode) items() []*ofxNode {
+	if n == nil {
+		return nil
+	}
+	return n.children
+}
+
+// text returns the value at the path below n, or "".
+func (n *ofxNode) text(path ...string) string {
+	for _, name := range path {
+		n = n.child(name)
+	}
+	if n == nil {
+		return ""
+	}
+	return n.value
+}
+
+func (n *ofxNode) amount(path ...string) (float64, error) {
+	return parseAmount(n.text(path...))
+}
+
+// find returns every descendant named name, in document order.
+func (n *ofxNode) find(name string) []*ofxNode {
+	var found []*ofxNode
+	for _, c := range n.children {
+		if c.name == name {
+			found = append(found, c)
+		}
+		found = append(found, c.find(name)...)
+	}
+	return found
+}
+
+// ofxDate reads the leading YYYYMMDD of an OFX datetime such as
+// "20230105120000.000[-5:EST]".
+func ofxDate(s string) (string, error) {
+	if len(s) < 8 {
+		return "", fmt.Errorf("unrecognised date %q", s)
+	}
+	d, err := time.Parse("20060102", s[:8])
+	if err != nil {
+		return "", fmt.Errorf("unrecognised date %q", s)
+	}
+	return d.Format(dateLayout), nil
+}
+
+// ofxSecurity is a SECLIST entry, keyed by its SECID.
+type ofxSecurity struct {
+	kind   string // STOCKINFO, MFINFO, DEBTINFO, OPTINFO or OTHERINFO
+	name   string
+	ticker string
+}
+
+// OFXParser reads the investment statements of an OFX or Quicken QFX
+// download: trades, income, reinvestments, transfers and splits, plus the
+// position list when the file has no transactions. Cash balances and bank
+// lines are reported but not imported, since the ledger does not hold cash.
+type OFXParser struct{}
+
+func (p *OFXParser) Name() string { return "ofx" }
+
+func (p *OFXParser) Description() string {
+	return "OFX or QFX download (SGML or XML) from a brokerage or bank"
+}
+
+func (p *OFXParser) Parse(data []byte, opts ImportOptions) ([]ImportRow, error) {
+	ofx, err := parseOFX(data)
+	if err != nil {
+		return nil, err
+	}
+
+	secs := map[string]ofxSecurity{}
+	for _, kind := range []string{"STOCKINFO", "MFINFO", "DEBTINFO", "OPTINFO", "OTHERINFO"} {
+		for _, info := range ofx.find(kind) {
+			sec := info.child("SECINFO")
+			secs[sec.text("SECID", "UNIQUEID")] = ofxSecurity{kind: kind, name: sec.text("SECNAME"), ticker: sec.text("TICKER")}
+		}
+	}
+
+	var rows []ImportRow
+	line := 0
+	for _, stmt := range ofx.find("INVSTMTRS") {
+		currency := stmt.text("CURDEF")
+		account := stmt.text("INVACCTFROM", "ACCTID")
+		before := len(rows)
+		for _, t := range stmt.child("INVTRANLIST").items() {
+			if t.name == "DTSTART" || t.name == "DTEND" {
+				continue
+			}
+			line++
+			rows = append(rows, p.transaction(t, line, account, currency, secs)...)
+		}
+
+		asOf := opts.AsOf
+		if asOf == "" {
+			asOf, _ = ofxDate(stmt.text("DTASOF"))
+		}
+		hasTxns := len(rows) > before
+		for _, pos := range stmt.child("INVPOSLIST").items() {
+			line++
+			rows = append(rows, p.position(pos, line, asOf, currency, secs, hasTxns))
+		}
+
+		if cash := stmt.text("INVBAL", "AVAILCASH"); cash != "" {
+			line++
+			rows = append(rows, cashBalanceRow(line, cash, currency, account))
+		}
+	}
+
+	// Bank and card statements: only the balance and the lines are noted
+	for _, name := range []string{"STMTRS", "CCSTMTRS"} {
+		for _, stmt := range ofx.find(name) {
+			currency := stmt.text("CURDEF")
+			account := stmt.text("BANKACCTFROM", "ACCTID") + stmt.text("CCACCTFROM", "ACCTID")
+			for _, t := range stmt.find("STMTTRN") {
+				line++
+				rows = append(rows, ImportRow{
+					Line: line, Currency: currency, Notes: t.text("NAME") + " " + t.text("MEMO"),
+					Status: ImportIgnored, Error: "bank transaction " + t.text("TRNAMT") + " " + currency,
+				})
+			}
+			if bal := stmt.text("LEDGERBAL", "BALAMT"); bal != "" {
+				line++
+				rows = append(rows, cashBalanceRow(line, bal, currency, account))
+			}
+		}
+	}
+	if line == 0 {
+		return nil, fmt.Errorf("no statements found in OFX file")
+	}
+	return rows, nil
+}
+
+func cashBalanceRow(line int, amount, currency, account string) ImportRow {
+	return ImportRow{
+		Line: line, Currency: currency, Notes: "Cash balance, account " + account,
+		Status: ImportIgnored, Error: fmt.Sprintf("cash balance %s %s; cash is not tracked in the ledger", amount, currency),
+	}
+}
+
+// security fills the row's identifiers from a SECID aggregate. The ticker,
+// when the file has one, is the source symbol; resolution confirms it or
+// falls back to the CUSIP. Returns the SECLIST kind.
+func (p *OFXParser) security(r *ImportRow, secid *ofxNode, secs map[string]ofxSecurity) string {
+	id := secid.text("UNIQUEID")
+	switch strings.ToUpper(secid.text("UNIQUEIDTYPE")) {
+	case "CUSIP":
+		r.CUSIP = id
+	case "ISIN":
+		r.ISIN = id
+	}
+	sec := secs[id]
+	r.SourceSymbol = strings.ToUpper(sec.ticker)
+	if r.SourceSymbol == "" {
+		r.SourceSymbol = id
+	}
+	if r.Notes == "" {
+		r.Notes = sec.name
+	}
+	return sec.kind
+}
+
+// transaction converts one INVTRANLIST entry. A reinvestment becomes the
+// income and the purchase it funded, as with statement CSVs.
+func (p *OFXParser) transaction(t *ofxNode, line int, account, currency string, secs map[string]ofxSecurity) []ImportRow {
+	// Trades wrap their common fields in INVBUY/INVSELL
+	body := t
+	if inner := t.child("INVBUY"); inner != nil {
+		body = inner
+	} else if inner := t.child("INVSELL"); inner != nil {
+		body = inner
+	}
+	r := ImportRow{
+		Line:      line,
+		AssetType: "Stock",
+		Currency:  currency,
+		Notes:     body.text("INVTRAN", "MEMO"),
+	}
+	for _, agg := range []string{"CURRENCY", "ORIGCURRENCY"} {
+		if cur := body.text(agg, "CURSYM"); cur != "" {
+			r.Currency = cur
+		}
+	}
+	if fitid := body.text("INVTRAN", "FITID"); fitid != "" {
+		r.ExternalID = account + ":" + fitid
+	}
+
+	if body.child("SECID") == nil {
+		r.Status, r.Error = ImportIgnored, "not a holding change: "+t.name
+		return []ImportRow{r}
+	}
+	date, err := ofxDate(body.text("INVTRAN", "DTTRADE"))
+	if err != nil {
+		r.Status, r.Error = ImportInvalid, err.Error()
+		return []ImportRow{r}
+	}
+	r.Date = date
+	switch p.security(&r, body.child("SECID"), secs) {
+	case "DEBTINFO", "OPTINFO":
+		r.Status, r.Error = ImportIgnored, "bonds and options are not supported: "+t.name
+		return []ImportRow{r}
+	}
+
+	var errs []string
+	num := func(path ...string) float64 {
+		v, err := body.amount(path...)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		return v
+	}
+	units, price, total := math.Abs(num("UNITS")), math.Abs(num("UNITPRICE")), math.Abs(num("TOTAL"))
+	fees := math.Abs(num("COMMISSION")) + math.Abs(num("FEES")) + math.Abs(num("TAXES")) + math.Abs(num("LOAD"))
+	if price == 0 && units > 0 {
+		price = (total - fees) / units
+	}
+	income := func() bool {
+		switch body.text("INCOMETYPE") {
+		case "DIV", "CGLONG", "CGSHORT":
+			return true
+		}
+		return false
+	}
+
+	var out []ImportRow
+	switch {
+	case strings.HasPrefix(t.name, "BUY"):
+		r.Type, r.Quantity, r.Price, r.Fees = TxnBuy, units, price, fees
+		out = []ImportRow{r}
+	case strings.HasPrefix(t.name, "SELL"):
+		r.Type, r.Quantity, r.Price, r.Fees = TxnSell, units, price, fees
+		out = []ImportRow{r}
+	case t.name == "INCOME" && income():
+		r.Type, r.Price, r.Fees = TxnDividend, total, math.Abs(num("WITHHOLDING"))
+		out = []ImportRow{r}
+	case t.name == "REINVEST":
+		buy := r
+		buy.Type, buy.Quantity, buy.Price, buy.Fees = TxnBuy, units, price, fees
+		if income() {
+			r.Type, r.Price = TxnDividend, total
+			r.ExternalID += ":income"
+			out = []ImportRow{r, buy}
+		} else {
+			out = []ImportRow{buy}
+		}
+	case t.name == "TRANSFER":
+		r.Type, r.Quantity, r.Price = TxnTransferIn, units, price
+		if cost := math.Abs(num("AVGCOSTBASIS")); cost > 0 && units > 0 {
+			r.Price = cost / units
+		}
+		if strings.ToUpper(body.text("TFERACTION")) == "OUT" || num("UNITS") < 0 {
+			r.Type = TxnTransferOut
+		}
+		out = []ImportRow{r}
+	case t.name == "SPLIT":
+		numerator, denominator := num("NUMERATOR"), num("DENOMINATOR")
+		if denominator == 0 {
+			errs = append(errs, "split has no denominator")
+		} else {
+			r.Type, r.Quantity = TxnSplit, numerator/denominator
+		}
+		out = []ImportRow{r}
+	case t.name == "INVEXPENSE":
+		r.Type, r.Price = TxnFee, total
+		out = []ImportRow{r}
+	default:
+		r.Status, r.Error = ImportIgnored, "not a holding change: "+t.name
+		return []ImportRow{r}
+	}
+	if len(errs) > 0 {
+		for i := range out {
+			out[i].Status, out[i].Error = ImportInvalid, strings.Join(errs, "; ")
+		}
+	}
+	return out
+}
+
+// position converts an INVPOSLIST entry into an opening balance at the
+// statement's market price. When the file also has transactions those are
+// the history, so positions are only reported.
+func (p *OFXParser) position(pos *ofxNode, line int, asOf, currency string, secs map[string]ofxSecurity, hasTxns bool) ImportRow {
+	inv := pos.child("INVPOS")
+	r := ImportRow{
+		Line:      line,
+		Type:      TxnBuy,
+		Date:      asOf,
+		AssetType: "Stock",
+		Currency:  currency,
+	}
+	kind := p.security(&r, inv.child("SECID"), secs)
+	r.Notes = "Opening balance (OFX position " + r.Notes + ")"
+	if cur := inv.text("CURRENCY", "CURSYM"); cur != "" {
+		r.Currency = cur
+	}
+
+	units, err1 := inv.amount("UNITS")
+	price, err2 := inv.amount("UNITPRICE")
+	r.Quantity, r.Price = units, price
+	switch {
+	case err1 != nil || err2 != nil:
+		r.Status, r.Error = ImportInvalid, fmt.Sprintf("position: %v %v", err1, err2)
+	case hasTxns:
+		r.Status, r.Error = ImportIgnored, fmt.Sprintf("position of %g units; the file's transactions are imported instead", units)
+	case kind == "DEBTINFO" || kind == "OPTINFO":
+		r.Status, r.Error = ImportIgnored, "bonds and options are not supported: "+pos.name
+	case strings.ToUpper(inv.text("POSTYPE")) == "SHORT" || units < 0:
+		r.Status, r.Error = ImportIgnored, "short positions are not supported"
+	case r.Date == "":
+		r.Status, r.Error = ImportInvalid, "no statement date; pass asOf"
+	}
+	return r
+}
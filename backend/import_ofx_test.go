@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOFX(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string // path below <OFX> -> text
+		wantErr bool
+	}{
+		{
+			name: "sgml with unclosed leaves",
+			data: "OFXHEADER:100\nDATA:OFXSGML\n\n<OFX>\n<INVSTMTRS>\n<DTASOF>20240105120000\n<CURDEF>USD\n" +
+				"<INVPOSLIST>\n<POSSTOCK>\n<UNITS>10\n</POSSTOCK>\n<POSSTOCK>\n<UNITS>5\n</POSSTOCK>\n</INVPOSLIST>\n</INVSTMTRS>\n</OFX>\n",
+			want: map[string]string{
+				"INVSTMTRS/DTASOF":     "20240105120000",
+				"INVSTMTRS/CURDEF":     "USD",
+				"INVSTMTRS/INVPOSLIST": "",
+			},
+		},
+		{
+			name: "xml with closed leaves",
+			data: `<?xml version="1.0"?><?OFX OFXHEADER="200" VERSION="220"?>` +
+				`<OFX><INVSTMTRS><DTASOF>20240105</DTASOF><CURDEF>USD</CURDEF>` +
+				`<INVPOSLIST><POSSTOCK><UNITS>10</UNITS></POSSTOCK><POSSTOCK><UNITS>5</UNITS></POSSTOCK></INVPOSLIST></INVSTMTRS></OFX>`,
+			want: map[string]string{
+				"INVSTMTRS/DTASOF": "20240105",
+				"INVSTMTRS/CURDEF": "USD",
+			},
+		},
+		{
+			name: "entities and lower-case tags",
+			data: "<ofx><stmt><memo>AT&amp;T &lt;common&gt;<empty/><name>Acme</name></stmt></ofx>",
+			want: map[string]string{
+				"STMT/MEMO":  "AT&T <common>",
+				"STMT/EMPTY": "",
+				"STMT/NAME":  "Acme",
+			},
+		},
+		{
+			name:    "not ofx",
+			data:    "Date,Symbol,Quantity\n2024-01-05,ABC,10\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated tag",
+			data:    "<OFX><STMT",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseOFX([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOFX() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for path, want := range tt.want {
+				if got := root.text(strings.Split(path, "/")...); got != want {
+					t.Errorf("%s = %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseOFXNesting(t *testing.T) {
+	data := "<OFX><INVSTMTRS><INVPOSLIST><POSSTOCK><UNITS>10</POSSTOCK><POSSTOCK><UNITS>5</POSSTOCK></INVPOSLIST><CURDEF>USD</INVSTMTRS></OFX>"
+	root, err := parseOFX([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	positions := root.child("INVSTMTRS").child("INVPOSLIST").items()
+	if len(positions) != 2 {
+		t.Fatalf("got %d positions, want 2", len(positions))
+	}
+	if got := positions[1].text("UNITS"); got != "5" {
+		t.Errorf("second position units = %q, want 5", got)
+	}
+	// The closing tag of a list returns to its parent
+	if got := root.text("INVSTMTRS", "CURDEF"); got != "USD" {
+		t.Errorf("CURDEF after the list = %q, want USD", got)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// qifRecord is one "^"-terminated QIF record: field code -> value, plus the
+// section it appeared in and the line it started on.
+type qifRecord struct {
+	section string
+	line    int
+	fields  map[byte]string
+}
+
+// readQIF splits a QIF file into records. Repeated codes (split lines) keep
+// their first value, which is all the investment fields need.
+func readQIF(data []byte) []qifRecord {
+	var records []qifRecord
+	section := ""
+	cur := qifRecord{fields: map[byte]string{}}
+	for i, raw := range strings.Split(string(data), "\n") {
+		ln := strings.TrimRight(strings.TrimPrefix(raw, "\ufeff"), "\r ")
+		switch {
+		case ln == "":
+			continue
+		case strings.HasPrefix(ln, "!Option") || strings.HasPrefix(ln, "!Clear"):
+			continue
+		case strings.HasPrefix(ln, "!"):
+			section = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(ln, "!")))
+			continue
+		case ln[0] == '^':
+			if len(cur.fields) > 0 {
+				records = append(records, cur)
+			}
+			cur = qifRecord{fields: map[byte]string{}}
+			continue
+		}
+		if len(cur.fields) == 0 {
+			cur.section, cur.line = section, i+1
+		}
+		if _, dup := cur.fields[ln[0]]; !dup {
+			cur.fields[ln[0]] = strings.TrimSpace(ln[1:])
+		}
+	}
+	if len(cur.fields) > 0 {
+		records = append(records, cur)
+	}
+	return records
+}
+
+// qifDate reads Quicken dates such as "1/5'23", " 1/ 5/23" and "01/05/2023"
+// (month first), or the given layout when one is set. An apostrophe before
+// a two-digit year means 2000s; otherwise years below 70 are taken as 2000s.
+func qifDate(s, layout string) (string, error) {
+	if layout != "" {
+		return parseStatementDate(s, layout)
+	}
+	s = strings.ReplaceAll(strings.TrimSpace(s), " ", "")
+	if d, err := time.Parse(dateLayout, s); err == nil {
+		return d.Format(dateLayout), nil
+	}
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == '/' || r == '\'' || r == '-' || r == '.' })
+	if len(parts) != 3 {
+		return "", fmt.Errorf("unrecognised date %q", s)
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return "", fmt.Errorf("unrecognised date %q", s)
+		}
+		n[i] = v
+	}
+	month, day, year := n[0], n[1], n[2]
+	if year < 100 {
+		if strings.Contains(s, "'") || year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+	d := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if month < 1 || month > 12 || d.Day() != day {
+		return "", fmt.Errorf("unrecognised date %q", s)
+	}
+	return d.Format(dateLayout), nil
+}
+
+// QIFParser reads Quicken Interchange Format investment accounts
+// (!Type:Invst), using the file's security list for tickers. Records from
+// bank and card accounts are reported but not imported.
+type QIFParser struct{}
+
+func (p *QIFParser) Name() string { return "qif" }
+
+func (p *QIFParser) Description() string {
+	return "Quicken QIF export of investment accounts"
+}
+
+func (p *QIFParser) Parse(data []byte, opts ImportOptions) ([]ImportRow, error) {
+	records := readQIF(data)
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no QIF records found")
+	}
+
+	// Security list: name -> ticker and type
+	tickers, types := map[string]string{}, map[string]string{}
+	for _, rec := range records {
+		if rec.section == "type:security" {
+			name := strings.ToUpper(rec.fields['N'])
+			tickers[name], types[name] = strings.ToUpper(rec.fields['S']), strings.ToLower(rec.fields['T'])
+		}
+	}
+
+	var rows []ImportRow
+	for _, rec := range records {
+		switch {
+		case rec.section == "type:invst":
+			rows = append(rows, p.investment(rec, tickers, types, opts)...)
+		case strings.HasPrefix(rec.section, "type:") && rec.section != "type:security" &&
+			rec.section != "type:cat" && rec.section != "type:class" && rec.section != "type:memorized":
+			rows = append(rows, ImportRow{
+				Line: rec.line, Notes: strings.TrimSpace(rec.fields['P'] + " " + rec.fields['M']),
+				Status: ImportIgnored, Error: "cash transaction " + rec.fields['T'],
+			})
+		}
+	}
+	return rows, nil
+}
+
+// investment converts one !Type:Invst record. The "X" action variants only
+// differ in moving the cash to another account, so they map the same way.
+func (p *QIFParser) investment(rec qifRecord, tickers, types map[string]string, opts ImportOptions) []ImportRow {
+	f := rec.fields
+	action := f['N']
+	security := f['Y']
+	r := ImportRow{
+		Line:         rec.line,
+		SourceSymbol: security,
+		AssetType:    "Stock",
+		Notes:        strings.TrimSpace(security + " " + f['M']),
+	}
+	if t := tickers[strings.ToUpper(security)]; t != "" {
+		r.SourceSymbol = t
+	}
+	if security == "" {
+		r.Status, r.Error = ImportIgnored, "not a holding change: "+action
+		return []ImportRow{r}
+	}
+	switch types[strings.ToUpper(security)] {
+	case "bond", "option":
+		r.Status, r.Error = ImportIgnored, "bonds and options are not supported: "+security
+		return []ImportRow{r}
+	}
+
+	var errs []string
+	var err error
+	if r.Date, err = qifDate(f['D'], opts.DateFormat); err != nil {
+		errs = append(errs, err.Error())
+	}
+	num := func(code byte) float64 {
+		v, err := parseAmount(f[code])
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		return math.Abs(v)
+	}
+	qty, price, fees := num('Q'), num('I'), num('O')
+	total := num('T')
+	if total == 0 {
+		total = num('U')
+	}
+	if price == 0 && qty > 0 {
+		price = total / qty
+	}
+
+	lower := strings.TrimSuffix(strings.ToLower(action), "x")
+	var out []ImportRow
+	switch {
+	case lower == "buy" || lower == "cvrshrt":
+		r.Type, r.Quantity, r.Price, r.Fees = TxnBuy, qty, price, fees
+		if f['I'] == "" && qty > 0 {
+			r.Price = (total - fees) / qty
+		}
+		out = []ImportRow{r}
+	case lower == "sell" || lower == "shtsell":
+		r.Type, r.Quantity, r.Price, r.Fees = TxnSell, qty, price, fees
+		if f['I'] == "" && qty > 0 {
+			r.Price = (total + fees) / qty
+		}
+		out = []ImportRow{r}
+	case lower == "div" || strings.HasPrefix(lower, "cg"):
+		r.Type, r.Price = TxnDividend, total
+		out = []ImportRow{r}
+	case strings.HasPrefix(lower, "reinv"):
+		buy := r
+		buy.Type, buy.Quantity, buy.Price, buy.Fees = TxnBuy, qty, price, fees
+		r.Type, r.Price = TxnDividend, total
+		out = []ImportRow{r, buy}
+	case lower == "shrsin":
+		r.Type, r.Quantity, r.Price = TxnTransferIn, qty, price
+		out = []ImportRow{r}
+	case lower == "shrsout":
+		r.Type, r.Quantity, r.Price = TxnTransferOut, qty, price
+		out = []ImportRow{r}
+	case lower == "miscexp":
+		r.Type, r.Price = TxnFee, total
+		out = []ImportRow{r}
+	case lower == "stksplit":
+		// Quicken's split ratio encoding varies by version; splits come from
+		// corporate actions instead
+		r.Status, r.Error = ImportIgnored, "stock splits are applied from corporate actions"
+		return []ImportRow{r}
+	default:
+		r.Status, r.Error = ImportIgnored, "not a holding change: "+action
+		return []ImportRow{r}
+	}
+	if len(errs) > 0 {
+		for i := range out {
+			out[i].Status, out[i].Error = ImportInvalid, strings.Join(errs, "; ")
+		}
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadQIF(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []qifRecord
+	}{
+		{
+			name: "sections and records",
+			data: "!Type:Security\nNApple Inc\nSAAPL\n^\n!Type:Invst\nD1/5'23\nNBuy\nYApple Inc\nQ10\n^\nD1/6'23\nNSell\n^\n",
+			want: []qifRecord{
+				{section: "type:security", line: 2, fields: map[byte]string{'N': "Apple Inc", 'S': "AAPL"}},
+				{section: "type:invst", line: 6, fields: map[byte]string{'D': "1/5'23", 'N': "Buy", 'Y': "Apple Inc", 'Q': "10"}},
+				{section: "type:invst", line: 11, fields: map[byte]string{'D': "1/6'23", 'N': "Sell"}},
+			},
+		},
+		{
+			name: "bom, crlf, options and a last record without a caret",
+			data: "\ufeff!Option:AutoSwitch\r\n!Type:Invst\r\nD01/05/2023\r\nNDiv \r\n",
+			want: []qifRecord{
+				{section: "type:invst", line: 3, fields: map[byte]string{'D': "01/05/2023", 'N': "Div"}},
+			},
+		},
+		{
+			name: "split lines keep the first value",
+			data: "!Type:Bank\nD1/5'23\nSFood\nSRent\n^\n",
+			want: []qifRecord{
+				{section: "type:bank", line: 2, fields: map[byte]string{'D': "1/5'23", 'S': "Food"}},
+			},
+		},
+		{
+			name: "empty records are dropped",
+			data: "!Type:Invst\n^\n\n^\n",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readQIF([]byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readQIF() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQIFDate(t *testing.T) {
+	tests := []struct {
+		in, layout string
+		want       string
+		wantErr    bool
+	}{
+		{in: "1/5'23", want: "2023-01-05"},
+		{in: " 1/ 5/23", want: "2023-01-05"},
+		{in: "01/05/2023", want: "2023-01-05"},
+		{in: "12/31/99", want: "1999-12-31"},
+		{in: "12/31'99", want: "2099-12-31"},
+		{in: "6-15-2024", want: "2024-06-15"},
+		{in: "2024-06-15", want: "2024-06-15"},
+		{in: "05/01/2023", layout: "02/01/2006", want: "2023-01-05"},
+		{in: "2/30/23", wantErr: true},
+		{in: "13/1/23", wantErr: true},
+		{in: "1/5", wantErr: true},
+		{in: "Jan 5 2023", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := qifDate(tt.in, tt.layout)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("qifDate(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("qifDate(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxImportBytes caps an uploaded statement.
+const maxImportBytes = 5 << 20
+
+// Import row statuses
+const (
+	ImportNew       = "new"
+	ImportDuplicate = "duplicate" // already imported, skipped on commit
+	ImportUnmapped  = "unmapped"  // no ticker found for the source symbol
+	ImportInvalid   = "invalid"
+	ImportIgnored   = "ignored" // a line that is not a ledger event (cash movement, interest, ...)
+	ImportImported  = "imported"
+	ImportFailed    = "failed"
+)
+
+// ImportRow is one statement line normalised into a ledger entry. Parsers
+// fill Symbol when the ticker follows from the file alone (e.g. an NSE code
+// becomes "INFY.NS"); otherwise it is resolved from SourceSymbol or ISIN.
+type ImportRow struct {
+	Line         int     `json:"line"`
+	SourceSymbol string  `json:"sourceSymbol"`
+	ISIN         string  `json:"isin,omitempty"`
+	CUSIP        string  `json:"cusip,omitempty"`
+	Symbol       string  `json:"symbol"`
+	AssetType    string  `json:"assetType"`
+	Type         string  `json:"type"`
+	Date         string  `json:"date"`
+	Quantity     float64 `json:"quantity"`
+	Price        float64 `json:"price"`
+	Fees         float64 `json:"fees"`
+	Currency     string  `json:"currency"`
+	Notes        string  `json:"notes"`
+	ExternalID   string  `json:"externalId,omitempty"` // broker trade or order ID
+	Status       string  `json:"status"`
+	Error        string  `json:"error,omitempty"`
+	TxnID        int     `json:"txnId,omitempty"`
+
+	fingerprint string
+}
+
+// ImportOptions are the request parameters a parser may use.
+type ImportOptions struct {
+	Currency   string            // default currency when the file has none
+	AsOf       string            // date for holdings snapshots, which carry no trade dates
+	Columns    map[string]string // generic CSV: field -> column header
+	DateFormat string            // generic CSV: Go layout for the date column
+}
+
+// StatementParser turns one file format into import rows. Lines that parse
+// but are not ledger events should be returned with status ImportIgnored so
+// the preview accounts for every line.
+type StatementParser interface {
+	Name() string
+	Description() string
+	Parse(data []byte, opts ImportOptions) ([]ImportRow, error)
+}
+
+// statementParsers is the registry of supported formats, by Name().
+var statementParsers = []StatementParser{
+	&ZerodhaTradebookParser{},
+	&ZerodhaHoldingsParser{},
+	&GrowwParser{},
+	&VanguardParser{},
+	&SchwabParser{},
+	&CASParser{},
+	&OFXParser{},
+	&QIFParser{},
+	&GenericCSVParser{},
+}
+
+func parserFor(format string) (StatementParser, bool) {
+	for _, p := range statementParsers {
+		if strings.EqualFold(p.Name(), format) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// importFingerprint identifies a row across repeated imports of the same or
+// an overlapping file. Broker trade IDs are used when present; otherwise the
+// row's contents plus its occurrence number within the file, so two
+// identical fills on one day stay distinct but re-imports still match.
+func importFingerprint(format string, r ImportRow, occurrence int) string {
+	key := ""
+	if r.ExternalID != "" {
+		key = fmt.Sprintf("%s|id|%s", format, r.ExternalID)
+	} else {
+		key = fmt.Sprintf("%s|%s|%s|%s|%.6f|%.6f|%d", format, strings.ToUpper(r.SourceSymbol), r.Type, r.Date, r.Quantity, r.Price, occurrence)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// SymbolMapping pins a broker's name for an instrument (or its ISIN) to a ticker.
+type SymbolMapping struct {
+	Source string `json:"source"`
+	Symbol string `json:"symbol"`
+}
+
+func loadSymbolMappings(ctx context.Context, q dbQuerier, userID int) (map[string]string, error) {
+	rows, err := q.Query(ctx, "SELECT source, symbol FROM symbol_mappings WHERE user_id=$1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mappings := map[string]string{}
+	for rows.Next() {
+		var source, symbol string
+		if err := rows.Scan(&source, &symbol); err != nil {
+			return nil, err
+		}
+		mappings[strings.ToUpper(source)] = symbol
+	}
+	return mappings, rows.Err()
+}
+
+// resolveImportSymbols maps rows to tickers: the user's own mappings (by ISIN,
+// CUSIP, then source symbol) win, then whatever the parser derived, then for
+// mutual funds the AMFI scheme master by ISIN and finally a scheme-name
+// search. For other securities a ticker the parser could not vouch for must
+// match a search result exactly, failing which the CUSIP or ISIN is searched.
+func resolveImportSymbols(ctx context.Context, q dbQuerier, userID int, rows []ImportRow) error {
+	mappings, err := loadSymbolMappings(ctx, q, userID)
+	if err != nil {
+		return err
+	}
+	var fundISINs []string
+	for _, r := range rows {
+		if r.Status == "" && r.Symbol == "" && r.AssetType == "Mutual Fund" && r.ISIN != "" {
+			fundISINs = append(fundISINs, strings.ToUpper(r.ISIN))
+		}
+	}
+	schemes, err := schemeSymbolsByISIN(ctx, q, fundISINs)
+	if err != nil {
+		log.Printf("Import: %v", err)
+		schemes = map[string]string{}
+	}
+
+	searched := map[string]string{}
+	for i := range rows {
+		r := &rows[i]
+		if r.Status != "" {
+			continue
+		}
+		if s, ok := mappings[strings.ToUpper(r.ISIN)]; ok && r.ISIN != "" {
+			r.Symbol = s
+		} else if s, ok := mappings[strings.ToUpper(r.CUSIP)]; ok && r.CUSIP != "" {
+			r.Symbol = s
+		} else if s, ok := mappings[strings.ToUpper(r.SourceSymbol)]; ok {
+			r.Symbol = s
+		}
+		if r.Symbol == "" && r.AssetType == "Mutual Fund" {
+			r.Symbol = schemes[strings.ToUpper(r.ISIN)]
+		}
+		if r.Symbol == "" && r.AssetType == "Mutual Fund" && r.SourceSymbol != "" {
+			name := strings.ToUpper(r.SourceSymbol)
+			if _, done := searched[name]; !done {
+				searched[name] = searchSchemeCode(ctx, r.SourceSymbol)
+			}
+			r.Symbol = searched[name]
+		}
+		if r.Symbol == "" && r.AssetType != "Mutual Fund" {
+			for i, id := range []string{r.SourceSymbol, r.CUSIP, r.ISIN} {
+				if id == "" || r.Symbol != "" || (i == 0 && (id == r.CUSIP || id == r.ISIN)) {
+					continue
+				}
+				key := fmt.Sprintf("ticker|%t|%s", i == 0, strings.ToUpper(id))
+				if _, done := searched[key]; !done {
+					searched[key] = searchTicker(ctx, id, i == 0)
+				}
+				r.Symbol = searched[key]
+			}
+		}
+		if r.Symbol == "" {
+			r.Status = ImportUnmapped
+			r.Error = "no ticker for " + r.SourceSymbol + "; add a symbol mapping"
+		}
+	}
+	return nil
+}
+
+// searchSchemeCode returns the AMFI symbol of the first scheme whose name
+// matches, or "" when the search finds nothing.
+func searchSchemeCode(ctx context.Context, name string) string {
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	for _, r := range market.Search(reqCtx, name) {
+		if symbolScheme(r.Symbol) == "AMFI" {
+			return r.Symbol
+		}
+	}
+	return ""
+}
+
+// searchTicker returns the first non-fund search result for an identifier
+// such as a CUSIP or ISIN, or "" when the search finds nothing. With exact
+// set the query is a ticker and only that symbol is accepted, allowing for
+// Yahoo's "-" in place of a share-class dot.
+func searchTicker(ctx context.Context, query string, exact bool) string {
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	want := strings.ReplaceAll(strings.ToUpper(query), ".", "-")
+	for _, r := range market.Search(reqCtx, query) {
+		if symbolScheme(r.Symbol) == "AMFI" {
+			continue
+		}
+		if !exact || strings.ToUpper(r.Symbol) == want || strings.ToUpper(r.Symbol) == strings.ToUpper(query) {
+			return r.Symbol
+		}
+	}
+	return ""
+}
+
+// prepareImport parses the file, resolves symbols, validates each row and
+// marks those already imported. Rows come back in date order, which is the
+// order they are committed in. Symbol resolution may search providers and
+// download the scheme master, so call it before opening a transaction.
+func prepareImport(ctx context.Context, q dbQuerier, userID int, p StatementParser, data []byte, opts ImportOptions) ([]ImportRow, error) {
+	rows, err := p.Parse(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		r := &rows[i]
+		if r.Currency == "" {
+			r.Currency = opts.Currency
+		}
+		r.Currency = normalizeCurrency(r.Currency)
+	}
+	if err := resolveImportSymbols(ctx, q, userID, rows); err != nil {
+		return nil, err
+	}
+
+	occurrences := map[string]int{}
+	for i := range rows {
+		r := &rows[i]
+		key := fmt.Sprintf("%s|%s|%s|%.6f|%.6f", strings.ToUpper(r.SourceSymbol), r.Type, r.Date, r.Quantity, r.Price)
+		r.fingerprint = importFingerprint(p.Name(), *r, occurrences[key])
+		occurrences[key]++
+		if r.Status != "" {
+			continue
+		}
+		t := Transaction{Symbol: r.Symbol, Type: r.Type, Date: r.Date, Quantity: r.Quantity, Price: r.Price, Fees: r.Fees}
+		if err := validateTransaction(&t); err != nil {
+			r.Status, r.Error = ImportInvalid, err.Error()
+			continue
+		}
+		r.Status = ImportNew
+	}
+
+	if err := markDuplicates(ctx, q, userID, rows); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Date < rows[j].Date })
+	return rows, nil
+}
+
+// markDuplicates flags new rows whose fingerprint is already recorded.
+func markDuplicates(ctx context.Context, q dbQuerier, userID int, rows []ImportRow) error {
+	var fingerprints []string
+	for _, r := range rows {
+		if r.Status == ImportNew {
+			fingerprints = append(fingerprints, r.fingerprint)
+		}
+	}
+	seen, err := importedFingerprints(ctx, q, userID, fingerprints)
+	if err != nil {
+		return err
+	}
+	for i := range rows {
+		if rows[i].Status == ImportNew && seen[rows[i].fingerprint] {
+			rows[i].Status = ImportDuplicate
+		}
+	}
+	return nil
+}
+
+func importedFingerprints(ctx context.Context, q dbQuerier, userID int, fingerprints []string) (map[string]bool, error) {
+	seen := map[string]bool{}
+	if len(fingerprints) == 0 {
+		return seen, nil
+	}
+	rows, err := q.Query(ctx, "SELECT fingerprint FROM import_rows WHERE user_id=$1 AND fingerprint = ANY($2)", userID, fingerprints)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f string
+		if err := rows.Scan(&f); err != nil {
+			return nil, err
+		}
+		seen[f] = true
+	}
+	return seen, rows.Err()
+}
+
+// commitImport writes every new row to the ledger inside the caller's
+// transaction. Each row runs in a savepoint, so a sell that exceeds the
+// holding fails on its own without losing the rest of the file.
+func commitImport(ctx context.Context, tx pgx.Tx, userID int, format string, rows []ImportRow) error {
+	for i := range rows {
+		r := &rows[i]
+		if r.Status != ImportNew {
+			continue
+		}
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		t := Transaction{
+			UserID: userID, Symbol: r.Symbol, Type: r.Type, Date: r.Date, Quantity: r.Quantity,
+			Price: r.Price, Fees: r.Fees, Currency: r.Currency, Notes: r.Notes, AssetType: r.AssetType,
+		}
+		err = insertTransaction(ctx, sp, &t, "")
+		if err == nil {
+			_, err = sp.Exec(ctx, "INSERT INTO import_rows (user_id, fingerprint, format, txn_id) VALUES ($1, $2, $3, $4)", userID, r.fingerprint, format, t.ID)
+		}
+		if err != nil {
+			sp.Rollback(ctx)
+			r.Status, r.Error = ImportFailed, err.Error()
+			continue
+		}
+		if err := sp.Commit(ctx); err != nil {
+			return err
+		}
+		r.Status, r.TxnID = ImportImported, t.ID
+	}
+	return nil
+}
+
+func importSummary(rows []ImportRow) map[string]int {
+	counts := map[string]int{"total": len(rows)}
+	for _, r := range rows {
+		counts[r.Status]++
+	}
+	return counts
+}
+
+// --- SHARED PARSING HELPERS ---
+
+// parseAmount reads numbers as brokers print them: currency symbols,
+// thousands separators and accounting-style negatives in parentheses.
+func parseAmount(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.Trim(s, "()")
+	s = strings.NewReplacer(",", "", "$", "", "₹", "", "£", "", "€", "", "INR", "", "USD", "", " ", "").Replace(s)
+	if s == "" || s == "-" || s == "--" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", s)
+	}
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+// statementDateLayouts are tried in order by parseStatementDate.
+var statementDateLayouts = []string{
+	dateLayout,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"01/02/2006",
+	"1/2/2006",
+	"02-01-2006",
+	"02-01-2006 03:04 PM",
+	"02-Jan-2006",
+	"02 Jan 2006",
+	"2 Jan 2006",
+	"Jan 2, 2006",
+	"20060102",
+}
+
+// dayFirstDateLayouts are for exports that write numeric dates day first,
+// as Indian brokers and fund houses do.
+var dayFirstDateLayouts = []string{
+	dateLayout,
+	"02/01/2006",
+	"2/1/2006",
+	"02-01-2006",
+	"02-Jan-2006",
+	"02 Jan 2006",
+	"2 Jan 2006",
+}
+
+// parseStatementDate accepts the given layout, or any common statement
+// layout when layout is empty, and returns YYYY-MM-DD. Slash dates are read
+// US-style (month first), which is what the US brokers that use them export.
+func parseStatementDate(s, layout string) (string, error) {
+	layouts := statementDateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	return parseDateIn(s, layouts)
+}
+
+// parseDateIn is parseStatementDate over an explicit list of layouts.
+func parseDateIn(s string, layouts []string) (string, error) {
+	s = strings.TrimSpace(s)
+	// Schwab writes "04/12/2023 as of 04/11/2023"; the first date is the trade date
+	if before, _, ok := strings.Cut(s, " as of "); ok {
+		s = before
+	}
+	for _, l := range layouts {
+		if d, err := time.Parse(l, s); err == nil {
+			return d.Format(dateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("unrecognised date %q", s)
+}
+
+// normalizeTxnType maps the many ways brokers spell trade sides onto ledger types.
+func normalizeTxnType(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case s == "b" || s == "buy" || s == "bought" || strings.HasPrefix(s, "purchase") || s == "sip" || strings.Contains(s, "reinvest"):
+		return TxnBuy
+	case s == "s" || s == "sell" || s == "sold" || strings.HasPrefix(s, "redemption") || strings.HasPrefix(s, "redeem"):
+		return TxnSell
+	case strings.Contains(s, "dividend") || strings.Contains(s, "idcw") || strings.Contains(s, "distribution"):
+		return TxnDividend
+	case strings.Contains(s, "switch in") || strings.Contains(s, "transfer in") || s == "transfer_in":
+		return TxnTransferIn
+	case strings.Contains(s, "switch out") || strings.Contains(s, "transfer out") || s == "transfer_out":
+		return TxnTransferOut
+	case s == "fee" || s == "fees":
+		return TxnFee
+	case s == "split":
+		return TxnSplit
+	}
+	return ""
+}
+
+// --- ROUTES ---
+
+// readImportRequest reads the multipart upload shared by preview and commit:
+// file, format, and optional currency, asOf, columns (JSON) and dateFormat.
+func readImportRequest(c *gin.Context) (StatementParser, []byte, ImportOptions, error) {
+	var opts ImportOptions
+	p, ok := parserFor(c.PostForm("format"))
+	if !ok {
+		return nil, nil, opts, fmt.Errorf("unknown format %q; see GET /api/import/formats", c.PostForm("format"))
+	}
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return nil, nil, opts, fmt.Errorf("file is required")
+	}
+	if fh.Size > maxImportBytes {
+		return nil, nil, opts, fmt.Errorf("file is larger than %d MB", maxImportBytes>>20)
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, nil, opts, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxImportBytes))
+	if err != nil {
+		return nil, nil, opts, err
+	}
+
+	opts.Currency = strings.ToUpper(c.PostForm("currency"))
+	if opts.Currency != "" && !validCurrencyCode(opts.Currency) {
+		return nil, nil, opts, fmt.Errorf("currency must be a three-letter currency code")
+	}
+	// Left empty when omitted so each parser can fall back on its own default
+	opts.AsOf = c.PostForm("asOf")
+	if _, err := time.Parse(dateLayout, opts.AsOf); opts.AsOf != "" && err != nil {
+		return nil, nil, opts, fmt.Errorf("asOf must be YYYY-MM-DD")
+	}
+	if cols := c.PostForm("columns"); cols != "" {
+		if err := json.Unmarshal([]byte(cols), &opts.Columns); err != nil {
+			return nil, nil, opts, fmt.Errorf("columns must be a JSON object of field to column name")
+		}
+	}
+	opts.DateFormat = c.PostForm("dateFormat")
+	return p, data, opts, nil
+}
+
+func registerImportRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/import/formats - Supported statement formats
+	api.GET("/import/formats", func(c *gin.Context) {
+		formats := make([]gin.H, 0, len(statementParsers))
+		for _, p := range statementParsers {
+			formats = append(formats, gin.H{"format": p.Name(), "description": p.Description()})
+		}
+		c.JSON(http.StatusOK, formats)
+	})
+
+	// POST /api/import/preview - Dry run: what an upload would add, skip or reject
+	api.POST("/import/preview", func(c *gin.Context) {
+		p, data, opts, err := readImportRequest(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rows, err := prepareImport(context.Background(), dbPool, currentUserID(c), p, data, opts)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"format": p.Name(), "summary": importSummary(rows), "rows": rows})
+	})
+
+	// POST /api/import/commit - Write the new rows of an upload to the ledger; safe to repeat
+	api.POST("/import/commit", func(c *gin.Context) {
+		p, data, opts, err := readImportRequest(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+
+		ctx := context.Background()
+		rows, err := prepareImport(ctx, dbPool, userID, p, data, opts)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		// A concurrent commit of the same file may have landed since the check above
+		if err := markDuplicates(ctx, tx, userID, rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if err := commitImport(ctx, tx, userID, p.Name(), rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import"})
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"format": p.Name(), "summary": importSummary(rows), "rows": rows})
+	})
+
+	// GET /api/import/mappings - The user's source symbol / ISIN to ticker mappings
+	api.GET("/import/mappings", func(c *gin.Context) {
+		mappings, err := loadSymbolMappings(context.Background(), dbPool, currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		list := make([]SymbolMapping, 0, len(mappings))
+		for source, symbol := range mappings {
+			list = append(list, SymbolMapping{Source: source, Symbol: symbol})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Source < list[j].Source })
+		c.JSON(http.StatusOK, list)
+	})
+
+	// PUT /api/import/mappings - Add or change mappings; an empty symbol removes one
+	api.PUT("/import/mappings", func(c *gin.Context) {
+		var input []SymbolMapping
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+
+		ctx := context.Background()
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		for _, m := range input {
+			source := strings.ToUpper(strings.TrimSpace(m.Source))
+			symbol := strings.TrimSpace(m.Symbol)
+			if source == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "every mapping needs a source"})
+				return
+			}
+			if symbol == "" {
+				_, err = tx.Exec(ctx, "DELETE FROM symbol_mappings WHERE user_id=$1 AND source=$2", userID, source)
+			} else {
+				_, err = tx.Exec(ctx, `
+					INSERT INTO symbol_mappings (user_id, source, symbol) VALUES ($1, $2, $3)
+					ON CONFLICT (user_id, source) DO UPDATE SET symbol=EXCLUDED.symbol`, userID, source, symbol)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save mappings"})
+				return
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save mappings"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Mappings saved"})
+	})
+}
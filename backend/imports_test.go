@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestImportFingerprint(t *testing.T) {
+	base := ImportRow{SourceSymbol: "INFY", Type: TxnBuy, Date: "2024-01-05", Quantity: 10, Price: 1500.5}
+	with := func(change func(r *ImportRow)) ImportRow {
+		r := base
+		change(&r)
+		return r
+	}
+
+	tests := []struct {
+		name      string
+		format    string
+		row       ImportRow
+		occurs    int
+		wantEqual bool // to base's fingerprint in zerodha-tradebook, occurrence 0
+	}{
+		{name: "same row", format: "zerodha-tradebook", row: base, wantEqual: true},
+		{name: "source symbol case", format: "zerodha-tradebook", row: with(func(r *ImportRow) { r.SourceSymbol = "infy" }), wantEqual: true},
+		{name: "resolved symbol is not part of it", format: "zerodha-tradebook", row: with(func(r *ImportRow) { r.Symbol = "INFY.NS" }), wantEqual: true},
+		{name: "second identical fill", format: "zerodha-tradebook", row: base, occurs: 1},
+		{name: "other format", format: "groww", row: base},
+		{name: "other quantity", format: "zerodha-tradebook", row: with(func(r *ImportRow) { r.Quantity = 11 })},
+		{name: "other price", format: "zerodha-tradebook", row: with(func(r *ImportRow) { r.Price = 1500.51 })},
+		{name: "other date", format: "zerodha-tradebook", row: with(func(r *ImportRow) { r.Date = "2024-01-06" })},
+		{name: "other type", format: "zerodha-tradebook", row: with(func(r *ImportRow) { r.Type = TxnSell })},
+		{name: "broker trade id", format: "zerodha-tradebook", row: with(func(r *ImportRow) { r.ExternalID = "T1/O1" })},
+	}
+	want := importFingerprint("zerodha-tradebook", base, 0)
+	if b, err := hex.DecodeString(want); err != nil || len(b) != 32 {
+		t.Fatalf("fingerprint %q is not a hex SHA-256", want)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := importFingerprint(tt.format, tt.row, tt.occurs)
+			if (got == want) != tt.wantEqual {
+				t.Errorf("importFingerprint() equal = %t, want %t", got == want, tt.wantEqual)
+			}
+		})
+	}
+}
+
+func TestImportFingerprintExternalID(t *testing.T) {
+	a := ImportRow{SourceSymbol: "INFY", Type: TxnBuy, Date: "2024-01-05", Quantity: 10, Price: 1500, ExternalID: "T1/O1"}
+	b := ImportRow{SourceSymbol: "INFY-BE", Type: TxnBuy, Date: "2024-01-06", Quantity: 12, Price: 1490, ExternalID: "T1/O1"}
+	if importFingerprint("zerodha-tradebook", a, 0) != importFingerprint("zerodha-tradebook", b, 3) {
+		t.Error("rows with the same broker trade ID should share a fingerprint")
+	}
+	c := b
+	c.ExternalID = "T2/O1"
+	if importFingerprint("zerodha-tradebook", a, 0) == importFingerprint("zerodha-tradebook", c, 0) {
+		t.Error("rows with different broker trade IDs should not share a fingerprint")
+	}
+}
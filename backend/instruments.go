@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// instrumentMetadataTTL is how long a stored profile is trusted before it is
+// fetched again; sectors and categories rarely change, market caps drift.
+const instrumentMetadataTTL = 30 * 24 * time.Hour
+
+const unclassified = "Unclassified"
+
+// Market-cap bucket thresholds in USD
+const (
+	largeCapUSD = 10e9
+	midCapUSD   = 2e9
+	smallCapUSD = 300e6
+)
+
+// allocationDimensions are the groupings /api/analytics/allocation supports.
+var allocationDimensions = []string{"type", "currency", "exchange", "sector", "industry", "country", "marketCap", "fundCategory"}
+
+func marketCapBucket(capUSD float64) string {
+	switch {
+	case capUSD <= 0:
+		return ""
+	case capUSD >= largeCapUSD:
+		return "Large Cap"
+	case capUSD >= midCapUSD:
+		return "Mid Cap"
+	case capUSD >= smallCapUSD:
+		return "Small Cap"
+	}
+	return "Micro Cap"
+}
+
+func loadInstrumentProfile(ctx context.Context, q dbQuerier, symbol string) (InstrumentProfile, time.Time, error) {
+	p := InstrumentProfile{Symbol: symbol}
+	var updated time.Time
+	err := q.QueryRow(ctx, `
+		SELECT name, quote_type, sector, industry, country, market_cap, market_cap_currency, market_cap_bucket,
+			fund_category, expense_ratio, source, updated_at
+		FROM instrument_metadata WHERE symbol=$1`, symbol).Scan(
+		&p.Name, &p.QuoteType, &p.Sector, &p.Industry, &p.Country, &p.MarketCap, &p.MarketCapCurrency,
+		&p.MarketCapBucket, &p.FundCategory, &p.ExpenseRatio, &p.Source, &updated)
+	return p, updated, err
+}
+
+func storeInstrumentProfile(ctx context.Context, q dbQuerier, p InstrumentProfile) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO instrument_metadata (symbol, name, quote_type, sector, industry, country, market_cap, market_cap_currency,
+			market_cap_bucket, fund_category, expense_ratio, source, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW())
+		ON CONFLICT (symbol) DO UPDATE SET name=EXCLUDED.name, quote_type=EXCLUDED.quote_type, sector=EXCLUDED.sector,
+			industry=EXCLUDED.industry, country=EXCLUDED.country, market_cap=EXCLUDED.market_cap,
+			market_cap_currency=EXCLUDED.market_cap_currency, market_cap_bucket=EXCLUDED.market_cap_bucket,
+			fund_category=EXCLUDED.fund_category, expense_ratio=EXCLUDED.expense_ratio, source=EXCLUDED.source, updated_at=NOW()`,
+		p.Symbol, p.Name, p.QuoteType, p.Sector, p.Industry, p.Country, p.MarketCap, p.MarketCapCurrency,
+		p.MarketCapBucket, p.FundCategory, p.ExpenseRatio, p.Source)
+	return err
+}
+
+// refreshInstrumentProfile fetches a profile from the symbol's provider,
+// buckets its market cap in USD terms and stores it.
+func refreshInstrumentProfile(ctx context.Context, dbPool *pgxpool.Pool, symbol string) (InstrumentProfile, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	p, err := market.Profile(reqCtx, symbol)
+	if err != nil {
+		return p, err
+	}
+	if p.MarketCapBucket == "" && p.MarketCap > 0 {
+		if rate, err := usdRate(ctx, dbPool, p.MarketCapCurrency, time.Now().UTC()); err == nil && rate > 0 {
+			p.MarketCapBucket = marketCapBucket(p.MarketCap / rate)
+		}
+	}
+	return p, storeInstrumentProfile(ctx, dbPool, p)
+}
+
+// instrumentProfiles returns stored profiles for the symbols, or an empty
+// profile for any not yet fetched. It never calls a provider, so request
+// handlers stay fast; the instrument-metadata job keeps the rows current.
+func instrumentProfiles(ctx context.Context, dbPool *pgxpool.Pool, symbols []string) map[string]InstrumentProfile {
+	profiles := map[string]InstrumentProfile{}
+	for _, s := range symbols {
+		p, _, err := loadInstrumentProfile(ctx, dbPool, s)
+		if err != nil && err != pgx.ErrNoRows {
+			log.Printf("Instrument metadata for %s: %v", s, err)
+		}
+		profiles[s] = p
+	}
+	return profiles
+}
+
+// refreshAllInstrumentProfiles re-fetches profiles for every tracked symbol
+// that is missing or older than instrumentMetadataTTL. A failed refresh keeps
+// the stale row, if any, and is retried on the next run.
+func refreshAllInstrumentProfiles(ctx context.Context, dbPool *pgxpool.Pool) error {
+	tracked, err := trackedSymbols(ctx, dbPool)
+	if err != nil {
+		return err
+	}
+	symbols := make([]string, 0, len(tracked))
+	for s := range tracked {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	for _, s := range symbols {
+		_, updated, err := loadInstrumentProfile(ctx, dbPool, s)
+		if err != nil && err != pgx.ErrNoRows {
+			log.Printf("Instrument metadata for %s: %v", s, err)
+		}
+		if err == nil && time.Since(updated) <= instrumentMetadataTTL {
+			continue
+		}
+		if _, err := refreshInstrumentProfile(ctx, dbPool, s); err != nil {
+			log.Printf("Instrument profile for %s unavailable: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// AllocationGroup is one slice of the portfolio along a dimension.
+type AllocationGroup struct {
+	Key     string   `json:"key"`
+	Value   float64  `json:"value"`
+	Weight  float64  `json:"weight"`
+	Symbols []string `json:"symbols"`
+}
+
+// allocationKey classifies a holding along one dimension.
+func allocationKey(dimension, assetType, currency, symbol string, p InstrumentProfile) string {
+	key := ""
+	switch dimension {
+	case "type":
+		key = assetType
+	case "currency":
+		key = currency
+	case "exchange":
+		if e := exchangeForSymbol(symbol); e != nil {
+			key = e.Code
+		}
+	case "sector":
+		key = p.Sector
+	case "industry":
+		key = p.Industry
+	case "country":
+		key = p.Country
+	case "marketCap":
+		key = p.MarketCapBucket
+	case "fundCategory":
+		key = p.FundCategory
+	}
+	if key == "" {
+		return unclassified
+	}
+	return key
+}
+
+// --- ROUTES ---
+
+func registerAllocationRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/analytics/allocation?by=sector&currency= - Portfolio weights grouped by one or every dimension
+	api.GET("/analytics/allocation", func(c *gin.Context) {
+		ctx := context.Background()
+		userID := currentUserID(c)
+
+		settings, err := loadUserSettings(ctx, dbPool, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		base := settings.BaseCurrency
+		if override := strings.ToUpper(c.Query("currency")); override != "" {
+			if !validCurrencyCode(override) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a three-letter currency code"})
+				return
+			}
+			base = override
+		}
+
+		dimensions := allocationDimensions
+		if by := c.Query("by"); by != "" {
+			found := false
+			for _, d := range allocationDimensions {
+				if strings.EqualFold(d, by) {
+					dimensions, found = []string{d}, true
+				}
+			}
+			if !found {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("by must be one of %s", strings.Join(allocationDimensions, ", "))})
+				return
+			}
+		}
+
+		rows, err := dbPool.Query(ctx, `
+			SELECT name, COALESCE(asset_type, ''), quantity * current_price, currency
+			FROM assets WHERE user_id=$1 AND quantity > 0 ORDER BY name`, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		type held struct {
+			symbol, assetType, currency string
+			value                       float64
+		}
+		var holdings []held
+		var symbols []string
+		for rows.Next() {
+			var h held
+			if err := rows.Scan(&h.symbol, &h.assetType, &h.value, &h.currency); err != nil {
+				rows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			h.currency = normalizeCurrency(h.currency)
+			holdings = append(holdings, h)
+			symbols = append(symbols, h.symbol)
+		}
+		rows.Close()
+
+		profiles := instrumentProfiles(ctx, dbPool, symbols)
+		fx := newFXConverter(ctx, dbPool, base)
+
+		total, expenseWeighted, fundValue := 0.0, 0.0, 0.0
+		groups := map[string]map[string]*AllocationGroup{}
+		for _, d := range dimensions {
+			groups[d] = map[string]*AllocationGroup{}
+		}
+		for _, h := range holdings {
+			rate, ok := fx.now(h.currency)
+			if !ok {
+				continue
+			}
+			value := h.value * rate
+			total += value
+			p := profiles[h.symbol]
+			if p.ExpenseRatio != nil {
+				expenseWeighted += *p.ExpenseRatio * value
+				fundValue += value
+			}
+			for _, d := range dimensions {
+				key := allocationKey(d, h.assetType, h.currency, h.symbol, p)
+				g, ok := groups[d][key]
+				if !ok {
+					g = &AllocationGroup{Key: key, Symbols: []string{}}
+					groups[d][key] = g
+				}
+				g.Value += value
+				g.Symbols = append(g.Symbols, h.symbol)
+			}
+		}
+
+		allocation := gin.H{}
+		for d, byKey := range groups {
+			list := make([]*AllocationGroup, 0, len(byKey))
+			for _, g := range byKey {
+				if total > 0 {
+					g.Weight = g.Value / total
+				}
+				list = append(list, g)
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i].Value > list[j].Value })
+			allocation[d] = list
+		}
+
+		// Value-weighted expense ratio across holdings that report one
+		var expenseRatio *float64
+		if fundValue > 0 {
+			r := expenseWeighted / fundValue
+			expenseRatio = &r
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"baseCurrency":         base,
+			"total":                total,
+			"allocation":           allocation,
+			"profiles":             profiles,
+			"weightedExpenseRatio": expenseRatio,
+			"missingRates":         fx.missingRates(),
+		})
+	})
+}
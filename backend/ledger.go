@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Ledger transaction types
+const (
+	TxnBuy         = "buy"
+	TxnSell        = "sell"
+	TxnDividend    = "dividend"
+	TxnFee         = "fee"
+	TxnSplit       = "split"
+	TxnTransferIn  = "transfer_in"
+	TxnTransferOut = "transfer_out"
+)
+
+const dateLayout = "2006-01-02"
+
+// Transaction is one entry in a user's ledger. Holdings in the assets table are
+// derived by replaying these in date order.
+//
+// For splits, Quantity holds the ratio of new shares per old share (2 for a
+// 2-for-1 split, 0.1 for a 1-for-10 reverse split) and Price is unused.
+// Dividends and fees carry their cash amount as Quantity × Price, or just
+// Price when Quantity is zero. On a dividend, Fees is the tax withheld.
+type Transaction struct {
+	ID        int     `json:"id"`
+	UserID    int     `json:"userId"`
+	Symbol    string  `json:"symbol"`
+	Type      string  `json:"type"`
+	Date      string  `json:"date"`
+	Quantity  float64 `json:"quantity"`
+	Price     float64 `json:"price"`
+	Fees      float64 `json:"fees"`
+	Currency  string  `json:"currency"`
+	Notes     string  `json:"notes"`
+	AssetType string  `json:"assetType,omitempty"`
+}
+
+// Holding is the position produced by replaying a ledger.
+type Holding struct {
+	Quantity float64
+	AvgPrice float64
+}
+
+func validateTransaction(t *Transaction) error {
+	t.Symbol = strings.TrimSpace(t.Symbol)
+	t.Type = strings.ToLower(strings.TrimSpace(t.Type))
+	if t.Symbol == "" {
+		return fmt.Errorf("symbol is required")
+	}
+	if t.Date == "" {
+		t.Date = time.Now().Format(dateLayout)
+	}
+	if _, err := time.Parse(dateLayout, t.Date); err != nil {
+		return fmt.Errorf("date must be YYYY-MM-DD")
+	}
+	if t.Quantity < 0 || t.Price < 0 || t.Fees < 0 {
+		return fmt.Errorf("quantity, price and fees cannot be negative")
+	}
+
+	switch t.Type {
+	case TxnBuy, TxnSell, TxnTransferIn, TxnTransferOut:
+		if t.Quantity <= 0 {
+			return fmt.Errorf("quantity must be positive for %s", t.Type)
+		}
+	case TxnSplit:
+		if t.Quantity <= 0 {
+			return fmt.Errorf("split ratio must be positive")
+		}
+	case TxnDividend, TxnFee:
+	default:
+		return fmt.Errorf("unknown transaction type %q", t.Type)
+	}
+	return nil
+}
+
+// replayLedger folds transactions (already sorted by date) into a holding using
+// average-cost accounting. Fees on buys are capitalised into the cost basis.
+func replayLedger(txns []Transaction) (Holding, error) {
+	var qty, cost float64
+	for _, t := range txns {
+		switch t.Type {
+		case TxnBuy, TxnTransferIn:
+			qty += t.Quantity
+			cost += t.Quantity*t.Price + t.Fees
+		case TxnSell, TxnTransferOut:
+			if t.Quantity > qty+1e-9 {
+				return Holding{}, fmt.Errorf("%s of %.4f %s on %s exceeds holding of %.4f", t.Type, t.Quantity, t.Symbol, t.Date, qty)
+			}
+			if qty > 0 {
+				cost -= cost * (t.Quantity / qty)
+			}
+			qty -= t.Quantity
+		case TxnSplit:
+			qty *= t.Quantity
+		}
+	}
+
+	if qty < 1e-9 {
+		return Holding{}, nil
+	}
+	return Holding{Quantity: qty, AvgPrice: cost / qty}, nil
+}
+
+func loadTransactions(ctx context.Context, q dbQuerier, userID int, symbol string) ([]Transaction, error) {
+	rows, err := q.Query(ctx, `
+		SELECT id, user_id, symbol, txn_type, to_char(trade_date, 'YYYY-MM-DD'), quantity, price, fees, currency, COALESCE(notes, '')
+		FROM transactions WHERE user_id=$1 AND ($2 = '' OR symbol=$2)
+		ORDER BY trade_date, id`, userID, symbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []Transaction
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Symbol, &t.Type, &t.Date, &t.Quantity, &t.Price, &t.Fees, &t.Currency, &t.Notes); err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+	}
+	return txns, rows.Err()
+}
+
+// ensureHoldingRow makes sure an assets row exists for the symbol so that the
+// price updater and portfolio views pick it up, and returns its currency. A
+// new row takes the given currency, resolved by the caller before its
+// transaction began, or else whatever is stored for the symbol.
+func ensureHoldingRow(ctx context.Context, q dbQuerier, userID int, symbol, nickname, assetType, currency string, price float64) (string, error) {
+	if currency != "" {
+		currency = normalizeCurrency(currency)
+	}
+	var existing string
+	err := q.QueryRow(ctx, "SELECT currency FROM assets WHERE name=$1 AND user_id=$2 LIMIT 1", symbol, userID).Scan(&existing)
+	if err == nil {
+		return existing, nil
+	}
+	if err != pgx.ErrNoRows {
+		return "", err
+	}
+
+	if currency == "" {
+		currency = storedCurrency(ctx, q, symbol)
+	}
+	_, err = q.Exec(ctx,
+		`INSERT INTO assets (user_id, name, nickname, asset_type, quantity, avg_price, current_price, previous_close, currency) VALUES ($1, $2, $3, $4, 0, 0, $5, $5, $6)`,
+		userID, symbol, nickname, assetType, price, currency)
+	return currency, err
+}
+
+// rebuildHolding replays the user's ledger for a symbol and writes the
+// resulting quantity and average price back to the assets row.
+func rebuildHolding(ctx context.Context, q dbQuerier, userID int, symbol string) error {
+	txns, err := loadTransactions(ctx, q, userID, symbol)
+	if err != nil {
+		return err
+	}
+	h, err := replayLedger(txns)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, "UPDATE assets SET quantity=$1, avg_price=$2 WHERE name=$3 AND user_id=$4", h.Quantity, h.AvgPrice, symbol, userID)
+	return err
+}
+
+// insertTransaction stores a validated transaction and rebuilds the affected
+// holding inside the caller's transaction. Set t.Currency beforehand (see
+// resolveCurrency) when the trade may open a new holding.
+func insertTransaction(ctx context.Context, tx dbQuerier, t *Transaction, nickname string) error {
+	currency, err := ensureHoldingRow(ctx, tx, t.UserID, t.Symbol, nickname, t.AssetType, t.Currency, t.Price)
+	if err != nil {
+		return err
+	}
+	if t.Currency == "" {
+		t.Currency = currency
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO transactions (user_id, symbol, txn_type, trade_date, quantity, price, fees, currency, notes)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		t.UserID, t.Symbol, t.Type, t.Date, t.Quantity, t.Price, t.Fees, t.Currency, t.Notes).Scan(&t.ID)
+	if err != nil {
+		return err
+	}
+	if err := invalidateSnapshots(ctx, tx, t.UserID, t.Date); err != nil {
+		return err
+	}
+	return rebuildHolding(ctx, tx, t.UserID, t.Symbol)
+}
+
+// --- ROUTES ---
+
+func registerTransactionRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/transactions?symbol= - List the user's ledger, optionally for one symbol
+	api.GET("/transactions", func(c *gin.Context) {
+		txns, err := loadTransactions(context.Background(), dbPool, currentUserID(c), c.Query("symbol"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		if txns == nil {
+			txns = []Transaction{}
+		}
+		c.JSON(http.StatusOK, txns)
+	})
+
+	// POST /api/transactions - Record a trade or cash event
+	api.POST("/transactions", func(c *gin.Context) {
+		var t Transaction
+		if err := c.ShouldBindJSON(&t); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateTransaction(&t); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		t.UserID = currentUserID(c)
+
+		ctx := context.Background()
+		if t.Currency == "" {
+			t.Currency = resolveCurrency(ctx, dbPool, t.UserID, t.Symbol)
+		}
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		if err := insertTransaction(ctx, tx, &t, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save transaction"})
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	})
+
+	// PUT /api/transactions/:id - Correct an existing ledger entry
+	api.PUT("/transactions/:id", func(c *gin.Context) {
+		var t Transaction
+		if err := c.ShouldBindJSON(&t); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+
+		ctx := context.Background()
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		// The symbol of an existing entry cannot change; move it by deleting and re-adding
+		var oldDate string
+		err = tx.QueryRow(ctx, "SELECT symbol, currency, to_char(trade_date, 'YYYY-MM-DD') FROM transactions WHERE id=$1 AND user_id=$2", c.Param("id"), userID).Scan(&t.Symbol, &t.Currency, &oldDate)
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if err := validateTransaction(&t); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		_, err = tx.Exec(ctx,
+			`UPDATE transactions SET txn_type=$1, trade_date=$2, quantity=$3, price=$4, fees=$5, notes=$6 WHERE id=$7 AND user_id=$8`,
+			t.Type, t.Date, t.Quantity, t.Price, t.Fees, t.Notes, c.Param("id"), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transaction"})
+			return
+		}
+		if err := rebuildHolding(ctx, tx, userID, t.Symbol); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := invalidateSnapshots(ctx, tx, userID, min(oldDate, t.Date)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transaction"})
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transaction"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Transaction updated!"})
+	})
+
+	// DELETE /api/transactions/:id - Remove a ledger entry and re-derive the holding
+	api.DELETE("/transactions/:id", func(c *gin.Context) {
+		userID := currentUserID(c)
+
+		ctx := context.Background()
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var symbol, date string
+		err = tx.QueryRow(ctx, "DELETE FROM transactions WHERE id=$1 AND user_id=$2 RETURNING symbol, to_char(trade_date, 'YYYY-MM-DD')", c.Param("id"), userID).Scan(&symbol, &date)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		if err := rebuildHolding(ctx, tx, userID, symbol); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := invalidateSnapshots(ctx, tx, userID, date); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete transaction"})
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete transaction"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Transaction deleted"})
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReplayLedger(t *testing.T) {
+	tests := []struct {
+		name    string
+		txns    []Transaction
+		want    Holding
+		wantErr bool
+	}{
+		{
+			name: "buys average with fees capitalised",
+			txns: []Transaction{
+				{Type: TxnBuy, Date: "2023-01-01", Quantity: 10, Price: 100, Fees: 10},
+				{Type: TxnBuy, Date: "2023-02-01", Quantity: 10, Price: 120},
+			},
+			want: Holding{Quantity: 20, AvgPrice: 110.5},
+		},
+		{
+			name: "sell keeps the average",
+			txns: []Transaction{
+				{Type: TxnBuy, Date: "2023-01-01", Quantity: 10, Price: 100},
+				{Type: TxnSell, Date: "2023-02-01", Quantity: 4, Price: 150},
+			},
+			want: Holding{Quantity: 6, AvgPrice: 100},
+		},
+		{
+			name: "split scales quantity and price",
+			txns: []Transaction{
+				{Type: TxnBuy, Date: "2023-01-01", Quantity: 10, Price: 100},
+				{Type: TxnSplit, Date: "2023-02-01", Quantity: 2},
+			},
+			want: Holding{Quantity: 20, AvgPrice: 50},
+		},
+		{
+			name: "transfers move cost",
+			txns: []Transaction{
+				{Type: TxnTransferIn, Date: "2023-01-01", Quantity: 5, Price: 40},
+				{Type: TxnTransferOut, Date: "2023-02-01", Quantity: 2, Price: 40},
+			},
+			want: Holding{Quantity: 3, AvgPrice: 40},
+		},
+		{
+			name: "dividends and fees leave the position alone",
+			txns: []Transaction{
+				{Type: TxnBuy, Date: "2023-01-01", Quantity: 10, Price: 100},
+				{Type: TxnDividend, Date: "2023-02-01", Quantity: 10, Price: 1.5},
+				{Type: TxnFee, Date: "2023-03-01", Price: 5},
+			},
+			want: Holding{Quantity: 10, AvgPrice: 100},
+		},
+		{
+			name: "fully sold",
+			txns: []Transaction{
+				{Type: TxnBuy, Date: "2023-01-01", Quantity: 10, Price: 100},
+				{Type: TxnSell, Date: "2023-02-01", Quantity: 10, Price: 90},
+			},
+			want: Holding{},
+		},
+		{
+			name: "oversold",
+			txns: []Transaction{
+				{Type: TxnBuy, Date: "2023-01-01", Quantity: 10, Price: 100},
+				{Type: TxnSell, Date: "2023-02-01", Quantity: 11, Price: 90},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replayLedger(tt.txns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replayLedger() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(got.Quantity-tt.want.Quantity) > 1e-9 || math.Abs(got.AvgPrice-tt.want.AvgPrice) > 1e-9 {
+				t.Errorf("replayLedger() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Lot matching methods used to decide which open lots a sell consumes
+const (
+	LotFIFO     = "fifo"
+	LotLIFO     = "lifo"
+	LotHIFO     = "hifo" // highest cost first
+	LotSpecific = "specific"
+)
+
+func validLotMethod(m string) bool {
+	return m == LotFIFO || m == LotLIFO || m == LotHIFO || m == LotSpecific
+}
+
+// OpenLot is the unsold remainder of a buy or transfer-in.
+type OpenLot struct {
+	LotID        int     `json:"lotId"`
+	Symbol       string  `json:"symbol"`
+	OpenDate     string  `json:"openDate"`
+	Quantity     float64 `json:"quantity"`
+	CostPerUnit  float64 `json:"costPerUnit"`
+	CostBasis    float64 `json:"costBasis"`
+	CurrentPrice float64 `json:"currentPrice"`
+	MarketValue  float64 `json:"marketValue"`
+	UnrealizedPL float64 `json:"unrealizedPL"`
+	HoldingDays  int     `json:"holdingDays"`
+}
+
+// ClosedLot is the part of a lot disposed of by a single sell or transfer-out.
+type ClosedLot struct {
+	LotID       int     `json:"lotId"`
+	SellID      int     `json:"sellId"`
+	Symbol      string  `json:"symbol"`
+	OpenDate    string  `json:"openDate"`
+	CloseDate   string  `json:"closeDate"`
+	Quantity    float64 `json:"quantity"`
+	CostBasis   float64 `json:"costBasis"`
+	Proceeds    float64 `json:"proceeds"`
+	RealizedPL  float64 `json:"realizedPL"`
+	HoldingDays int     `json:"holdingDays"`
+}
+
+// LotSelection pins part of a sell to a specific lot (the opening transaction ID).
+type LotSelection struct {
+	LotID    int     `json:"lotId"`
+	Quantity float64 `json:"quantity"`
+}
+
+// matchLots replays one symbol's ledger (sorted by date) and matches every
+// disposal against open lots using the given method. Selections keyed by sell
+// transaction ID are honoured first when the method is "specific"; any
+// unselected remainder falls back to FIFO.
+func matchLots(txns []Transaction, method string, selections map[int][]LotSelection) ([]OpenLot, []ClosedLot, error) {
+	var open []OpenLot
+	var closed []ClosedLot
+
+	for _, t := range txns {
+		switch t.Type {
+		case TxnBuy, TxnTransferIn:
+			cost := t.Quantity*t.Price + t.Fees
+			open = append(open, OpenLot{
+				LotID: t.ID, Symbol: t.Symbol, OpenDate: t.Date,
+				Quantity: t.Quantity, CostPerUnit: cost / t.Quantity, CostBasis: cost,
+			})
+
+		case TxnSplit:
+			for i := range open {
+				open[i].Quantity *= t.Quantity
+				open[i].CostPerUnit /= t.Quantity
+			}
+
+		case TxnSell, TxnTransferOut:
+			remaining := t.Quantity
+			// Transfers out carry no proceeds; the position simply leaves the account at cost
+			netProceeds := 0.0
+			if t.Type == TxnSell {
+				netProceeds = t.Quantity*t.Price - t.Fees
+			}
+			take := func(i int, qty float64) {
+				lot := &open[i]
+				cost := qty * lot.CostPerUnit
+				proceeds := cost
+				if t.Type == TxnSell {
+					proceeds = netProceeds * (qty / t.Quantity)
+				}
+				closed = append(closed, ClosedLot{
+					LotID: lot.LotID, SellID: t.ID, Symbol: t.Symbol,
+					OpenDate: lot.OpenDate, CloseDate: t.Date, Quantity: qty,
+					CostBasis: cost, Proceeds: proceeds, RealizedPL: proceeds - cost,
+					HoldingDays: daysBetween(lot.OpenDate, t.Date),
+				})
+				lot.Quantity -= qty
+				remaining -= qty
+			}
+
+			if method == LotSpecific {
+				for _, sel := range selections[t.ID] {
+					if remaining <= 1e-9 {
+						break
+					}
+					i := findLot(open, sel.LotID)
+					if i < 0 || sel.Quantity > open[i].Quantity+1e-9 {
+						return nil, nil, fmt.Errorf("sell %d selects %.4f from lot %d which is not open with that quantity", t.ID, sel.Quantity, sel.LotID)
+					}
+					take(i, math.Min(sel.Quantity, remaining))
+				}
+			}
+
+			for _, i := range lotOrder(open, method) {
+				if remaining <= 1e-9 {
+					break
+				}
+				if open[i].Quantity <= 1e-9 {
+					continue
+				}
+				take(i, math.Min(open[i].Quantity, remaining))
+			}
+			if remaining > 1e-9 {
+				return nil, nil, fmt.Errorf("%s of %.4f %s on %s exceeds open lots", t.Type, t.Quantity, t.Symbol, t.Date)
+			}
+			open = compactLots(open)
+		}
+	}
+
+	for i := range open {
+		open[i].CostBasis = open[i].Quantity * open[i].CostPerUnit
+	}
+	return open, closed, nil
+}
+
+// lotOrder returns indexes into open in the order the method consumes them.
+func lotOrder(open []OpenLot, method string) []int {
+	idx := make([]int, len(open))
+	for i := range idx {
+		idx[i] = i
+	}
+	switch method {
+	case LotLIFO:
+		sort.SliceStable(idx, func(a, b int) bool {
+			la, lb := open[idx[a]], open[idx[b]]
+			if la.OpenDate != lb.OpenDate {
+				return la.OpenDate > lb.OpenDate
+			}
+			return la.LotID > lb.LotID
+		})
+	case LotHIFO:
+		sort.SliceStable(idx, func(a, b int) bool { return open[idx[a]].CostPerUnit > open[idx[b]].CostPerUnit })
+	}
+	return idx
+}
+
+func findLot(open []OpenLot, lotID int) int {
+	for i, l := range open {
+		if l.LotID == lotID {
+			return i
+		}
+	}
+	return -1
+}
+
+func compactLots(open []OpenLot) []OpenLot {
+	out := open[:0]
+	for _, l := range open {
+		if l.Quantity > 1e-9 {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func daysBetween(from, to string) int {
+	a, err1 := time.Parse(dateLayout, from)
+	b, err2 := time.Parse(dateLayout, to)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return int(b.Sub(a).Hours() / 24)
+}
+
+func loadLotSelections(ctx context.Context, q dbQuerier, userID int) (map[int][]LotSelection, error) {
+	rows, err := q.Query(ctx, `
+		SELECT s.sell_txn_id, s.lot_txn_id, s.quantity
+		FROM lot_selections s JOIN transactions t ON t.id = s.sell_txn_id
+		WHERE t.user_id=$1 ORDER BY s.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	selections := map[int][]LotSelection{}
+	for rows.Next() {
+		var sellID int
+		var sel LotSelection
+		if err := rows.Scan(&sellID, &sel.LotID, &sel.Quantity); err != nil {
+			return nil, err
+		}
+		selections[sellID] = append(selections[sellID], sel)
+	}
+	return selections, rows.Err()
+}
+
+// userLots matches lots for every symbol the user holds (or just one) and
+// values the open lots at the latest stored price.
+func userLots(ctx context.Context, dbPool *pgxpool.Pool, userID int, symbol string) ([]OpenLot, []ClosedLot, error) {
+	settings, err := loadUserSettings(ctx, dbPool, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	txns, err := loadTransactions(ctx, dbPool, userID, symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+	selections, err := loadLotSelections(ctx, dbPool, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	prices, err := currentPrices(ctx, dbPool, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bySymbol := map[string][]Transaction{}
+	var symbols []string
+	for _, t := range txns {
+		if _, ok := bySymbol[t.Symbol]; !ok {
+			symbols = append(symbols, t.Symbol)
+		}
+		bySymbol[t.Symbol] = append(bySymbol[t.Symbol], t)
+	}
+
+	today := time.Now().Format(dateLayout)
+	open, closed := []OpenLot{}, []ClosedLot{}
+	for _, sym := range symbols {
+		o, cl, err := matchLots(bySymbol[sym], settings.LotMethod, selections)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range o {
+			o[i].CurrentPrice = prices[sym]
+			o[i].MarketValue = o[i].Quantity * o[i].CurrentPrice
+			o[i].UnrealizedPL = o[i].MarketValue - o[i].CostBasis
+			o[i].HoldingDays = daysBetween(o[i].OpenDate, today)
+		}
+		open = append(open, o...)
+		closed = append(closed, cl...)
+	}
+	return open, closed, nil
+}
+
+func currentPrices(ctx context.Context, q dbQuerier, userID int) (map[string]float64, error) {
+	rows, err := q.Query(ctx, "SELECT name, current_price FROM assets WHERE user_id=$1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := map[string]float64{}
+	for rows.Next() {
+		var name string
+		var price float64
+		if err := rows.Scan(&name, &price); err != nil {
+			return nil, err
+		}
+		prices[name] = price
+	}
+	return prices, rows.Err()
+}
+
+// --- ROUTES ---
+
+func registerLotRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/lots?symbol= - Open and closed tax lots under the user's matching method
+	api.GET("/lots", func(c *gin.Context) {
+		ctx := context.Background()
+		userID := currentUserID(c)
+		open, closed, err := userLots(ctx, dbPool, userID, c.Query("symbol"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		settings, _ := loadUserSettings(ctx, dbPool, userID)
+		c.JSON(http.StatusOK, gin.H{"method": settings.LotMethod, "open": open, "closed": closed})
+	})
+
+	// GET /api/pnl?symbol= - Realized and unrealized P&L per asset and realized P&L per year
+	api.GET("/pnl", func(c *gin.Context) {
+		open, closed, err := userLots(context.Background(), dbPool, currentUserID(c), c.Query("symbol"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		type assetPL struct {
+			Symbol       string  `json:"symbol"`
+			Realized     float64 `json:"realized"`
+			Unrealized   float64 `json:"unrealized"`
+			OpenQuantity float64 `json:"openQuantity"`
+			CostBasis    float64 `json:"costBasis"`
+		}
+		perAsset := map[string]*assetPL{}
+		get := func(sym string) *assetPL {
+			if perAsset[sym] == nil {
+				perAsset[sym] = &assetPL{Symbol: sym}
+			}
+			return perAsset[sym]
+		}
+		perYear := map[string]map[string]float64{}
+
+		for _, l := range open {
+			a := get(l.Symbol)
+			a.Unrealized += l.UnrealizedPL
+			a.OpenQuantity += l.Quantity
+			a.CostBasis += l.CostBasis
+		}
+		for _, l := range closed {
+			get(l.Symbol).Realized += l.RealizedPL
+			year := l.CloseDate[:4]
+			if perYear[year] == nil {
+				perYear[year] = map[string]float64{}
+			}
+			perYear[year][l.Symbol] += l.RealizedPL
+		}
+
+		assets := make([]*assetPL, 0, len(perAsset))
+		for _, a := range perAsset {
+			assets = append(assets, a)
+		}
+		sort.Slice(assets, func(i, j int) bool { return assets[i].Symbol < assets[j].Symbol })
+
+		years := make([]gin.H, 0, len(perYear))
+		for year, bySymbol := range perYear {
+			total := 0.0
+			for _, v := range bySymbol {
+				total += v
+			}
+			years = append(years, gin.H{"year": year, "realized": total, "bySymbol": bySymbol})
+		}
+		sort.Slice(years, func(i, j int) bool { return years[i]["year"].(string) < years[j]["year"].(string) })
+
+		c.JSON(http.StatusOK, gin.H{"assets": assets, "years": years})
+	})
+
+	// PUT /api/transactions/:id/lots - Choose which lots a sell disposes of (specific-lot method)
+	api.PUT("/transactions/:id/lots", func(c *gin.Context) {
+		var input []LotSelection
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+		sellID, _ := strconv.Atoi(c.Param("id"))
+
+		ctx := context.Background()
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		var symbol, txnType string
+		var qty float64
+		err = tx.QueryRow(ctx, "SELECT symbol, txn_type, quantity FROM transactions WHERE id=$1 AND user_id=$2", sellID, userID).Scan(&symbol, &txnType, &qty)
+		if err != nil || (txnType != TxnSell && txnType != TxnTransferOut) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Sell transaction not found"})
+			return
+		}
+
+		total := 0.0
+		for _, sel := range input {
+			if sel.Quantity <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Lot quantities must be positive"})
+				return
+			}
+			total += sel.Quantity
+		}
+		if total > qty+1e-9 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Selected lots exceed the sell quantity"})
+			return
+		}
+
+		if _, err := tx.Exec(ctx, "DELETE FROM lot_selections WHERE sell_txn_id=$1", sellID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save lot selection"})
+			return
+		}
+		for _, sel := range input {
+			_, err := tx.Exec(ctx, "INSERT INTO lot_selections (sell_txn_id, lot_txn_id, quantity) VALUES ($1, $2, $3)", sellID, sel.LotID, sel.Quantity)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lot selection"})
+				return
+			}
+		}
+
+		// Validate the selection against the replayed ledger before committing
+		txns, err := loadTransactions(ctx, tx, userID, symbol)
+		if err == nil {
+			var selections map[int][]LotSelection
+			if selections, err = loadLotSelections(ctx, tx, userID); err == nil {
+				_, _, err = matchLots(txns, LotSpecific, selections)
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save lot selection"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Lot selection saved"})
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMatchLots(t *testing.T) {
+	buys := []Transaction{
+		{ID: 1, Type: TxnBuy, Date: "2023-01-01", Quantity: 10, Price: 100},
+		{ID: 2, Type: TxnBuy, Date: "2023-02-01", Quantity: 10, Price: 120},
+		{ID: 3, Type: TxnBuy, Date: "2023-03-01", Quantity: 10, Price: 90},
+	}
+	sell := func(qty float64) Transaction {
+		return Transaction{ID: 4, Type: TxnSell, Date: "2023-04-01", Quantity: qty, Price: 130}
+	}
+
+	tests := []struct {
+		name       string
+		txns       []Transaction
+		method     string
+		selections map[int][]LotSelection
+		wantOpen   map[int]float64 // lot ID -> remaining quantity
+		wantPL     float64
+		wantErr    bool
+	}{
+		{
+			name:     "fifo",
+			txns:     append(buys[:3:3], sell(15)),
+			method:   LotFIFO,
+			wantOpen: map[int]float64{2: 5, 3: 10},
+			wantPL:   1950 - 1000 - 600,
+		},
+		{
+			name:     "lifo",
+			txns:     append(buys[:3:3], sell(15)),
+			method:   LotLIFO,
+			wantOpen: map[int]float64{1: 10, 2: 5},
+			wantPL:   1950 - 900 - 600,
+		},
+		{
+			name:     "hifo",
+			txns:     append(buys[:3:3], sell(15)),
+			method:   LotHIFO,
+			wantOpen: map[int]float64{1: 5, 3: 10},
+			wantPL:   1950 - 1200 - 500,
+		},
+		{
+			name:       "specific then fifo for the rest",
+			txns:       append(buys[:3:3], sell(15)),
+			method:     LotSpecific,
+			selections: map[int][]LotSelection{4: {{LotID: 3, Quantity: 5}}},
+			wantOpen:   map[int]float64{2: 10, 3: 5},
+			wantPL:     1950 - 450 - 1000,
+		},
+		{
+			name: "split before the sell",
+			txns: []Transaction{
+				{ID: 1, Type: TxnBuy, Date: "2023-01-01", Quantity: 10, Price: 100},
+				{ID: 2, Type: TxnSplit, Date: "2023-02-01", Quantity: 2},
+				{ID: 3, Type: TxnSell, Date: "2023-03-01", Quantity: 5, Price: 60},
+			},
+			method:   LotFIFO,
+			wantOpen: map[int]float64{1: 15},
+			wantPL:   300 - 250,
+		},
+		{
+			name: "transfer out leaves at cost",
+			txns: []Transaction{
+				{ID: 1, Type: TxnBuy, Date: "2023-01-01", Quantity: 10, Price: 100},
+				{ID: 2, Type: TxnTransferOut, Date: "2023-02-01", Quantity: 10, Price: 100},
+			},
+			method:   LotFIFO,
+			wantOpen: map[int]float64{},
+		},
+		{
+			name:       "selection of a lot that is not open",
+			txns:       append(buys[:3:3], sell(5)),
+			method:     LotSpecific,
+			selections: map[int][]LotSelection{4: {{LotID: 9, Quantity: 5}}},
+			wantErr:    true,
+		},
+		{
+			name:    "oversold",
+			txns:    append(buys[:3:3], sell(31)),
+			method:  LotFIFO,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, closed, err := matchLots(tt.txns, tt.method, tt.selections)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matchLots() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			gotOpen := map[int]float64{}
+			for _, l := range open {
+				gotOpen[l.LotID] = l.Quantity
+			}
+			if len(gotOpen) != len(tt.wantOpen) {
+				t.Errorf("open lots = %v, want %v", gotOpen, tt.wantOpen)
+			}
+			for id, qty := range tt.wantOpen {
+				if math.Abs(gotOpen[id]-qty) > 1e-9 {
+					t.Errorf("open lot %d = %g, want %g", id, gotOpen[id], qty)
+				}
+			}
+			pl := 0.0
+			for _, l := range closed {
+				pl += l.RealizedPL
+			}
+			if math.Abs(pl-tt.wantPL) > 1e-6 {
+				t.Errorf("realized P/L = %g, want %g", pl, tt.wantPL)
+			}
+		})
+	}
+}
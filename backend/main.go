@@ -29,53 +29,21 @@ type User struct {
 }
 
 type Asset struct {
-	ID            int     `json:"id"`
-	UserID        int     `json:"userId"`
-	Name          string  `json:"name"`
-	Nickname      string  `json:"nickname"`
-	Type          string  `json:"type"`
-	Quantity      float64 `json:"quantity"`
-	AvgPrice      float64 `json:"avgPrice"`
-	CurrentPrice  float64 `json:"currentPrice"`
-	PreviousClose float64 `json:"previousClose"`
-	Currency      string  `json:"currency"`
-}
-
-type YahooResponse struct {
-	Chart struct {
-		Result []struct {
-			Meta struct {
-				Currency           string  `json:"currency"`
-				RegularMarketPrice float64 `json:"regularMarketPrice"`
-				ChartPreviousClose float64 `json:"chartPreviousClose"`
-			} `json:"meta"`
-		} `json:"result"`
-	} `json:"chart"`
-}
-
-type YahooSearchResponse struct {
-	Quotes []struct {
-		Symbol    string `json:"symbol"`
-		ShortName string `json:"shortname"`
-		Exchange  string `json:"exchange"`
-		QuoteType string `json:"quoteType"`
-	} `json:"quotes"`
-}
-
-// AMFI specific structs for Indian Mutual Funds
-type MFAPISearchResult struct {
-	SchemeCode int    `json:"schemeCode"`
-	SchemeName string `json:"schemeName"`
-}
-
-type MFAPIDetailResult struct {
-	Meta struct {
-		SchemeName string `json:"scheme_name"`
-	} `json:"meta"`
-	Data []struct {
-		Date string `json:"date"`
-		Nav  string `json:"nav"`
-	} `json:"data"`
+	ID            int      `json:"id"`
+	UserID        int      `json:"userId"`
+	Name          string   `json:"name"`
+	Nickname      string   `json:"nickname"`
+	Type          string   `json:"type"`
+	Quantity      float64  `json:"quantity"`
+	AvgPrice      float64  `json:"avgPrice"`
+	CurrentPrice  float64  `json:"currentPrice"`
+	PreviousClose float64  `json:"previousClose"`
+	Currency      string   `json:"currency"`
+	Exchange      string   `json:"exchange"`
+	MarketState   string   `json:"marketState"`
+	Tag           string   `json:"tag"`
+	Drip          bool     `json:"drip"`
+	Withholding   *float64 `json:"withholding"` // nil uses the user's default
 }
 
 type YahooSearchNewsResponse struct {
@@ -118,6 +86,11 @@ func main() {
 		log.Fatal("DB_URL not found in environment variables")
 	}
 
+	authSecret = []byte(os.Getenv("AUTH_SECRET"))
+	if len(authSecret) < 32 {
+		log.Fatal("AUTH_SECRET must be set to at least 32 characters")
+	}
+
 	// 2. Connect to Database (Using Pool for concurrency)
 	dbPool, err := pgxpool.New(context.Background(), connStr)
 	if err != nil {
@@ -127,10 +100,31 @@ func main() {
 
 	fmt.Println("Successfully connected to Supabase (Multi-User Mode)!")
 
-	// Run Schema Migration for Nickname
-	_, err = dbPool.Exec(context.Background(), "ALTER TABLE assets ADD COLUMN IF NOT EXISTS nickname VARCHAR(255)")
-	if err != nil {
-		log.Println("Warning: Could not alter table for nickname column:", err)
+	// Run Schema Migrations
+	runMigrations(dbPool)
+
+	// Wire market data providers and their fallback chains
+	market = defaultMarketData(dbPool)
+
+	// One-off maintenance command: `portfolio-backend backfill [days]`
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		days := 365
+		if len(os.Args) > 2 {
+			if n, err := strconv.Atoi(os.Args[2]); err == nil {
+				days = n
+			}
+		}
+		if err := backfillPriceHistory(dbPool, days); err != nil {
+			log.Fatal("Backfill finished with errors: ", err)
+		}
+		if err := syncFXRates(context.Background(), dbPool, days); err != nil {
+			log.Fatal("FX backfill finished with errors: ", err)
+		}
+		if err := snapshotAllUsers(context.Background(), dbPool); err != nil {
+			log.Fatal("Snapshot backfill finished with errors: ", err)
+		}
+		fmt.Println("Backfill complete")
+		return
 	}
 
 	// 3. Setup Router
@@ -140,7 +134,7 @@ func main() {
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-User-ID")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -176,7 +170,15 @@ func main() {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "User created", "userId": newID, "username": u.Username})
+		session, err := issueSession(dbPool, newID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+			return
+		}
+		session["message"] = "User created"
+		session["userId"] = newID
+		session["username"] = u.Username
+		c.JSON(http.StatusOK, session)
 	})
 
 	// POST /api/login - Authenticates an existing user
@@ -206,90 +208,113 @@ func main() {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Login successful", "userId": dbID, "username": u.Username})
+		session, err := issueSession(dbPool, dbID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+			return
+		}
+		session["message"] = "Login successful"
+		session["userId"] = dbID
+		session["username"] = u.Username
+		c.JSON(http.StatusOK, session)
 	})
 
-	// --- ASSET ROUTES (Protected by X-User-ID) ---
+	// POST /api/refresh - Rotates a refresh token into a new access/refresh pair
+	r.POST("/api/refresh", func(c *gin.Context) {
+		var input struct {
+			RefreshToken string `json:"refreshToken"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil || input.RefreshToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
 
-	// POST /api/assets - Add a new asset or Merge with existing
-	r.POST("/api/assets", func(c *gin.Context) {
-		// Identify User from Headers
-		userIDStr := c.GetHeader("X-User-ID")
-		if userIDStr == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized. Please log in."})
+		userID, session, err := rotateRefreshToken(dbPool, input.RefreshToken)
+		if err == errInvalidToken || err == errExpiredToken || err == errReusedToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired. Please log in again."})
 			return
 		}
-		userID, _ := strconv.Atoi(userIDStr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+			return
+		}
+		session["userId"] = userID
+		c.JSON(http.StatusOK, session)
+	})
 
-		var input Asset
-		if err := c.ShouldBindJSON(&input); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// POST /api/logout - Revokes the refresh token family of this session
+	r.POST("/api/logout", func(c *gin.Context) {
+		var input struct {
+			RefreshToken string `json:"refreshToken"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil || input.RefreshToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 			return
 		}
 
-		var existingID int
-		var existingQty float64
-		var existingAvgPrice float64
+		if err := revokeRefreshFamily(dbPool, input.RefreshToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+	})
 
-		// Check if asset already exists FOR THIS SPECIFIC USER
-		queryCheck := `SELECT id, COALESCE(quantity, 0), COALESCE(avg_price, 0) FROM assets WHERE name=$1 AND user_id=$2 LIMIT 1`
-		err := dbPool.QueryRow(context.Background(), queryCheck, input.Name, userID).Scan(&existingID, &existingQty, &existingAvgPrice)
+	// --- PROTECTED ROUTES (Require a valid access token) ---
+	api := r.Group("/api", authRequired())
 
-		if err == pgx.ErrNoRows {
-			// New Asset Logic: Determine Currency
-			currency := "USD"
-			// Check for Indian equities (.NS, .BO) or AMFI mutual funds (AMFI:)
-			if strings.HasSuffix(input.Name, ".NS") || strings.HasSuffix(input.Name, ".BO") || strings.HasPrefix(input.Name, "AMFI:") {
-				currency = "INR"
-			} else if strings.HasSuffix(input.Name, ".SI") {
-				currency = "SGD"
-			}
+	// --- ASSET ROUTES ---
 
-			insertQ := `INSERT INTO assets (user_id, name, nickname, asset_type, quantity, avg_price, current_price, previous_close, currency) VALUES ($1, $2, $3, $4, $5, $6, $6, $6, $7)`
-			_, err := dbPool.Exec(context.Background(), insertQ, userID, input.Name, input.Nickname, input.Type, input.Quantity, input.AvgPrice, currency)
+	// POST /api/assets - Record a buy in the ledger (creating the holding if needed)
+	api.POST("/assets", func(c *gin.Context) {
+		userID := currentUserID(c)
 
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert asset"})
-				return
-			}
-			c.JSON(http.StatusOK, gin.H{"message": "Asset saved!"})
-
-		} else {
-			// Merge Asset Logic: Calculate new weighted average
-			newTotalQty := existingQty + input.Quantity
-			var newAvgPrice float64
-			if newTotalQty > 0 {
-				newAvgPrice = ((existingQty * existingAvgPrice) + (input.Quantity * input.AvgPrice)) / newTotalQty
-			}
-			
-			// If a nickname is provided, update it too
-			if input.Nickname != "" {
-				_, err = dbPool.Exec(context.Background(), "UPDATE assets SET quantity=$1, avg_price=$2, nickname=$3 WHERE id=$4", newTotalQty, newAvgPrice, input.Nickname, existingID)
-			} else {
-				_, err = dbPool.Exec(context.Background(), "UPDATE assets SET quantity=$1, avg_price=$2 WHERE id=$3", newTotalQty, newAvgPrice, existingID)
-			}
+		var input Asset
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge asset"})
+		t := Transaction{UserID: userID, Symbol: input.Name, Type: TxnBuy, Quantity: input.Quantity, Price: input.AvgPrice, AssetType: input.Type}
+		if err := validateTransaction(&t); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx := context.Background()
+		t.Currency = resolveCurrency(ctx, dbPool, userID, t.Symbol)
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		// If a nickname is provided for an existing holding, update it too
+		if input.Nickname != "" {
+			if _, err := tx.Exec(ctx, "UPDATE assets SET nickname=$1 WHERE name=$2 AND user_id=$3", input.Nickname, input.Name, userID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save asset"})
 				return
 			}
-			c.JSON(http.StatusOK, gin.H{"message": "Asset merged!"})
 		}
+		if err := insertTransaction(ctx, tx, &t, input.Nickname); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save asset"})
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save asset"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Asset saved!", "transactionId": t.ID})
 	})
 
 	// GET /api/assets - Fetch all assets for the logged-in user
-	r.GET("/api/assets", func(c *gin.Context) {
-		userIDStr := c.GetHeader("X-User-ID")
-		if userIDStr == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-		userID, _ := strconv.Atoi(userIDStr)
+	api.GET("/assets", func(c *gin.Context) {
+		userID := currentUserID(c)
 
 		// Select only assets belonging to this specific user
 		query := `
-			SELECT id, name, COALESCE(nickname, ''), asset_type, quantity, avg_price, current_price, previous_close, currency 
-			FROM assets WHERE user_id=$1 ORDER BY (current_price * quantity) DESC`
+			SELECT id, name, COALESCE(nickname, ''), asset_type, quantity, avg_price, current_price, previous_close, currency, COALESCE(tag, ''), drip, withholding
+			FROM assets WHERE user_id=$1 AND quantity > 0 ORDER BY (current_price * quantity) DESC`
 
 		rows, err := dbPool.Query(context.Background(), query, userID)
 		if err != nil {
@@ -298,33 +323,49 @@ func main() {
 		}
 		defer rows.Close()
 
+		now := time.Now()
 		var assets []Asset
 		for rows.Next() {
 			var a Asset
-			rows.Scan(&a.ID, &a.Name, &a.Nickname, &a.Type, &a.Quantity, &a.AvgPrice, &a.CurrentPrice, &a.PreviousClose, &a.Currency)
+			rows.Scan(&a.ID, &a.Name, &a.Nickname, &a.Type, &a.Quantity, &a.AvgPrice, &a.CurrentPrice, &a.PreviousClose, &a.Currency, &a.Tag, &a.Drip, &a.Withholding)
+			if e := exchangeForSymbol(a.Name); e != nil {
+				a.Exchange = e.Code
+			}
+			a.MarketState = quoteMarketState(a.Name, now)
 			assets = append(assets, a)
 		}
 		c.JSON(http.StatusOK, assets)
 	})
 
-	// PUT /api/assets/:id - Edit an asset securely
-	r.PUT("/api/assets/:id", func(c *gin.Context) {
-		userIDStr := c.GetHeader("X-User-ID")
+	// PUT /api/assets/:id - Edit an asset's nickname, rebalancing tag, dividend reinvestment and withholding (quantity and price come from the ledger)
+	api.PUT("/assets/:id", func(c *gin.Context) {
+		userID := currentUserID(c)
 		id := c.Param("id")
-		if userIDStr == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-		userID, _ := strconv.Atoi(userIDStr)
 
-		var input Asset
+		var input struct {
+			Nickname *string `json:"nickname"` // Omitted keeps the current nickname
+			Tag      *string `json:"tag"`      // Omitted keeps the current tag
+			Drip     *bool   `json:"drip"`     // Omitted keeps the current setting
+			// Omitted keeps the current rate; a negative value reverts to the user default
+			Withholding *float64 `json:"withholding"`
+		}
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if input.Withholding != nil && *input.Withholding >= 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "withholding must be a percentage under 100"})
+			return
+		}
+		if input.Tag != nil {
+			trimmed := strings.TrimSpace(*input.Tag)
+			input.Tag = &trimmed
+		}
 
-		updateQ := `UPDATE assets SET nickname=$1, quantity=$2, avg_price=$3 WHERE id=$4 AND user_id=$5`
-		res, err := dbPool.Exec(context.Background(), updateQ, input.Nickname, input.Quantity, input.AvgPrice, id, userID)
+		updateQ := `UPDATE assets SET nickname=COALESCE($1, nickname), tag=COALESCE($2, tag), drip=COALESCE($3, drip),
+			withholding=CASE WHEN $4::float8 IS NULL THEN withholding WHEN $4 < 0 THEN NULL ELSE $4 END
+			WHERE id=$5 AND user_id=$6`
+		res, err := dbPool.Exec(context.Background(), updateQ, input.Nickname, input.Tag, input.Drip, input.Withholding, id, userID)
 
 		if err != nil || res.RowsAffected() == 0 {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update asset or unauthorized"})
@@ -333,120 +374,80 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"message": "Asset updated!"})
 	})
 
-	// DELETE /api/assets/:id - Remove an asset safely
-	r.DELETE("/api/assets/:id", func(c *gin.Context) {
-		userIDStr := c.GetHeader("X-User-ID")
+	// DELETE /api/assets/:id - Remove an asset and its ledger history safely
+	api.DELETE("/assets/:id", func(c *gin.Context) {
+		userID := currentUserID(c)
 		id := c.Param("id")
-		userID, _ := strconv.Atoi(userIDStr)
+
+		ctx := context.Background()
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
 
 		// Secure Delete: Ensure ID matches AND User matches so people can't delete other people's stocks
-		res, err := dbPool.Exec(context.Background(), "DELETE FROM assets WHERE id=$1 AND user_id=$2", id, userID)
-		if err != nil || res.RowsAffected() == 0 {
+		var symbol string
+		err = tx.QueryRow(ctx, "DELETE FROM assets WHERE id=$1 AND user_id=$2 RETURNING name", id, userID).Scan(&symbol)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete or unauthorized"})
 			return
 		}
+		if _, err := tx.Exec(ctx, "DELETE FROM transactions WHERE symbol=$1 AND user_id=$2", symbol, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete transactions"})
+			return
+		}
+		if err := invalidateSnapshots(ctx, tx, userID, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete"})
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete"})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"message": "Asset deleted"})
 	})
 
-	// --- CHART & MARKET DATA ROUTES ---
+	// --- LEDGER ROUTES ---
+	registerTransactionRoutes(api, dbPool)
+	registerLotRoutes(api, dbPool)
+	registerCorporateActionRoutes(api, dbPool)
 
-	// GET /api/history/:symbol - Fetch historical charting data with dynamic timeframes
-	r.GET("/api/history/:symbol", func(c *gin.Context) {
-		symbol := c.Param("symbol")
-		rng := c.DefaultQuery("range", "3mo") // Default to 3 months if not provided
-
-		// 1. Handle Official Indian Mutual Funds (AMFI)
-		if strings.HasPrefix(symbol, "AMFI:") {
-			schemeCode := strings.TrimPrefix(symbol, "AMFI:")
-			url := fmt.Sprintf("https://api.mfapi.in/mf/%s", schemeCode)
-			req, _ := http.NewRequest("GET", url, nil)
-			client := &http.Client{Timeout: 5 * time.Second}
-			resp, err := client.Do(req)
-			
-			if err != nil || resp.StatusCode != 200 {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "AMFI data fetch failed"})
-				return
-			}
-			defer resp.Body.Close()
+	// --- IMPORT ROUTES ---
+	registerImportRoutes(api, dbPool)
+	registerBackupRoutes(api, dbPool)
+	registerBooksRoutes(api, dbPool)
 
-			var amfiData MFAPIDetailResult
-			if err := json.NewDecoder(resp.Body).Decode(&amfiData); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse AMFI data"})
-				return
-			}
+	// --- SETTINGS ROUTES ---
+	registerSettingsRoutes(api, dbPool)
 
-			// Determine date cutoff based on range
-			daysToKeep := 90 // Default 3mo
-			if rng == "1wk" { daysToKeep = 7 }
-			if rng == "1mo" { daysToKeep = 30 }
-			if rng == "1y" { daysToKeep = 365 }
-			if rng == "max" { daysToKeep = 99999 } // effectively all time
-
-			cutoffTime := time.Now().AddDate(0, 0, -daysToKeep)
-			
-			var timestamps []int64
-			var closes []float64
-
-			// AMFI provides data newest first, but charts need oldest first. 
-			// We iterate backwards through the AMFI slice.
-			for i := len(amfiData.Data) - 1; i >= 0; i-- {
-				// Parse date from DD-MM-YYYY
-				parsedDate, err := time.Parse("02-01-2006", amfiData.Data[i].Date)
-				if err != nil { continue }
-				
-				if parsedDate.After(cutoffTime) || daysToKeep == 99999 {
-					nav, err := strconv.ParseFloat(amfiData.Data[i].Nav, 64)
-					if err == nil {
-						timestamps = append(timestamps, parsedDate.Unix())
-						closes = append(closes, nav)
-					}
-				}
-			}
+	// --- PORTFOLIO ROUTES ---
+	registerPortfolioRoutes(api, dbPool)
+	registerSnapshotRoutes(api, dbPool)
 
-			// Mock the exact Yahoo JSON structure so the frontend chart doesn't break
-			c.JSON(http.StatusOK, gin.H{
-				"chart": gin.H{
-					"result": []gin.H{
-						{
-							"timestamp": timestamps,
-							"indicators": gin.H{
-								"quote": []gin.H{
-									{"close": closes},
-								},
-							},
-						},
-					},
-				},
-			})
-			return
-		}
+	// --- ANALYTICS ROUTES ---
+	registerAnalyticsRoutes(api, dbPool)
+	registerBenchmarkRoutes(api, dbPool)
+	registerRiskRoutes(api, dbPool)
+	registerCorrelationRoutes(api, dbPool)
+	registerAllocationRoutes(api, dbPool)
+	registerRebalanceRoutes(api, dbPool)
+	registerDividendRoutes(api, dbPool)
 
-		// 2. Handle Standard Yahoo Assets (Stocks, ETFs, Crypto, etc.)
-		interval := "1d"
-		if rng == "max" {
-			interval = "1mo"
-		} else if rng == "1y" {
-			interval = "1wk"
-		}
+	// --- CHART & MARKET DATA ROUTES ---
 
-		url := fmt.Sprintf("https://query1.finance.yahoo.com/v8/finance/chart/%s?interval=%s&range=%s", symbol, interval, rng)
-		req, _ := http.NewRequest("GET", url, nil)
-		req.Header.Set("User-Agent", "Mozilla/5.0")
-		client := &http.Client{Timeout: 5 * time.Second}
-		resp, err := client.Do(req)
+	// GET /api/history/:symbol - Fetch historical charting data with dynamic timeframes.
+	// Authenticated because a miss persists the symbol's history and calls upstream.
+	registerHistoryRoutes(api, dbPool)
 
-		if err != nil || resp.StatusCode != 200 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chart data from Yahoo"})
-			return
-		}
-		defer resp.Body.Close()
+	// GET /api/markets - Exchange registry with trading sessions and holidays
+	registerMarketRoutes(r)
 
-		var data map[string]interface{}
-		json.NewDecoder(resp.Body).Decode(&data)
-		c.JSON(http.StatusOK, data)
-	})
+	// GET /api/rates - Exchange rates for the user's currencies, live or on a past date
+	registerFXRoutes(api, dbPool)
 
-	// GET /api/search - Autocomplete ticker symbols via Yahoo Finance + AMFI
+	// GET /api/search - Autocomplete ticker symbols across all registered searchers
 	r.GET("/api/search", func(c *gin.Context) {
 		query := c.Query("q")
 		if query == "" {
@@ -454,96 +455,64 @@ func main() {
 			return
 		}
 
-		client := &http.Client{Timeout: 5 * time.Second}
-		var searchData YahooSearchResponse
-
-		// 1. Fetch from Yahoo Finance
-		yUrl := fmt.Sprintf("https://query2.finance.yahoo.com/v1/finance/search?q=%s&quotesCount=4&newsCount=0", url.QueryEscape(query))
-		req1, _ := http.NewRequest("GET", yUrl, nil)
-		req1.Header.Set("User-Agent", "Mozilla/5.0")
-		if resp1, err1 := client.Do(req1); err1 == nil && resp1.StatusCode == 200 {
-			json.NewDecoder(resp1.Body).Decode(&searchData)
-			resp1.Body.Close()
-		}
+		// Return combined array to the frontend
+		c.JSON(http.StatusOK, market.Search(c.Request.Context(), query))
+	})
 
-		// 2. Fetch from Official Indian Mutual Fund API (AMFI)
-		mfUrl := fmt.Sprintf("https://api.mfapi.in/mf/search?q=%s", url.QueryEscape(query))
-		req2, _ := http.NewRequest("GET", mfUrl, nil)
-		if resp2, err2 := client.Do(req2); err2 == nil && resp2.StatusCode == 200 {
-			var mfData []MFAPISearchResult
-			json.NewDecoder(resp2.Body).Decode(&mfData)
-			resp2.Body.Close()
+	// --- ADMIN ROUTES ---
+	admin := api.Group("", adminRequired(dbPool))
 
-			// Append up to 6 Indian MFs to the dropdown results
-			limit := len(mfData)
-			if limit > 6 { 
-				limit = 6 
-			}
-			
-			for i := 0; i < limit; i++ {
-				searchData.Quotes = append(searchData.Quotes, struct {
-					Symbol    string `json:"symbol"`
-					ShortName string `json:"shortname"`
-					Exchange  string `json:"exchange"`
-					QuoteType string `json:"quoteType"`
-				}{
-					Symbol:    fmt.Sprintf("AMFI:%d", mfData[i].SchemeCode),
-					ShortName: mfData[i].SchemeName,
-					Exchange:  "AMFI India",
-					QuoteType: "MUTUALFUND",
-				})
-			}
+	// POST /api/update-prices - Force a global price refresh (normally done by the scheduler)
+	admin.POST("/update-prices", func(c *gin.Context) {
+		report, err := runPriceUpdate(context.Background(), dbPool, nil)
+		if err != nil {
+			log.Println("Price update failed:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save prices", "report": report})
+			return
 		}
-
-		// Return combined array to the frontend
-		c.JSON(http.StatusOK, searchData.Quotes)
+		c.JSON(http.StatusOK, gin.H{"message": "Prices updated", "report": report})
 	})
 
-	// POST /api/update-prices - Global Price Updater (Scraper)
-	r.POST("/api/update-prices", func(c *gin.Context) {
-		// Get unique names to avoid requesting the same stock twice
-		rows, _ := dbPool.Query(context.Background(), "SELECT DISTINCT name FROM assets")
-		var names []string
-		for rows.Next() {
-			var n string
-			rows.Scan(&n)
-			names = append(names, n)
+	// POST /api/dividends/sync - Import dividend events now (normally done by the scheduler)
+	admin.POST("/dividends/sync", func(c *gin.Context) {
+		report, err := syncDividends(context.Background(), dbPool)
+		if err != nil {
+			log.Println("Dividend sync failed:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import dividends", "report": report})
+			return
 		}
-		rows.Close()
+		c.JSON(http.StatusOK, gin.H{"message": "Dividends imported", "report": report})
+	})
 
-		for _, symbol := range names {
-			price, prevClose, currency, err := fetchLivePriceExtended(symbol)
-			if err == nil {
-				// Update all assets matching this symbol across ALL users
-				dbPool.Exec(context.Background(), "UPDATE assets SET current_price=$1, previous_close=$2, currency=$3 WHERE name=$4", price, prevClose, currency, symbol)
-			}
+	// POST /api/corporate-actions/sync - Fetch and apply corporate actions now (normally done by the scheduler)
+	admin.POST("/corporate-actions/sync", func(c *gin.Context) {
+		report, err := syncCorporateActions(context.Background(), dbPool)
+		if err != nil {
+			log.Println("Corporate action sync failed:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync corporate actions", "report": report})
+			return
 		}
-		c.JSON(http.StatusOK, gin.H{"message": "Prices updated"})
+		c.JSON(http.StatusOK, gin.H{"message": "Corporate actions synced", "report": report})
 	})
 
-	// GET /api/rates - Public Exchange Rates
-	r.GET("/api/rates", func(c *gin.Context) {
-		inr, _, _, _ := fetchLivePriceExtended("INR=X")
-		sgd, _, _, _ := fetchLivePriceExtended("SGD=X")
-
-		// Fallbacks if Yahoo blocks the request
-		if inr == 0 {
-			inr = 87.0
-		}
-		if sgd == 0 {
-			sgd = 1.36
+	// GET /api/jobs - Scheduled job state across all instances
+	admin.GET("/jobs", func(c *gin.Context) {
+		jobs, err := loadJobStatuses(context.Background(), dbPool)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
 		}
-		c.JSON(http.StatusOK, gin.H{"USD": 1.0, "INR": inr, "SGD": sgd})
+		c.JSON(http.StatusOK, jobs)
+	})
+
+	// GET /api/providers/status - Market data provider health and circuit breakers
+	api.GET("/providers/status", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"providers": market.ProviderStatuses(), "chains": market.chains})
 	})
 
 	// POST /api/insights - Generate AI insights for user's portfolio
-	r.POST("/api/insights", func(c *gin.Context) {
-		userIDStr := c.GetHeader("X-User-ID")
-		if userIDStr == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-		userID, _ := strconv.Atoi(userIDStr)
+	api.POST("/insights", func(c *gin.Context) {
+		userID := currentUserID(c)
 
 		// 1. Fetch Top Assets
 		query := `SELECT name, quantity, current_price, currency FROM assets WHERE user_id=$1 ORDER BY (current_price * quantity) DESC LIMIT 5`
@@ -562,8 +531,8 @@ func main() {
 			var qty, price float64
 			rows.Scan(&name, &qty, &price, &currency)
 			portfolioDesc = append(portfolioDesc, fmt.Sprintf("%.2f units of %s (Price: %.2f %s)", qty, name, price, currency))
-			// Avoid AMFI tickers for Yahoo News
-			if !strings.HasPrefix(name, "AMFI:") {
+			// Avoid scheme-prefixed tickers (e.g. AMFI:) for Yahoo News
+			if symbolScheme(name) == "" {
 				symbols = append(symbols, name)
 			}
 		}
@@ -596,14 +565,21 @@ Based on this information, provide 3 short, actionable pivot strategies or insig
 		c.JSON(http.StatusOK, gin.H{"insights": insights})
 	})
 
+	// --- BACKGROUND PRICE REFRESH ---
+	startScheduler(dbPool)
+
 	// --- KEEP-ALIVE (RENDER + SUPABASE) ---
-	urlKeepAlive := "https://investing-tracker.onrender.com/api/rates"
+	urlKeepAlive := os.Getenv("KEEPALIVE_URL") // e.g. https://investing-tracker.onrender.com/api/markets
 	go func() {
 		time.Sleep(1 * time.Minute)
 		ticker := time.NewTicker(10 * time.Minute)
 		for range ticker.C {
 			// 1. Ping Render to keep the web server awake
-			http.Get(urlKeepAlive)
+			if urlKeepAlive != "" {
+				if resp, err := http.Get(urlKeepAlive); err == nil {
+					resp.Body.Close()
+				}
+			}
 
 			// 2. Ping Supabase to keep the database awake
 			dbPool.Exec(context.Background(), "SELECT 1")
@@ -616,67 +592,6 @@ Based on this information, provide 3 short, actionable pivot strategies or insig
 
 // --- HELPER FUNCTIONS ---
 
-func fetchLivePriceExtended(symbol string) (float64, float64, string, error) {
-	// 1. Handle Indian Mutual Funds via AMFI
-	if strings.HasPrefix(symbol, "AMFI:") {
-		schemeCode := strings.TrimPrefix(symbol, "AMFI:")
-		url := fmt.Sprintf("https://api.mfapi.in/mf/%s", schemeCode)
-		req, _ := http.NewRequest("GET", url, nil)
-		client := &http.Client{Timeout: 5 * time.Second}
-		resp, err := client.Do(req)
-		
-		if err != nil { 
-			return 0, 0, "", err 
-		}
-		defer resp.Body.Close()
-
-		var data MFAPIDetailResult
-		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil { 
-			return 0, 0, "", err 
-		}
-		if len(data.Data) < 1 { 
-			return 0, 0, "", fmt.Errorf("no amfi data found") 
-		}
-
-		currentPrice, _ := strconv.ParseFloat(data.Data[0].Nav, 64)
-		prevClose := currentPrice
-		
-		// If there is data for yesterday, use it for the daily change calculation
-		if len(data.Data) > 1 {
-			prevClose, _ = strconv.ParseFloat(data.Data[1].Nav, 64)
-		}
-		
-		// AMFI prices are strictly in INR
-		return currentPrice, prevClose, "INR", nil
-	}
-
-	// 2. Standard Yahoo Finance Fetch
-	urlStr := fmt.Sprintf("https://query1.finance.yahoo.com/v8/finance/chart/%s?interval=1d", symbol)
-	req, _ := http.NewRequest("GET", urlStr, nil)
-	// We use a User-Agent to prevent Yahoo from blocking us as a bot
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
-
-	if err != nil || resp.StatusCode != 200 {
-		return 0, 0, "", fmt.Errorf("bad status or connection error")
-	}
-	defer resp.Body.Close()
-
-	var data YahooResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return 0, 0, "", err
-	}
-
-	if len(data.Chart.Result) == 0 {
-		return 0, 0, "", fmt.Errorf("no data found for symbol")
-	}
-
-	meta := data.Chart.Result[0].Meta
-	return meta.RegularMarketPrice, meta.ChartPreviousClose, meta.Currency, nil
-}
-
 func fetchNewsForAssets(symbols []string) string {
 	if len(symbols) == 0 {
 		return "No news available."
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// --- MARKET DATA PROVIDERS ---
+
+// Quote is a latest-price snapshot for a symbol.
+type Quote struct {
+	Symbol        string  `json:"symbol"`
+	Price         float64 `json:"price"`
+	PreviousClose float64 `json:"previousClose"`
+	Currency      string  `json:"currency"`
+	Source        string  `json:"source"`
+	MarketState   string  `json:"marketState,omitempty"`
+	Date          string  `json:"date,omitempty"` // session the price belongs to, when the provider reports it
+}
+
+// PricePoint is one close in a price series.
+type PricePoint struct {
+	Time  time.Time
+	Close float64
+}
+
+// PriceSeries is a chronological (oldest first) list of closes.
+type PriceSeries struct {
+	Symbol   string
+	Currency string
+	Points   []PricePoint
+	Quote    Quote
+}
+
+// SearchResult mirrors the fields the frontend autocomplete already consumes.
+type SearchResult struct {
+	Symbol    string `json:"symbol"`
+	ShortName string `json:"shortname"`
+	Exchange  string `json:"exchange"`
+	QuoteType string `json:"quoteType"`
+}
+
+// lookupError reports a symbol a provider does not cover or has no data
+// for. It says nothing about the provider's health, so fallback chains move
+// on to the next provider without counting it as a failure.
+type lookupError struct{ msg string }
+
+func (e *lookupError) Error() string { return e.msg }
+
+func lookupErrorf(format string, args ...any) error {
+	return &lookupError{msg: fmt.Sprintf(format, args...)}
+}
+
+func isLookupError(err error) bool {
+	var le *lookupError
+	return errors.As(err, &le)
+}
+
+type QuoteProvider interface {
+	Name() string
+	Quote(ctx context.Context, symbol string) (Quote, error)
+}
+
+// HistoryProvider returns closes either for a chart range (whatever interval
+// suits the range) or as a daily series starting after a given date.
+type HistoryProvider interface {
+	Name() string
+	History(ctx context.Context, symbol, rng string) (PriceSeries, error)
+	DailyHistory(ctx context.Context, symbol string, from time.Time) (PriceSeries, error)
+}
+
+// BatchQuoteProvider fetches many quotes in one upstream call. Symbols it
+// could not price are simply absent from the result.
+type BatchQuoteProvider interface {
+	Name() string
+	Quotes(ctx context.Context, symbols []string) (map[string]Quote, error)
+}
+
+// InstrumentProfile is classification data for one instrument. Fields the
+// provider does not know are left empty.
+type InstrumentProfile struct {
+	Symbol            string   `json:"symbol"`
+	Name              string   `json:"name"`
+	QuoteType         string   `json:"quoteType"`
+	Sector            string   `json:"sector"`
+	Industry          string   `json:"industry"`
+	Country           string   `json:"country"`
+	MarketCap         float64  `json:"marketCap"`
+	MarketCapCurrency string   `json:"marketCapCurrency"`
+	MarketCapBucket   string   `json:"marketCapBucket"`
+	FundCategory      string   `json:"fundCategory"`
+	ExpenseRatio      *float64 `json:"expenseRatio"`
+	Source            string   `json:"source"`
+}
+
+type ProfileProvider interface {
+	Name() string
+	Profile(ctx context.Context, symbol string) (InstrumentProfile, error)
+}
+
+// DividendEvent is one cash distribution per share, keyed by its ex-date.
+type DividendEvent struct {
+	Symbol   string  `json:"symbol"`
+	ExDate   string  `json:"exDate"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Source   string  `json:"source"`
+}
+
+type DividendProvider interface {
+	Name() string
+	Dividends(ctx context.Context, symbol string, from time.Time) ([]DividendEvent, error)
+}
+
+// CorporateActionProvider lists splits, mergers and similar events that
+// change the shares an investor holds.
+type CorporateActionProvider interface {
+	Name() string
+	CorporateActions(ctx context.Context, symbol string, from time.Time) ([]CorporateAction, error)
+}
+
+type SymbolSearcher interface {
+	Name() string
+	Search(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
+type searcherEntry struct {
+	searcher SymbolSearcher
+	limit    int
+}
+
+// MarketData routes requests to providers registered per symbol scheme. The
+// scheme is the prefix before ":" (e.g. "AMFI" in "AMFI:119551"); symbols
+// without a prefix use the default "" scheme.
+//
+// Currency pairs ("INR=X") may be routed separately under the "FX" key.
+type MarketData struct {
+	quotes    map[string]QuoteProvider
+	histories map[string]HistoryProvider
+	searchers []searcherEntry
+	health    *healthRegistry
+	chains    map[string][]string
+	limits    map[string]*rateLimiter
+	batch     map[string]BatchQuoteProvider
+	profiles  map[string]ProfileProvider
+	dividends map[string]DividendProvider
+	actions   map[string]CorporateActionProvider
+	lastKnown *LastKnownProvider
+}
+
+func NewMarketData() *MarketData {
+	return &MarketData{
+		quotes:    map[string]QuoteProvider{},
+		histories: map[string]HistoryProvider{},
+		health:    &healthRegistry{},
+		chains:    map[string][]string{},
+		limits:    map[string]*rateLimiter{},
+		batch:     map[string]BatchQuoteProvider{},
+		profiles:  map[string]ProfileProvider{},
+		dividends: map[string]DividendProvider{},
+		actions:   map[string]CorporateActionProvider{},
+	}
+}
+
+// SetRateLimit paces calls to the named provider across all chains.
+func (m *MarketData) SetRateLimit(provider string, perSecond float64) {
+	m.limits[provider] = newRateLimiter(perSecond)
+}
+
+// RegisterBatch sets the batch quote provider for a scheme. Batch calls are
+// health-tracked as "<name>-batch" and share the provider's rate limit.
+func (m *MarketData) RegisterBatch(scheme string, p BatchQuoteProvider) {
+	m.batch[scheme] = p
+	m.health.get(p.Name() + "-batch")
+}
+
+func (m *MarketData) RegisterQuotes(scheme string, p QuoteProvider) {
+	m.quotes[scheme] = p
+}
+
+func (m *MarketData) RegisterHistory(scheme string, p HistoryProvider) {
+	m.histories[scheme] = p
+}
+
+func (m *MarketData) RegisterProfile(scheme string, p ProfileProvider) {
+	m.profiles[scheme] = p
+}
+
+func (m *MarketData) RegisterDividends(scheme string, p DividendProvider) {
+	m.dividends[scheme] = p
+}
+
+func (m *MarketData) RegisterCorporateActions(scheme string, p CorporateActionProvider) {
+	m.actions[scheme] = p
+}
+
+// AddSearcher appends a searcher; results are concatenated in registration order.
+func (m *MarketData) AddSearcher(s SymbolSearcher, limit int) {
+	m.searchers = append(m.searchers, searcherEntry{searcher: s, limit: limit})
+}
+
+func symbolScheme(symbol string) string {
+	if scheme, _, ok := strings.Cut(symbol, ":"); ok {
+		return strings.ToUpper(scheme)
+	}
+	return ""
+}
+
+// RegisterFallback routes quotes for a key through an ordered provider chain
+// guarded by per-provider circuit breakers.
+func (m *MarketData) RegisterFallback(key string, store *LastKnownProvider, chain ...QuoteProvider) {
+	names := make([]string, len(chain))
+	for i, p := range chain {
+		names[i] = p.Name()
+		m.health.get(p.Name())
+	}
+	m.chains[key] = names
+	m.quotes[key] = &FallbackQuotes{chain: chain, health: m.health, limits: m.limits, store: store}
+}
+
+// ProviderStatuses reports breaker state and call statistics per provider.
+func (m *MarketData) ProviderStatuses() []ProviderStatus {
+	return m.health.statuses()
+}
+
+func (m *MarketData) Quote(ctx context.Context, symbol string) (Quote, error) {
+	p, ok := m.quotes[symbolScheme(symbol)]
+	if isFXSymbol(symbol) {
+		if fx, found := m.quotes["FX"]; found {
+			p, ok = fx, true
+		}
+	}
+	if !ok {
+		return Quote{}, fmt.Errorf("no quote provider for %s", symbol)
+	}
+	q, err := p.Quote(ctx, symbol)
+	if err == nil {
+		q.MarketState = quoteMarketState(symbol, time.Now())
+	}
+	return q, err
+}
+
+// BatchQuotes prices as many symbols of one scheme as the batch provider can
+// in a single call. Callers should fall back to Quote for missing symbols.
+func (m *MarketData) BatchQuotes(ctx context.Context, scheme string, symbols []string) (map[string]Quote, error) {
+	p, ok := m.batch[scheme]
+	if !ok {
+		return nil, fmt.Errorf("no batch provider for scheme %q", scheme)
+	}
+	h := m.health.get(p.Name() + "-batch")
+	if !h.allow() {
+		return nil, fmt.Errorf("%s-batch: circuit open", p.Name())
+	}
+	if err := m.limits[p.Name()].Wait(ctx); err != nil {
+		h.record(0, err)
+		return nil, err
+	}
+
+	start := time.Now()
+	quotes, err := p.Quotes(ctx, symbols)
+	h.record(time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	if m.lastKnown != nil {
+		for _, q := range quotes {
+			m.lastKnown.Remember(ctx, q)
+		}
+	}
+	return quotes, nil
+}
+
+func (m *MarketData) History(ctx context.Context, symbol, rng string) (PriceSeries, error) {
+	p, ok := m.histories[symbolScheme(symbol)]
+	if !ok {
+		return PriceSeries{}, fmt.Errorf("no history provider for %s", symbol)
+	}
+	return p.History(ctx, symbol, rng)
+}
+
+func (m *MarketData) DailyHistory(ctx context.Context, symbol string, from time.Time) (PriceSeries, error) {
+	p, ok := m.histories[symbolScheme(symbol)]
+	if !ok {
+		return PriceSeries{}, fmt.Errorf("no history provider for %s", symbol)
+	}
+	return p.DailyHistory(ctx, symbol, from)
+}
+
+func (m *MarketData) Profile(ctx context.Context, symbol string) (InstrumentProfile, error) {
+	p, ok := m.profiles[symbolScheme(symbol)]
+	if !ok {
+		return InstrumentProfile{}, fmt.Errorf("no profile provider for %s", symbol)
+	}
+	if err := m.limits[p.Name()].Wait(ctx); err != nil {
+		return InstrumentProfile{}, err
+	}
+	return p.Profile(ctx, symbol)
+}
+
+func (m *MarketData) Dividends(ctx context.Context, symbol string, from time.Time) ([]DividendEvent, error) {
+	p, ok := m.dividends[symbolScheme(symbol)]
+	if !ok {
+		return nil, fmt.Errorf("no dividend provider for %s", symbol)
+	}
+	if err := m.limits[p.Name()].Wait(ctx); err != nil {
+		return nil, err
+	}
+	return p.Dividends(ctx, symbol, from)
+}
+
+func (m *MarketData) CorporateActions(ctx context.Context, symbol string, from time.Time) ([]CorporateAction, error) {
+	p, ok := m.actions[symbolScheme(symbol)]
+	if !ok {
+		return nil, fmt.Errorf("no corporate action provider for %s", symbol)
+	}
+	if err := m.limits[p.Name()].Wait(ctx); err != nil {
+		return nil, err
+	}
+	return p.CorporateActions(ctx, symbol, from)
+}
+
+// Search queries every searcher and ignores individual failures so one
+// unavailable source doesn't empty the autocomplete.
+func (m *MarketData) Search(ctx context.Context, query string) []SearchResult {
+	results := []SearchResult{}
+	for _, e := range m.searchers {
+		found, err := e.searcher.Search(ctx, query, e.limit)
+		if err != nil {
+			continue
+		}
+		results = append(results, found...)
+	}
+	return results
+}
+
+// market is the process-wide provider registry, built in main(). Tests can
+// replace it with fakes registered on a fresh NewMarketData().
+var market = NewMarketData()
+
+const defaultQuoteFallbacks = "default=yahoo,stooq,lastknown;FX=yahoo,stooq,lastknown;AMFI=amfi,lastknown"
+
+// defaultMarketData wires the bundled providers. Quote fallback chains come
+// from QUOTE_FALLBACKS (see parseFallbackConfig) or defaultQuoteFallbacks.
+func defaultMarketData(dbPool *pgxpool.Pool) *MarketData {
+	m := NewMarketData()
+	yahoo := &YahooProvider{}
+	amfi := &AMFIProvider{}
+	lastKnown := &LastKnownProvider{dbPool: dbPool}
+	m.lastKnown = lastKnown
+	byName := map[string]QuoteProvider{
+		yahoo.Name():     yahoo,
+		amfi.Name():      amfi,
+		"stooq":          &StooqProvider{},
+		lastKnown.Name(): lastKnown,
+	}
+
+	// Stay well under the rates at which upstreams start blocking us
+	m.SetRateLimit(yahoo.Name(), 5)
+	m.SetRateLimit(amfi.Name(), 4)
+	m.SetRateLimit("stooq", 2)
+	m.RegisterBatch("", yahoo)
+
+	cfg := os.Getenv("QUOTE_FALLBACKS")
+	if cfg == "" {
+		cfg = defaultQuoteFallbacks
+	}
+	for key, names := range parseFallbackConfig(cfg) {
+		var chain []QuoteProvider
+		for _, name := range names {
+			if p, ok := byName[name]; ok {
+				chain = append(chain, p)
+			} else {
+				log.Printf("Warning: unknown quote provider %q in QUOTE_FALLBACKS", name)
+			}
+		}
+		if len(chain) > 0 {
+			m.RegisterFallback(key, lastKnown, chain...)
+		}
+	}
+
+	m.RegisterHistory("", yahoo)
+	m.RegisterHistory("AMFI", amfi)
+	m.RegisterProfile("", yahoo)
+	m.RegisterProfile("AMFI", amfi)
+	m.RegisterDividends("", yahoo)
+	m.RegisterCorporateActions("", yahoo)
+	m.AddSearcher(yahoo, 4)
+	m.AddSearcher(amfi, 6)
+	return m
+}
+
+// --- SHARED HTTP HELPERS ---
+
+var marketHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// getJSON fetches url and decodes a 200 response into out. A 404 is a
+// lookupError: the upstream answered but does not know the resource.
+func getJSON(ctx context.Context, url string, headers map[string]string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := marketHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return lookupErrorf("not found at %s", req.URL.Host)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &httpStatusError{status: resp.StatusCode, host: req.URL.Host}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// httpStatusError is an upstream reply other than 200 or 404.
+type httpStatusError struct {
+	status int
+	host   string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("bad status %d from %s", e.status, e.host)
+}
+
+// rangeDays converts a chart range ("1wk", "1mo", "3mo", "1y", "max") to a
+// number of calendar days; 0 means unbounded.
+func rangeDays(rng string) int {
+	switch rng {
+	case "1d":
+		return 5
+	case "1wk", "5d":
+		return 7
+	case "1mo":
+		return 30
+	case "6mo":
+		return 182
+	case "1y":
+		return 365
+	case "2y":
+		return 730
+	case "5y":
+		return 1826
+	case "max":
+		return 0
+	}
+	return 90 // Default 3mo
+}
+
+// chartResponse renders a series in the Yahoo chart JSON shape the frontend
+// chart and live-price badge already understand.
+func chartResponse(s PriceSeries) gin.H {
+	timestamps := make([]int64, 0, len(s.Points))
+	closes := make([]float64, 0, len(s.Points))
+	for _, p := range s.Points {
+		timestamps = append(timestamps, p.Time.Unix())
+		closes = append(closes, p.Close)
+	}
+
+	return gin.H{
+		"chart": gin.H{
+			"result": []gin.H{
+				{
+					"meta": gin.H{
+						"symbol":             s.Symbol,
+						"currency":           s.Currency,
+						"regularMarketPrice": s.Quote.Price,
+						"chartPreviousClose": s.Quote.PreviousClose,
+						"marketState":        s.Quote.MarketState,
+					},
+					"timestamp": timestamps,
+					"indicators": gin.H{
+						"quote": []gin.H{
+							{"close": closes},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeQuoteProvider serves fixed quotes. Symbols it has no quote for are a
+// lookup error, as with the real providers; err, when set, fails every call.
+type fakeQuoteProvider struct {
+	name   string
+	quotes map[string]Quote
+	err    error
+	calls  int
+}
+
+func (f *fakeQuoteProvider) Name() string { return f.name }
+
+func (f *fakeQuoteProvider) Quote(ctx context.Context, symbol string) (Quote, error) {
+	f.calls++
+	if f.err != nil {
+		return Quote{}, f.err
+	}
+	q, ok := f.quotes[symbol]
+	if !ok {
+		return Quote{}, lookupErrorf("%s has no quote for %s", f.name, symbol)
+	}
+	q.Symbol, q.Source = symbol, f.name
+	return q, nil
+}
+
+// fakeHistoryProvider serves fixed daily closes per symbol.
+type fakeHistoryProvider struct {
+	name   string
+	closes map[string][]PricePoint
+}
+
+func (f *fakeHistoryProvider) Name() string { return f.name }
+
+func (f *fakeHistoryProvider) History(ctx context.Context, symbol, rng string) (PriceSeries, error) {
+	points, ok := f.closes[symbol]
+	if !ok {
+		return PriceSeries{}, lookupErrorf("%s has no history for %s", f.name, symbol)
+	}
+	return PriceSeries{Symbol: symbol, Points: points}, nil
+}
+
+func (f *fakeHistoryProvider) DailyHistory(ctx context.Context, symbol string, from time.Time) (PriceSeries, error) {
+	series, err := f.History(ctx, symbol, "max")
+	if err != nil {
+		return series, err
+	}
+	var points []PricePoint
+	for _, p := range series.Points {
+		if p.Time.After(from) {
+			points = append(points, p)
+		}
+	}
+	series.Points = points
+	return series, nil
+}
+
+func providerStatus(m *MarketData, name string) ProviderStatus {
+	for _, s := range m.ProviderStatuses() {
+		if s.Name == name {
+			return s
+		}
+	}
+	return ProviderStatus{}
+}
+
+func TestFallbackQuotes(t *testing.T) {
+	tests := []struct {
+		name            string
+		primary         *fakeQuoteProvider
+		symbol          string
+		wantSource      string
+		wantErr         bool
+		primaryFailures int64
+	}{
+		{
+			name:       "primary serves",
+			primary:    &fakeQuoteProvider{name: "primary", quotes: map[string]Quote{"ABC": {Price: 10}}},
+			symbol:     "ABC",
+			wantSource: "primary",
+		},
+		{
+			name:       "unknown symbol falls through without a failure",
+			primary:    &fakeQuoteProvider{name: "primary", quotes: map[string]Quote{}},
+			symbol:     "ABC",
+			wantSource: "secondary",
+		},
+		{
+			name:       "zero price falls through without a failure",
+			primary:    &fakeQuoteProvider{name: "primary", quotes: map[string]Quote{"ABC": {Price: 0}}},
+			symbol:     "ABC",
+			wantSource: "secondary",
+		},
+		{
+			name:            "outage falls through and counts",
+			primary:         &fakeQuoteProvider{name: "primary", err: errors.New("upstream returned 503")},
+			symbol:          "ABC",
+			wantSource:      "secondary",
+			primaryFailures: 1,
+		},
+		{
+			name:    "nobody has it",
+			primary: &fakeQuoteProvider{name: "primary", quotes: map[string]Quote{}},
+			symbol:  "XYZ",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secondary := &fakeQuoteProvider{name: "secondary", quotes: map[string]Quote{"ABC": {Price: 11}}}
+			m := NewMarketData()
+			m.RegisterFallback("", nil, tt.primary, secondary)
+
+			q, err := m.Quote(context.Background(), tt.symbol)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Quote() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && q.Source != tt.wantSource {
+				t.Errorf("Quote() source = %q, want %q", q.Source, tt.wantSource)
+			}
+			if got := providerStatus(m, "primary").Failures; got != tt.primaryFailures {
+				t.Errorf("primary failures = %d, want %d", got, tt.primaryFailures)
+			}
+		})
+	}
+}
+
+func TestFallbackBreakerIgnoresLookupErrors(t *testing.T) {
+	primary := &fakeQuoteProvider{name: "primary", quotes: map[string]Quote{"ABC": {Price: 10}}}
+	m := NewMarketData()
+	m.RegisterFallback("", nil, primary)
+
+	for i := 0; i < breakerFailureThreshold*2; i++ {
+		m.Quote(context.Background(), "MISSING")
+	}
+	if s := providerStatus(m, "primary"); s.State != "closed" || s.ConsecutiveFailures != 0 {
+		t.Fatalf("after unknown symbols: state %q with %d consecutive failures, want closed with 0", s.State, s.ConsecutiveFailures)
+	}
+	if _, err := m.Quote(context.Background(), "ABC"); err != nil {
+		t.Fatalf("Quote(ABC) = %v, want a quote", err)
+	}
+
+	primary.err = errors.New("connection refused")
+	for i := 0; i < breakerFailureThreshold; i++ {
+		m.Quote(context.Background(), "ABC")
+	}
+	calls := primary.calls
+	if _, err := m.Quote(context.Background(), "ABC"); err == nil {
+		t.Fatal("Quote() with an open breaker succeeded")
+	}
+	if primary.calls != calls {
+		t.Errorf("open breaker still called the provider")
+	}
+}
+
+func TestMarketDataHistoryRouting(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	m := NewMarketData()
+	m.RegisterHistory("", &fakeHistoryProvider{name: "stocks", closes: map[string][]PricePoint{
+		"ABC": {{Time: day(1), Close: 10}, {Time: day(2), Close: 11}, {Time: day(3), Close: 12}},
+	}})
+	m.RegisterHistory("AMFI", &fakeHistoryProvider{name: "funds", closes: map[string][]PricePoint{
+		"AMFI:100": {{Time: day(2), Close: 50}},
+	}})
+
+	tests := []struct {
+		name      string
+		symbol    string
+		from      time.Time
+		wantLen   int
+		wantFirst float64
+		wantErr   bool
+	}{
+		{name: "default scheme", symbol: "ABC", from: day(1), wantLen: 2, wantFirst: 11},
+		{name: "whole series", symbol: "ABC", from: day(0), wantLen: 3, wantFirst: 10},
+		{name: "scheme prefix", symbol: "AMFI:100", from: day(1), wantLen: 1, wantFirst: 50},
+		{name: "unregistered scheme", symbol: "CAS:1", from: day(1), wantErr: true},
+		{name: "unknown symbol", symbol: "XYZ", from: day(1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series, err := m.DailyHistory(context.Background(), tt.symbol, tt.from)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DailyHistory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(series.Points) != tt.wantLen || series.Points[0].Close != tt.wantFirst {
+				t.Errorf("DailyHistory() = %+v, want %d points from %g", series.Points, tt.wantLen, tt.wantFirst)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // Exchange time zones must resolve even on minimal images
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed data/exchanges.json
+var exchangesJSON []byte
+
+// Quote market states
+const (
+	MarketLive    = "live"
+	MarketDelayed = "delayed"
+	MarketClosed  = "closed"
+)
+
+// Exchange describes one venue's regular session and holiday calendar.
+type Exchange struct {
+	Code              string   `json:"code"`
+	Name              string   `json:"name"`
+	TimeZone          string   `json:"timeZone"`
+	Currency          string   `json:"currency,omitempty"`
+	Open              string   `json:"open"`
+	Close             string   `json:"close"`
+	Suffixes          []string `json:"suffixes,omitempty"`
+	SymbolSuffixes    []string `json:"symbolSuffixes,omitempty"`
+	Schemes           []string `json:"schemes,omitempty"`
+	Default           bool     `json:"default,omitempty"`
+	AlwaysOpen        bool     `json:"alwaysOpen,omitempty"`
+	EndOfDayOnly      bool     `json:"endOfDayOnly,omitempty"`
+	QuoteDelayMinutes int      `json:"quoteDelayMinutes,omitempty"`
+	HolidayCalendar   string   `json:"holidayCalendar,omitempty"`
+
+	loc          *time.Location
+	holidays     map[string]bool
+	holidayYears map[int]bool
+	earlyCloses  map[string]string
+}
+
+type holidayCalendar struct {
+	Holidays    []string          `json:"holidays"`
+	EarlyCloses map[string]string `json:"earlyCloses"`
+}
+
+// MarketStatus is an exchange's state at a point in time.
+type MarketStatus struct {
+	Code            string    `json:"code"`
+	Name            string    `json:"name"`
+	TimeZone        string    `json:"timeZone"`
+	LocalTime       string    `json:"localTime"`
+	IsOpen          bool      `json:"isOpen"`
+	IsTradingDay    bool      `json:"isTradingDay"`
+	SessionDate     string    `json:"sessionDate"`
+	PreviousSession string    `json:"previousSession"`
+	NextOpen        time.Time `json:"nextOpen,omitempty"`
+	NextClose       time.Time `json:"nextClose,omitempty"`
+	HolidayToday    bool      `json:"holidayToday"`
+	HolidaysKnown   bool      `json:"holidaysKnown"` // false when the calendar has no dates for this year
+}
+
+// exchanges is the registry loaded from the bundled data file at startup.
+var exchanges = loadExchanges(exchangesJSON)
+
+func loadExchanges(data []byte) []*Exchange {
+	var file struct {
+		Exchanges        []*Exchange                `json:"exchanges"`
+		HolidayCalendars map[string]holidayCalendar `json:"holidayCalendars"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		log.Fatal("Invalid bundled exchange data: ", err)
+	}
+
+	for _, e := range file.Exchanges {
+		loc, err := time.LoadLocation(e.TimeZone)
+		if err != nil {
+			log.Fatalf("Exchange %s has unknown time zone %s", e.Code, e.TimeZone)
+		}
+		e.loc = loc
+		e.holidays = map[string]bool{}
+		e.holidayYears = map[int]bool{}
+		if cal, ok := file.HolidayCalendars[e.HolidayCalendar]; ok {
+			for _, d := range cal.Holidays {
+				e.holidays[d] = true
+				if t, err := time.Parse(dateLayout, d); err == nil {
+					e.holidayYears[t.Year()] = true
+				}
+			}
+			e.earlyCloses = cal.EarlyCloses
+		}
+		if year := time.Now().In(loc).Year(); !e.HasHolidays(year) {
+			log.Printf("Warning: exchange %s has no %d holidays in the bundled calendar; they will be treated as trading days", e.Code, year)
+		}
+	}
+	return file.Exchanges
+}
+
+// HasHolidays reports whether the exchange's holiday calendar covers year.
+// Venues without a calendar (FX, crypto) always do.
+func (e *Exchange) HasHolidays(year int) bool {
+	return e.HolidayCalendar == "" || e.holidayYears[year]
+}
+
+func exchangeByCode(code string) *Exchange {
+	for _, e := range exchanges {
+		if strings.EqualFold(e.Code, code) {
+			return e
+		}
+	}
+	return nil
+}
+
+// exchangeForSymbol resolves a Yahoo-style or scheme-prefixed symbol to its
+// venue. Unsuffixed tickers map to the default (NYSE) calendar, which NASDAQ
+// shares, since the symbol alone cannot tell them apart.
+func exchangeForSymbol(symbol string) *Exchange {
+	scheme := symbolScheme(symbol)
+	var fallback *Exchange
+	for _, e := range exchanges {
+		for _, s := range e.Schemes {
+			if scheme == s {
+				return e
+			}
+		}
+		for _, suf := range e.Suffixes {
+			if strings.HasSuffix(strings.ToUpper(symbol), suf) {
+				return e
+			}
+		}
+		for _, suf := range e.SymbolSuffixes {
+			if strings.HasSuffix(strings.ToUpper(symbol), suf) {
+				return e
+			}
+		}
+		if e.Default {
+			fallback = e
+		}
+	}
+	// Indices (^GSPC, ^NSEI) carry no venue hint, so leave them unresolved
+	if scheme == "" && !strings.Contains(symbol, ".") && !strings.HasPrefix(symbol, "^") {
+		return fallback
+	}
+	return nil
+}
+
+func parseHHMM(s string) (int, int) {
+	var h, m int
+	fmt.Sscanf(s, "%d:%d", &h, &m)
+	return h, m
+}
+
+// IsTradingDay reports whether the exchange opens on the given local date.
+func (e *Exchange) IsTradingDay(day time.Time) bool {
+	if e.AlwaysOpen {
+		return true
+	}
+	local := day.In(e.loc)
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return false
+	}
+	return !e.holidays[local.Format(dateLayout)]
+}
+
+// session returns the open and close instants for the local date of day.
+func (e *Exchange) session(day time.Time) (time.Time, time.Time) {
+	local := day.In(e.loc)
+	y, mo, d := local.Date()
+	oh, om := parseHHMM(e.Open)
+	closeStr := e.Close
+	if early, ok := e.earlyCloses[local.Format(dateLayout)]; ok {
+		closeStr = early
+	}
+	ch, cm := parseHHMM(closeStr)
+	return time.Date(y, mo, d, oh, om, 0, 0, e.loc), time.Date(y, mo, d, ch, cm, 0, 0, e.loc)
+}
+
+func (e *Exchange) IsOpen(now time.Time) bool {
+	if e.AlwaysOpen {
+		return true
+	}
+	if !e.IsTradingDay(now) {
+		return false
+	}
+	open, close := e.session(now)
+	return !now.Before(open) && now.Before(close)
+}
+
+// SessionDate is the local date of the latest session that has started at
+// now, i.e. the day a close observed now belongs to.
+func (e *Exchange) SessionDate(now time.Time) time.Time {
+	day := now.In(e.loc)
+	for i := 0; i < 15; i++ {
+		if e.IsTradingDay(day) {
+			open, _ := e.session(day)
+			if i > 0 || !now.Before(open) {
+				y, m, d := day.Date()
+				return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+			}
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	y, m, d := now.In(e.loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// PreviousTradingDay is the last trading date strictly before date.
+func (e *Exchange) PreviousTradingDay(date time.Time) time.Time {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, e.loc).AddDate(0, 0, -1)
+	for i := 0; i < 15 && !e.IsTradingDay(day); i++ {
+		day = day.AddDate(0, 0, -1)
+	}
+	y, m, d := day.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// nextBoundaries finds the next open and close instants after now.
+func (e *Exchange) nextBoundaries(now time.Time) (time.Time, time.Time) {
+	if e.AlwaysOpen {
+		return time.Time{}, time.Time{}
+	}
+	var nextOpen, nextClose time.Time
+	day := now.In(e.loc)
+	for i := 0; i < 15 && (nextOpen.IsZero() || nextClose.IsZero()); i++ {
+		if e.IsTradingDay(day) {
+			open, close := e.session(day)
+			if nextOpen.IsZero() && open.After(now) {
+				nextOpen = open
+			}
+			if nextClose.IsZero() && close.After(now) {
+				nextClose = close
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return nextOpen, nextClose
+}
+
+func (e *Exchange) Status(now time.Time) MarketStatus {
+	session := e.SessionDate(now)
+	nextOpen, nextClose := e.nextBoundaries(now)
+	local := now.In(e.loc)
+	return MarketStatus{
+		Code:            e.Code,
+		Name:            e.Name,
+		TimeZone:        e.TimeZone,
+		LocalTime:       local.Format("2006-01-02 15:04"),
+		IsOpen:          e.IsOpen(now),
+		IsTradingDay:    e.IsTradingDay(now),
+		SessionDate:     session.Format(dateLayout),
+		PreviousSession: e.PreviousTradingDay(session).Format(dateLayout),
+		NextOpen:        nextOpen,
+		NextClose:       nextClose,
+		HolidayToday:    e.holidays[local.Format(dateLayout)],
+		HolidaysKnown:   e.HasHolidays(local.Year()),
+	}
+}
+
+// quoteMarketState labels a quote for symbol as live, delayed or closed.
+func quoteMarketState(symbol string, now time.Time) string {
+	e := exchangeForSymbol(symbol)
+	if e == nil || e.EndOfDayOnly || !e.IsOpen(now) {
+		return MarketClosed
+	}
+	if e.QuoteDelayMinutes > 0 {
+		return MarketDelayed
+	}
+	return MarketLive
+}
+
+// symbolMarketOpen reports whether the symbol's exchange is in session.
+func symbolMarketOpen(symbol string, now time.Time) bool {
+	e := exchangeForSymbol(symbol)
+	return e != nil && !e.EndOfDayOnly && e.IsOpen(now)
+}
+
+// anyMarketOpen reports whether any exchange with a regular session (not
+// 24-hour venues or end-of-day NAVs) is trading.
+func anyMarketOpen(now time.Time) bool {
+	for _, e := range exchanges {
+		if e.AlwaysOpen || e.EndOfDayOnly || e.Open == "00:00" {
+			continue
+		}
+		if e.IsOpen(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// previousCloseFor returns the stored close of the trading day before the
+// given session, so daily change spans exactly one session even across
+// weekends and holidays.
+func previousCloseFor(ctx context.Context, q dbQuerier, symbol string, session time.Time) (float64, bool) {
+	e := exchangeForSymbol(symbol)
+	if e == nil {
+		return 0, false
+	}
+	prevDay := e.PreviousTradingDay(session)
+
+	var close float64
+	err := q.QueryRow(ctx,
+		"SELECT close FROM price_history WHERE symbol=$1 AND date <= $2 ORDER BY date DESC LIMIT 1",
+		symbol, prevDay).Scan(&close)
+	return close, err == nil
+}
+
+// --- ROUTES ---
+
+func registerMarketRoutes(r *gin.Engine) {
+	// GET /api/markets - Every exchange with its current session status
+	r.GET("/api/markets", func(c *gin.Context) {
+		now := time.Now()
+		statuses := make([]MarketStatus, 0, len(exchanges))
+		for _, e := range exchanges {
+			statuses = append(statuses, e.Status(now))
+		}
+		c.JSON(http.StatusOK, statuses)
+	})
+
+	// GET /api/markets/:code - One exchange with its configuration and upcoming holidays
+	r.GET("/api/markets/:code", func(c *gin.Context) {
+		e := exchangeByCode(c.Param("code"))
+		if e == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown exchange"})
+			return
+		}
+
+		today := time.Now().In(e.loc).Format(dateLayout)
+		upcoming := []string{}
+		for d := range e.holidays {
+			if d >= today {
+				upcoming = append(upcoming, d)
+			}
+		}
+		sort.Strings(upcoming)
+		c.JSON(http.StatusOK, gin.H{"exchange": e, "status": e.Status(time.Now()), "upcomingHolidays": upcoming})
+	})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// schemaMigrations are applied in order on every startup, so each statement
+// must be idempotent (IF NOT EXISTS / ON CONFLICT DO NOTHING).
+var schemaMigrations = []string{
+	// Nickname column for user-friendly asset labels
+	`ALTER TABLE assets ADD COLUMN IF NOT EXISTS nickname VARCHAR(255)`,
+
+	// Refresh tokens (stored hashed) grouped into rotation families
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash VARCHAR(64) NOT NULL UNIQUE,
+		family_id VARCHAR(32) NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		revoked_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id)`,
+
+	// Transaction ledger; assets.quantity/avg_price are derived from it
+	`CREATE TABLE IF NOT EXISTS transactions (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		symbol VARCHAR(255) NOT NULL,
+		txn_type VARCHAR(20) NOT NULL,
+		trade_date DATE NOT NULL,
+		quantity DOUBLE PRECISION NOT NULL DEFAULT 0,
+		price DOUBLE PRECISION NOT NULL DEFAULT 0,
+		fees DOUBLE PRECISION NOT NULL DEFAULT 0,
+		currency VARCHAR(10) NOT NULL,
+		notes TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_transactions_user_symbol ON transactions(user_id, symbol, trade_date)`,
+	// One-off data migrations that must not repeat on later startups
+	`CREATE TABLE IF NOT EXISTS data_migrations (
+		name VARCHAR(64) PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+
+	// Per-user preferences
+	`CREATE TABLE IF NOT EXISTS user_settings (
+		user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		lot_method VARCHAR(10) NOT NULL DEFAULT 'fifo'
+	)`,
+
+	// Specific-lot identification: which opening lots a sell disposes of
+	`CREATE TABLE IF NOT EXISTS lot_selections (
+		id SERIAL PRIMARY KEY,
+		sell_txn_id INT NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+		lot_txn_id INT NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+		quantity DOUBLE PRECISION NOT NULL
+	)`,
+
+	// Most recent successful quote per symbol, the last resort in fallback chains
+	`CREATE TABLE IF NOT EXISTS last_quotes (
+		symbol VARCHAR(255) PRIMARY KEY,
+		price DOUBLE PRECISION NOT NULL,
+		previous_close DOUBLE PRECISION NOT NULL,
+		currency VARCHAR(10) NOT NULL,
+		source VARCHAR(32) NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+
+	// Persisted daily closes and the upstream window already fetched per symbol
+	`CREATE TABLE IF NOT EXISTS price_history (
+		symbol VARCHAR(255) NOT NULL,
+		date DATE NOT NULL,
+		close DOUBLE PRECISION NOT NULL,
+		currency VARCHAR(10) NOT NULL,
+		source VARCHAR(32) NOT NULL,
+		PRIMARY KEY (symbol, date)
+	)`,
+	`CREATE TABLE IF NOT EXISTS price_history_coverage (
+		symbol VARCHAR(255) PRIMARY KEY,
+		covered_from DATE NOT NULL,
+		covered_to DATE NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+
+	// Admin flag for operational endpoints such as forced price refreshes
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE`,
+
+	// Background job state shared by all instances; locked_until is the run lease
+	`CREATE TABLE IF NOT EXISTS scheduled_jobs (
+		name VARCHAR(64) PRIMARY KEY,
+		next_run_at TIMESTAMPTZ NOT NULL,
+		last_run_at TIMESTAMPTZ,
+		locked_by VARCHAR(255),
+		locked_until TIMESTAMPTZ,
+		last_status VARCHAR(16),
+		last_error TEXT,
+		last_report JSONB
+	)`,
+
+	// Daily FX rates; stored against USD and crossed for other pairs
+	`CREATE TABLE IF NOT EXISTS fx_rates (
+		date DATE NOT NULL,
+		base VARCHAR(10) NOT NULL,
+		quote VARCHAR(10) NOT NULL,
+		rate DOUBLE PRECISION NOT NULL,
+		source VARCHAR(32) NOT NULL,
+		PRIMARY KEY (date, base, quote)
+	)`,
+
+	// Reporting currency for server-side portfolio valuation
+	`ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS base_currency VARCHAR(10) NOT NULL DEFAULT 'USD'`,
+
+	// End-of-day portfolio totals in the user's base currency
+	`CREATE TABLE IF NOT EXISTS portfolio_snapshots (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		date DATE NOT NULL,
+		currency VARCHAR(10) NOT NULL,
+		total_value DOUBLE PRECISION NOT NULL,
+		total_cost DOUBLE PRECISION NOT NULL,
+		holdings JSONB NOT NULL DEFAULT '[]',
+		PRIMARY KEY (user_id, date)
+	)`,
+
+	// Indices the user compares the portfolio against
+	`ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS benchmarks TEXT[] NOT NULL DEFAULT '{}'`,
+
+	// Classification from provider profiles and AMFI scheme categories
+	`CREATE TABLE IF NOT EXISTS instrument_metadata (
+		symbol VARCHAR(255) PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		quote_type VARCHAR(32) NOT NULL DEFAULT '',
+		sector VARCHAR(128) NOT NULL DEFAULT '',
+		industry VARCHAR(128) NOT NULL DEFAULT '',
+		country VARCHAR(64) NOT NULL DEFAULT '',
+		market_cap DOUBLE PRECISION NOT NULL DEFAULT 0,
+		market_cap_currency VARCHAR(10) NOT NULL DEFAULT '',
+		market_cap_bucket VARCHAR(16) NOT NULL DEFAULT '',
+		fund_category VARCHAR(128) NOT NULL DEFAULT '',
+		expense_ratio DOUBLE PRECISION,
+		source VARCHAR(32) NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+
+	// Target mix for rebalancing; tag is a free-form group for custom targets
+	`ALTER TABLE assets ADD COLUMN IF NOT EXISTS tag VARCHAR(64)`,
+	`CREATE TABLE IF NOT EXISTS allocation_targets (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		kind VARCHAR(10) NOT NULL,
+		key VARCHAR(255) NOT NULL,
+		weight DOUBLE PRECISION NOT NULL,
+		tolerance DOUBLE PRECISION NOT NULL,
+		PRIMARY KEY (user_id, key)
+	)`,
+
+	// Provider dividend history, and which events were already booked per user
+	`CREATE TABLE IF NOT EXISTS dividend_events (
+		symbol VARCHAR(255) NOT NULL,
+		ex_date DATE NOT NULL,
+		amount DOUBLE PRECISION NOT NULL,
+		currency VARCHAR(10) NOT NULL,
+		source VARCHAR(32) NOT NULL,
+		PRIMARY KEY (symbol, ex_date)
+	)`,
+	`CREATE TABLE IF NOT EXISTS dividend_postings (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		symbol VARCHAR(255) NOT NULL,
+		ex_date DATE NOT NULL,
+		PRIMARY KEY (user_id, symbol, ex_date)
+	)`,
+	`ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS dividend_withholding DOUBLE PRECISION NOT NULL DEFAULT 0`,
+	// Reinvest imported dividends into the same holding
+	`ALTER TABLE assets ADD COLUMN IF NOT EXISTS drip BOOLEAN NOT NULL DEFAULT FALSE`,
+	// Per-holding withholding rate; NULL falls back to the user setting
+	`ALTER TABLE assets ADD COLUMN IF NOT EXISTS withholding DOUBLE PRECISION`,
+
+	// Corporate actions: provider-reported ones have no user_id and apply to
+	// every holder, manual ones only to the user who entered them
+	`CREATE TABLE IF NOT EXISTS corporate_actions (
+		id SERIAL PRIMARY KEY,
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		symbol VARCHAR(255) NOT NULL,
+		action_type VARCHAR(20) NOT NULL,
+		ex_date DATE NOT NULL,
+		ratio DOUBLE PRECISION NOT NULL,
+		new_symbol VARCHAR(255),
+		source VARCHAR(32) NOT NULL,
+		notes TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_corporate_actions_global ON corporate_actions(symbol, action_type, ex_date) WHERE user_id IS NULL`,
+	// Audit trail of each action applied to a user's holdings
+	`CREATE TABLE IF NOT EXISTS corporate_action_log (
+		action_id INT NOT NULL REFERENCES corporate_actions(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		quantity_before DOUBLE PRECISION NOT NULL,
+		quantity_after DOUBLE PRECISION NOT NULL,
+		txn_ids INT[] NOT NULL DEFAULT '{}',
+		notes TEXT,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (action_id, user_id)
+	)`,
+
+	// Statement imports: per-user ticker overrides and fingerprints of
+	// imported rows so re-uploading a file skips what is already in the ledger
+	`CREATE TABLE IF NOT EXISTS symbol_mappings (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		source VARCHAR(255) NOT NULL,
+		symbol VARCHAR(255) NOT NULL,
+		PRIMARY KEY (user_id, source)
+	)`,
+	`CREATE TABLE IF NOT EXISTS import_rows (
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		fingerprint VARCHAR(64) NOT NULL,
+		format VARCHAR(32) NOT NULL,
+		txn_id INT REFERENCES transactions(id) ON DELETE SET NULL,
+		imported_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, fingerprint)
+	)`,
+
+	// AMFI scheme master, for mapping mutual fund ISINs to scheme codes
+	`CREATE TABLE IF NOT EXISTS amfi_schemes (
+		scheme_code INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		isin_growth VARCHAR(12),
+		isin_reinvest VARCHAR(12),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_amfi_schemes_isin_growth ON amfi_schemes(isin_growth)`,
+	`CREATE INDEX IF NOT EXISTS idx_amfi_schemes_isin_reinvest ON amfi_schemes(isin_reinvest)`,
+}
+
+func runMigrations(dbPool *pgxpool.Pool) {
+	for _, stmt := range schemaMigrations {
+		if _, err := dbPool.Exec(context.Background(), stmt); err != nil {
+			log.Println("Warning: schema migration failed:", err)
+		}
+	}
+	if err := seedOpeningBalances(context.Background(), dbPool); err != nil {
+		log.Println("Warning: opening balance migration failed:", err)
+	}
+}
+
+// seedOpeningBalances gives holdings that predate the ledger an opening buy,
+// once per database. The buy is dated OPENING_BALANCE_DATE when set, else
+// the asset row's created_at when the table has one, else the migration
+// day.
+func seedOpeningBalances(ctx context.Context, dbPool *pgxpool.Pool) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "INSERT INTO data_migrations (name) VALUES ('opening-balances') ON CONFLICT DO NOTHING")
+	if err != nil || tag.RowsAffected() == 0 {
+		return err
+	}
+
+	dateExpr := "CURRENT_DATE"
+	if v := os.Getenv("OPENING_BALANCE_DATE"); v != "" {
+		if _, err := time.Parse(dateLayout, v); err != nil {
+			return fmt.Errorf("OPENING_BALANCE_DATE must be YYYY-MM-DD: %w", err)
+		}
+		dateExpr = "'" + v + "'::date"
+	} else {
+		var hasCreated bool
+		err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM information_schema.columns
+			WHERE table_name='assets' AND column_name='created_at')`).Scan(&hasCreated)
+		if err != nil {
+			return err
+		}
+		if hasCreated {
+			dateExpr = "COALESCE(a.created_at::date, CURRENT_DATE)"
+		}
+	}
+	tag, err = tx.Exec(ctx, `
+		INSERT INTO transactions (user_id, symbol, txn_type, trade_date, quantity, price, fees, currency, notes)
+		SELECT a.user_id, a.name, 'buy', `+dateExpr+`, a.quantity, a.avg_price, 0, a.currency, 'Opening balance'
+		FROM assets a
+		WHERE a.quantity > 0 AND NOT EXISTS (SELECT 1 FROM transactions t WHERE t.user_id=a.user_id AND t.symbol=a.name)`)
+	if err != nil {
+		return err
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		log.Printf("Seeded %d opening balances", n)
+	}
+	return tx.Commit(ctx)
+}
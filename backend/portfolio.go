@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// HoldingValuation is one position converted to the reporting currency.
+// Unrealized P&L splits into the price move (valued at today's rate) and the
+// currency move on the original cost (today's rate versus trade-date rates).
+type HoldingValuation struct {
+	Symbol       string  `json:"symbol"`
+	Nickname     string  `json:"nickname"`
+	Currency     string  `json:"currency"`
+	Quantity     float64 `json:"quantity"`
+	Price        float64 `json:"price"`
+	FXRate       float64 `json:"fxRate"`
+	Value        float64 `json:"value"`
+	Cost         float64 `json:"cost"`
+	DayChange    float64 `json:"dayChange"`
+	UnrealizedPL float64 `json:"unrealizedPL"`
+	PriceGain    float64 `json:"priceGain"`
+	FXGain       float64 `json:"fxGain"`
+}
+
+// PortfolioSummary totals every holding in one currency.
+type PortfolioSummary struct {
+	BaseCurrency    string             `json:"baseCurrency"`
+	TotalValue      float64            `json:"totalValue"`
+	TotalCost       float64            `json:"totalCost"`
+	DayChange       float64            `json:"dayChange"`
+	DayChangePct    float64            `json:"dayChangePct"`
+	UnrealizedPL    float64            `json:"unrealizedPL"`
+	UnrealizedPLPct float64            `json:"unrealizedPLPct"`
+	PriceGain       float64            `json:"priceGain"`
+	FXGain          float64            `json:"fxGain"`
+	Holdings        []HoldingValuation `json:"holdings"`
+	MissingRates    []string           `json:"missingRates,omitempty"`
+}
+
+// fxConverter caches rates into one target currency for the duration of a
+// request. Currencies with no rate at all are collected in missing.
+type fxConverter struct {
+	ctx     context.Context
+	q       dbQuerier
+	to      string
+	live    map[string]float64
+	dated   map[string]float64
+	missing map[string]bool
+}
+
+func newFXConverter(ctx context.Context, q dbQuerier, to string) *fxConverter {
+	return &fxConverter{ctx: ctx, q: q, to: to, live: map[string]float64{}, dated: map[string]float64{}, missing: map[string]bool{}}
+}
+
+// now returns today's rate from currency into the target currency.
+func (f *fxConverter) now(currency string) (float64, bool) {
+	if currency == f.to {
+		return 1, true
+	}
+	fromRate, ok1 := f.usd(currency)
+	toRate, ok2 := f.usd(f.to)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return toRate / fromRate, true
+}
+
+// usd caches today's units of currency per USD.
+func (f *fxConverter) usd(currency string) (float64, bool) {
+	rate, ok := f.live[currency]
+	if !ok {
+		var err error
+		if rate, err = liveUSDRate(f.ctx, f.q, currency); err != nil || rate <= 0 {
+			rate = 0
+			f.missing[currency] = true
+		}
+		f.live[currency] = rate
+	}
+	return rate, rate > 0
+}
+
+// on returns the rate from currency into the target currency on date,
+// falling back to today's rate when no history is stored.
+func (f *fxConverter) on(currency, date string) (float64, bool) {
+	key := currency + "|" + date
+	if rate, ok := f.dated[key]; ok {
+		return rate, rate > 0
+	}
+	day, err := time.Parse(dateLayout, date)
+	rate := 0.0
+	if err == nil {
+		rate, err = fxRateOn(f.ctx, f.q, currency, f.to, day)
+	}
+	if err != nil {
+		rate, _ = f.now(currency)
+	}
+	f.dated[key] = rate
+	return rate, rate > 0
+}
+
+func (f *fxConverter) missingRates() []string {
+	out := make([]string, 0, len(f.missing))
+	for c := range f.missing {
+		out = append(out, c)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// valuePortfolio converts the user's open positions into base. Cost basis is
+// taken from open lots so each lot converts at the rate of its own trade date.
+func valuePortfolio(ctx context.Context, dbPool *pgxpool.Pool, userID int, base string) (PortfolioSummary, error) {
+	summary := PortfolioSummary{BaseCurrency: base, Holdings: []HoldingValuation{}}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT name, COALESCE(nickname, ''), quantity, current_price, previous_close, currency
+		FROM assets WHERE user_id=$1 AND quantity > 0 ORDER BY name`, userID)
+	if err != nil {
+		return summary, err
+	}
+	var holdings []HoldingValuation
+	prevCloses := map[string]float64{}
+	for rows.Next() {
+		var h HoldingValuation
+		var prev float64
+		if err := rows.Scan(&h.Symbol, &h.Nickname, &h.Quantity, &h.Price, &prev, &h.Currency); err != nil {
+			rows.Close()
+			return summary, err
+		}
+		h.Currency = normalizeCurrency(h.Currency)
+		prevCloses[h.Symbol] = prev
+		holdings = append(holdings, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+
+	open, _, err := userLots(ctx, dbPool, userID, "")
+	if err != nil {
+		return summary, err
+	}
+	lotsBySymbol := map[string][]OpenLot{}
+	for _, l := range open {
+		lotsBySymbol[l.Symbol] = append(lotsBySymbol[l.Symbol], l)
+	}
+
+	fx := newFXConverter(ctx, dbPool, base)
+	prevValue := 0.0
+	for _, h := range holdings {
+		rate, ok := fx.now(h.Currency)
+		if !ok {
+			continue
+		}
+		h.FXRate = rate
+		nativeValue := h.Quantity * h.Price
+		h.Value = nativeValue * rate
+		h.DayChange = h.Quantity * (h.Price - prevCloses[h.Symbol]) * rate
+		if prevCloses[h.Symbol] == 0 {
+			h.DayChange = 0
+		}
+
+		nativeCost := 0.0
+		for _, l := range lotsBySymbol[h.Symbol] {
+			lotRate, _ := fx.on(h.Currency, l.OpenDate)
+			nativeCost += l.CostBasis
+			h.Cost += l.CostBasis * lotRate
+		}
+		h.UnrealizedPL = h.Value - h.Cost
+		h.PriceGain = (nativeValue - nativeCost) * rate
+		h.FXGain = h.UnrealizedPL - h.PriceGain
+
+		summary.TotalValue += h.Value
+		summary.TotalCost += h.Cost
+		summary.DayChange += h.DayChange
+		summary.PriceGain += h.PriceGain
+		summary.FXGain += h.FXGain
+		prevValue += h.Value - h.DayChange
+		summary.Holdings = append(summary.Holdings, h)
+	}
+
+	summary.UnrealizedPL = summary.TotalValue - summary.TotalCost
+	if summary.TotalCost > 0 {
+		summary.UnrealizedPLPct = summary.UnrealizedPL / summary.TotalCost * 100
+	}
+	if prevValue > 0 {
+		summary.DayChangePct = summary.DayChange / prevValue * 100
+	}
+	summary.MissingRates = fx.missingRates()
+	return summary, nil
+}
+
+// --- ROUTES ---
+
+func registerPortfolioRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/portfolio/summary?currency= - Totals converted to the user's base currency (or an override)
+	api.GET("/portfolio/summary", func(c *gin.Context) {
+		ctx := context.Background()
+		userID := currentUserID(c)
+
+		settings, err := loadUserSettings(ctx, dbPool, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		base := settings.BaseCurrency
+		if override := strings.ToUpper(c.Query("currency")); override != "" {
+			if !validCurrencyCode(override) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a three-letter currency code"})
+				return
+			}
+			base = override
+		}
+
+		summary, err := valuePortfolio(ctx, dbPool, userID, base)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to value portfolio"})
+			return
+		}
+		c.JSON(http.StatusOK, summary)
+	})
+}
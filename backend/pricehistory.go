@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// historyEpoch stands in for "all time" when a chart range is unbounded.
+var historyEpoch = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// maxChartPoints caps long ranges; beyond it the series is thinned to month-ends.
+const maxChartPoints = 1000
+
+// storePriceSeries upserts daily closes into price_history in one statement.
+func storePriceSeries(ctx context.Context, q dbQuerier, series PriceSeries, source string) error {
+	if len(series.Points) == 0 {
+		return nil
+	}
+	dates := make([]string, len(series.Points))
+	closes := make([]float64, len(series.Points))
+	for i, p := range series.Points {
+		dates[i] = p.Time.Format(dateLayout)
+		closes[i] = p.Close
+	}
+
+	_, err := q.Exec(ctx, `
+		INSERT INTO price_history (symbol, date, close, currency, source)
+		SELECT $1, d::date, c, $4, $5 FROM unnest($2::text[], $3::float8[]) AS t(d, c)
+		ON CONFLICT (symbol, date) DO UPDATE SET close=EXCLUDED.close, currency=EXCLUDED.currency, source=EXCLUDED.source`,
+		series.Symbol, dates, closes, series.Currency, source)
+	return err
+}
+
+func loadPriceHistory(ctx context.Context, q dbQuerier, symbol string, from time.Time) (PriceSeries, error) {
+	rows, err := q.Query(ctx,
+		"SELECT date, close, currency FROM price_history WHERE symbol=$1 AND date > $2 ORDER BY date",
+		symbol, from)
+	if err != nil {
+		return PriceSeries{}, err
+	}
+	defer rows.Close()
+
+	series := PriceSeries{Symbol: symbol}
+	for rows.Next() {
+		var p PricePoint
+		if err := rows.Scan(&p.Time, &p.Close, &series.Currency); err != nil {
+			return PriceSeries{}, err
+		}
+		series.Points = append(series.Points, p)
+	}
+	return series, rows.Err()
+}
+
+// ensurePriceHistory makes sure price_history holds upstream data for symbol
+// from the given date up to yesterday. price_history_coverage records which
+// window has already been fetched, so young instruments whose data simply
+// starts later than from are not re-requested on every call.
+func ensurePriceHistory(ctx context.Context, dbPool *pgxpool.Pool, symbol string, from time.Time) error {
+	var coveredFrom, coveredTo time.Time
+	err := dbPool.QueryRow(ctx,
+		"SELECT covered_from, covered_to FROM price_history_coverage WHERE symbol=$1", symbol).Scan(&coveredFrom, &coveredTo)
+	if err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+	var fetchFrom time.Time
+	switch {
+	case err == pgx.ErrNoRows:
+		fetchFrom = from
+	case from.Before(coveredFrom):
+		// Missing the start of the window; refetch from the requested date
+		fetchFrom = from
+	case coveredTo.Before(yesterday):
+		// Only the tail is stale; fetch just the missing days
+		fetchFrom = coveredTo
+	default:
+		return nil
+	}
+
+	series, err := market.DailyHistory(ctx, symbol, fetchFrom)
+	if err != nil {
+		return err
+	}
+	if err := storePriceSeries(ctx, dbPool, series, series.Quote.Source); err != nil {
+		return err
+	}
+	// Snapshots from the first new close on were valued without it
+	if len(series.Points) > 0 {
+		if err := invalidateSymbolSnapshots(ctx, dbPool, symbol, series.Points[0].Time); err != nil {
+			return err
+		}
+	}
+
+	if coveredFrom.IsZero() || fetchFrom.Before(coveredFrom) {
+		coveredFrom = fetchFrom
+	}
+	_, err = dbPool.Exec(ctx, `
+		INSERT INTO price_history_coverage (symbol, covered_from, covered_to, updated_at) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (symbol) DO UPDATE SET covered_from=EXCLUDED.covered_from, covered_to=EXCLUDED.covered_to, updated_at=NOW()`,
+		symbol, coveredFrom, today)
+	return err
+}
+
+// thinToMonthEnds keeps the last close of each calendar month.
+func thinToMonthEnds(points []PricePoint) []PricePoint {
+	var out []PricePoint
+	for i, p := range points {
+		if i == len(points)-1 || points[i+1].Time.Month() != p.Time.Month() || points[i+1].Time.Year() != p.Time.Year() {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// trackedSymbols lists every symbol that appears in a holding or the ledger,
+// with the earliest trade date per symbol.
+func trackedSymbols(ctx context.Context, q dbQuerier) (map[string]time.Time, error) {
+	rows, err := q.Query(ctx, `
+		SELECT name, COALESCE((SELECT MIN(trade_date) FROM transactions t WHERE t.symbol = a.name), CURRENT_DATE)
+		FROM (SELECT DISTINCT name FROM assets UNION SELECT DISTINCT symbol FROM transactions) a`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	symbols := map[string]time.Time{}
+	for rows.Next() {
+		var name string
+		var first time.Time
+		if err := rows.Scan(&name, &first); err != nil {
+			return nil, err
+		}
+		symbols[name] = first
+	}
+	return symbols, rows.Err()
+}
+
+// backfillPriceHistory fills gaps for every tracked symbol, going back to the
+// earliest trade or minDays, whichever is further. It is run via
+// `portfolio-backend backfill [days]`.
+func backfillPriceHistory(dbPool *pgxpool.Pool, minDays int) error {
+	ctx := context.Background()
+	symbols, err := trackedSymbols(ctx, dbPool)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for symbol, firstTrade := range symbols {
+		from := time.Now().UTC().AddDate(0, 0, -minDays)
+		if firstTrade.Before(from) {
+			from = firstTrade.AddDate(0, 0, -7)
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := ensurePriceHistory(reqCtx, dbPool, symbol, from)
+		cancel()
+		if err != nil {
+			failed++
+			log.Printf("Backfill %s failed: %v", symbol, err)
+			continue
+		}
+		log.Printf("Backfill %s ok (from %s)", symbol, from.Format(dateLayout))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d symbols failed", failed, len(symbols))
+	}
+	return nil
+}
+
+// --- ROUTES ---
+
+func registerHistoryRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/history/:symbol - Historical closes from price_history, topped up from upstream
+	api.GET("/history/:symbol", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		symbol := c.Param("symbol")
+		rng := c.DefaultQuery("range", "3mo") // Default to 3 months if not provided
+
+		from := historyEpoch
+		if days := rangeDays(rng); days != 0 {
+			from = time.Now().UTC().AddDate(0, 0, -days)
+		}
+
+		// Serve straight from upstream if the store can't be filled
+		if err := ensurePriceHistory(ctx, dbPool, symbol, from); err != nil {
+			log.Printf("History store miss for %s: %v", symbol, err)
+			series, err := market.History(ctx, symbol, rng)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chart data"})
+				return
+			}
+			c.JSON(http.StatusOK, chartResponse(series))
+			return
+		}
+
+		series, err := loadPriceHistory(ctx, dbPool, symbol, from)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load chart data"})
+			return
+		}
+		if len(series.Points) > maxChartPoints {
+			series.Points = thinToMonthEnds(series.Points)
+		}
+
+		// The live price badge needs an intraday quote; longer charts use the stored closes
+		if rng == "1d" {
+			if q, err := market.Quote(ctx, symbol); err == nil {
+				series.Quote = q
+				series.Currency = q.Currency
+			}
+		}
+		if series.Quote.Price == 0 && len(series.Points) > 0 {
+			series.Quote = Quote{Symbol: symbol, Price: series.Points[len(series.Points)-1].Close, PreviousClose: series.Points[0].Close, Currency: series.Currency, Source: "db"}
+		}
+		c.JSON(http.StatusOK, chartResponse(series))
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	priceUpdateWorkers   = 8
+	priceUpdateTimeout   = 10 * time.Second
+	priceUpdateBatchWait = 15 * time.Second
+)
+
+// Price update outcomes
+const (
+	PriceUpdated = "updated"
+	PriceFailed  = "failed"
+	PriceSkipped = "skipped"
+)
+
+// PriceUpdateResult is the outcome for one symbol.
+type PriceUpdateResult struct {
+	Symbol   string  `json:"symbol"`
+	Status   string  `json:"status"`
+	Reason   string  `json:"reason,omitempty"`
+	Price    float64 `json:"price,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+	Source   string  `json:"source,omitempty"`
+
+	quote Quote
+}
+
+// PriceUpdateReport summarises a full refresh run.
+type PriceUpdateReport struct {
+	StartedAt  time.Time           `json:"startedAt"`
+	FinishedAt time.Time           `json:"finishedAt"`
+	DurationMs int64               `json:"durationMs"`
+	Updated    int                 `json:"updated"`
+	Failed     int                 `json:"failed"`
+	Skipped    int                 `json:"skipped"`
+	Results    []PriceUpdateResult `json:"results"`
+}
+
+// priceUpdateTargets returns held symbols plus those only held with zero
+// quantity, which are reported as skipped rather than fetched. A nil include
+// selects every symbol.
+func priceUpdateTargets(ctx context.Context, dbPool *pgxpool.Pool, include func(string) bool) (active []string, closed []string, err error) {
+	rows, err := dbPool.Query(ctx, "SELECT name, MAX(quantity) FROM assets GROUP BY name ORDER BY name")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var maxQty float64
+		if err := rows.Scan(&name, &maxQty); err != nil {
+			return nil, nil, err
+		}
+		if include != nil && !include(name) {
+			continue
+		}
+		if maxQty > 0 {
+			active = append(active, name)
+		} else {
+			closed = append(closed, name)
+		}
+	}
+	return active, closed, rows.Err()
+}
+
+// fetchQuotes prices symbols using batch calls where a scheme supports them,
+// then a bounded worker pool over the fallback chains for the rest.
+func fetchQuotes(ctx context.Context, symbols []string) map[string]PriceUpdateResult {
+	results := make(map[string]PriceUpdateResult, len(symbols))
+	var mu sync.Mutex
+	set := func(r PriceUpdateResult) {
+		mu.Lock()
+		results[r.Symbol] = r
+		mu.Unlock()
+	}
+
+	// 1. Batched calls, grouped by scheme
+	byScheme := map[string][]string{}
+	for _, s := range symbols {
+		byScheme[symbolScheme(s)] = append(byScheme[symbolScheme(s)], s)
+	}
+	var remaining []string
+	for scheme, group := range byScheme {
+		if _, ok := market.batch[scheme]; !ok {
+			remaining = append(remaining, group...)
+			continue
+		}
+		for start := 0; start < len(group); start += yahooBatchSize {
+			chunk := group[start:min(start+yahooBatchSize, len(group))]
+			batchCtx, cancel := context.WithTimeout(ctx, priceUpdateBatchWait)
+			quotes, err := market.BatchQuotes(batchCtx, scheme, chunk)
+			cancel()
+			for _, s := range chunk {
+				if q, ok := quotes[s]; ok && err == nil {
+					set(PriceUpdateResult{Symbol: s, Status: PriceUpdated, Price: q.Price, Currency: q.Currency, Source: q.Source + "-batch", quote: q})
+				} else {
+					remaining = append(remaining, s)
+				}
+			}
+		}
+	}
+
+	// 2. Per-symbol fallback chains through a bounded pool
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < priceUpdateWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				qCtx, cancel := context.WithTimeout(ctx, priceUpdateTimeout)
+				q, err := market.Quote(qCtx, s)
+				cancel()
+				if err != nil {
+					set(PriceUpdateResult{Symbol: s, Status: PriceFailed, Reason: err.Error()})
+					continue
+				}
+				set(PriceUpdateResult{Symbol: s, Status: PriceUpdated, Price: q.Price, Currency: q.Currency, Source: q.Source, quote: q})
+			}
+		}()
+	}
+	for _, s := range remaining {
+		jobs <- s
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// writeQuotes applies all quotes to every user's holdings and records each
+// as the close of its own date, or else its exchange's current session, in
+// price_history, all in a single transaction. Cached last-known quotes update
+// holdings but are not recorded, since they are not that session's close. Where the prior session's
+// close is stored, it replaces the provider's previous close so daily change
+// spans exactly one session.
+func writeQuotes(ctx context.Context, dbPool *pgxpool.Pool, quotes []Quote, now time.Time) error {
+	if len(quotes) == 0 {
+		return nil
+	}
+	names := make([]string, len(quotes))
+	prices := make([]float64, len(quotes))
+	prevs := make([]float64, len(quotes))
+	currencies := make([]string, len(quotes))
+	sources := make([]string, len(quotes))
+	days := make([]string, len(quotes))
+	for i, q := range quotes {
+		names[i], prices[i], prevs[i], currencies[i], sources[i] = q.Symbol, q.Price, q.PreviousClose, normalizeCurrency(q.Currency), q.Source
+		y, m, d := now.UTC().Date()
+		session := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		if e := exchangeForSymbol(q.Symbol); e != nil {
+			session = e.SessionDate(now)
+		}
+		if t, err := time.Parse(dateLayout, q.Date); err == nil {
+			session = t
+		}
+		days[i] = session.Format(dateLayout)
+		if prev, ok := previousCloseFor(ctx, dbPool, q.Symbol, session); ok {
+			prevs[i] = prev
+		}
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE assets a SET current_price=u.price, previous_close=u.prev, currency=u.currency
+		FROM unnest($1::text[], $2::float8[], $3::float8[], $4::text[]) AS u(name, price, prev, currency)
+		WHERE a.name = u.name`, names, prices, prevs, currencies)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO price_history (symbol, date, close, currency, source)
+		SELECT name, day::date, price, currency, source
+		FROM unnest($1::text[], $2::float8[], $3::text[], $4::text[], $5::text[]) AS u(name, price, currency, source, day)
+		WHERE source <> $6
+		ON CONFLICT (symbol, date) DO UPDATE SET close=EXCLUDED.close, currency=EXCLUDED.currency, source=EXCLUDED.source`,
+		names, prices, currencies, sources, days, lastKnownSource)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// priceUpdateMu prevents overlapping refresh runs within this process.
+var priceUpdateMu sync.Mutex
+
+// runPriceUpdate refreshes held symbols (all, or those include accepts) and
+// reports per-symbol outcomes.
+func runPriceUpdate(ctx context.Context, dbPool *pgxpool.Pool, include func(string) bool) (PriceUpdateReport, error) {
+	priceUpdateMu.Lock()
+	defer priceUpdateMu.Unlock()
+
+	report := PriceUpdateReport{StartedAt: time.Now()}
+	active, closed, err := priceUpdateTargets(ctx, dbPool, include)
+	if err != nil {
+		return report, err
+	}
+
+	results := fetchQuotes(ctx, active)
+	for _, s := range closed {
+		results[s] = PriceUpdateResult{Symbol: s, Status: PriceSkipped, Reason: "no open positions"}
+	}
+
+	var quotes []Quote
+	for _, r := range results {
+		if r.Status == PriceUpdated {
+			quotes = append(quotes, r.quote)
+		}
+	}
+	if err := writeQuotes(ctx, dbPool, quotes, time.Now()); err != nil {
+		return report, err
+	}
+
+	for _, r := range results {
+		switch r.Status {
+		case PriceUpdated:
+			report.Updated++
+		case PriceFailed:
+			report.Failed++
+		case PriceSkipped:
+			report.Skipped++
+		}
+		report.Results = append(report.Results, r)
+	}
+	sort.Slice(report.Results, func(i, j int) bool { return report.Results[i].Symbol < report.Results[j].Symbol })
+
+	report.FinishedAt = time.Now()
+	report.DurationMs = report.FinishedAt.Sub(report.StartedAt).Milliseconds()
+	return report, nil
+}
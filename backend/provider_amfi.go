@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AMFIProvider serves Indian mutual fund NAVs from mfapi.in for symbols of
+// the form "AMFI:<schemeCode>".
+type AMFIProvider struct{}
+
+// AMFI specific structs for Indian Mutual Funds
+type MFAPISearchResult struct {
+	SchemeCode int    `json:"schemeCode"`
+	SchemeName string `json:"schemeName"`
+}
+
+type MFAPIDetailResult struct {
+	Meta struct {
+		SchemeName     string `json:"scheme_name"`
+		FundHouse      string `json:"fund_house"`
+		SchemeType     string `json:"scheme_type"`
+		SchemeCategory string `json:"scheme_category"`
+	} `json:"meta"`
+	Data []struct {
+		Date string `json:"date"`
+		Nav  string `json:"nav"`
+	} `json:"data"`
+}
+
+func (a *AMFIProvider) Name() string { return "amfi" }
+
+func (a *AMFIProvider) scheme(ctx context.Context, symbol string) (MFAPIDetailResult, error) {
+	schemeCode := strings.TrimPrefix(symbol, "AMFI:")
+	var data MFAPIDetailResult
+	if err := getJSON(ctx, fmt.Sprintf("https://api.mfapi.in/mf/%s", url.PathEscape(schemeCode)), nil, &data); err != nil {
+		return data, err
+	}
+	if len(data.Data) < 1 {
+		return data, lookupErrorf("no amfi data found")
+	}
+	return data, nil
+}
+
+func (a *AMFIProvider) Quote(ctx context.Context, symbol string) (Quote, error) {
+	data, err := a.scheme(ctx, symbol)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	currentPrice, _ := strconv.ParseFloat(data.Data[0].Nav, 64)
+	prevClose := currentPrice
+
+	// If there is data for yesterday, use it for the daily change calculation
+	if len(data.Data) > 1 {
+		prevClose, _ = strconv.ParseFloat(data.Data[1].Nav, 64)
+	}
+
+	// AMFI prices are strictly in INR. The NAV is dated, so it is stored
+	// against its own day rather than whenever it was fetched.
+	q := Quote{Symbol: symbol, Price: currentPrice, PreviousClose: prevClose, Currency: "INR", Source: a.Name()}
+	if d, err := time.Parse("02-01-2006", data.Data[0].Date); err == nil {
+		q.Date = d.Format(dateLayout)
+	}
+	return q, nil
+}
+
+func (a *AMFIProvider) History(ctx context.Context, symbol, rng string) (PriceSeries, error) {
+	var cutoffTime time.Time
+	if days := rangeDays(rng); days != 0 {
+		cutoffTime = time.Now().AddDate(0, 0, -days)
+	}
+	return a.DailyHistory(ctx, symbol, cutoffTime)
+}
+
+// DailyHistory returns every NAV after from. mfapi.in has no date filter, so
+// the full scheme history is always downloaded and trimmed here.
+func (a *AMFIProvider) DailyHistory(ctx context.Context, symbol string, from time.Time) (PriceSeries, error) {
+	data, err := a.scheme(ctx, symbol)
+	if err != nil {
+		return PriceSeries{}, err
+	}
+
+	series := PriceSeries{Symbol: symbol, Currency: "INR"}
+
+	// AMFI provides data newest first, but charts need oldest first.
+	// We iterate backwards through the AMFI slice.
+	for i := len(data.Data) - 1; i >= 0; i-- {
+		// Parse date from DD-MM-YYYY
+		parsedDate, err := time.Parse("02-01-2006", data.Data[i].Date)
+		if err != nil || !parsedDate.After(from) {
+			continue
+		}
+		nav, err := strconv.ParseFloat(data.Data[i].Nav, 64)
+		if err == nil {
+			series.Points = append(series.Points, PricePoint{Time: parsedDate, Close: nav})
+		}
+	}
+
+	if n := len(series.Points); n > 0 {
+		series.Quote = Quote{Symbol: symbol, Price: series.Points[n-1].Close, PreviousClose: series.Points[0].Close, Currency: "INR", Source: a.Name()}
+	}
+	return series, nil
+}
+
+// Profile classifies a scheme from its SEBI category, e.g. "Equity Scheme -
+// Large Cap Fund". mfapi.in does not publish expense ratios.
+func (a *AMFIProvider) Profile(ctx context.Context, symbol string) (InstrumentProfile, error) {
+	data, err := a.scheme(ctx, symbol)
+	if err != nil {
+		return InstrumentProfile{}, err
+	}
+
+	category := data.Meta.SchemeCategory
+	p := InstrumentProfile{
+		Symbol:       symbol,
+		Name:         data.Meta.SchemeName,
+		QuoteType:    "MUTUALFUND",
+		Country:      "India",
+		FundCategory: category,
+		Source:       a.Name(),
+	}
+	// The asset class is the part before " Scheme", used as the sector
+	if class, _, ok := strings.Cut(category, " Scheme"); ok {
+		p.Sector = class
+	}
+	lower := strings.ToLower(category)
+	switch {
+	case strings.Contains(lower, "large & mid cap"):
+		p.MarketCapBucket = "Multi Cap"
+	case strings.Contains(lower, "large cap"):
+		p.MarketCapBucket = "Large Cap"
+	case strings.Contains(lower, "mid cap"):
+		p.MarketCapBucket = "Mid Cap"
+	case strings.Contains(lower, "small cap"):
+		p.MarketCapBucket = "Small Cap"
+	case strings.Contains(lower, "multi cap"), strings.Contains(lower, "flexi cap"):
+		p.MarketCapBucket = "Multi Cap"
+	}
+	return p, nil
+}
+
+func (a *AMFIProvider) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	var mfData []MFAPISearchResult
+	if err := getJSON(ctx, fmt.Sprintf("https://api.mfapi.in/mf/search?q=%s", url.QueryEscape(query)), nil, &mfData); err != nil {
+		return nil, err
+	}
+
+	if len(mfData) > limit {
+		mfData = mfData[:limit]
+	}
+	results := make([]SearchResult, 0, len(mfData))
+	for _, mf := range mfData {
+		results = append(results, SearchResult{
+			Symbol:    fmt.Sprintf("AMFI:%d", mf.SchemeCode),
+			ShortName: mf.SchemeName,
+			Exchange:  "AMFI India",
+			QuoteType: "MUTUALFUND",
+		})
+	}
+	return results, nil
+}
+
+// AMFIScheme is one row of the AMFI scheme master. A scheme has up to two
+// ISINs: growth/payout and dividend reinvestment.
+type AMFIScheme struct {
+	Code         int
+	Name         string
+	ISINGrowth   string
+	ISINReinvest string
+}
+
+// amfiNAVAllURL is the daily NAV file, which doubles as the scheme master.
+const amfiNAVAllURL = "https://www.amfiindia.com/spages/NAVAll.txt"
+
+// SchemeMaster downloads every open scheme with its ISINs. The file is
+// semicolon separated under fund-house headings:
+// "Scheme Code;ISIN Div Payout/ ISIN Growth;ISIN Div Reinvestment;Scheme Name;Net Asset Value;Date".
+func (a *AMFIProvider) SchemeMaster(ctx context.Context) ([]AMFIScheme, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", amfiNAVAllURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status %d from %s", resp.StatusCode, req.URL.Host)
+	}
+
+	var schemes []AMFIScheme
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ";")
+		if len(fields) < 4 {
+			continue
+		}
+		code, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil {
+			continue // heading or column names
+		}
+		s := AMFIScheme{Code: code, Name: strings.TrimSpace(fields[3])}
+		if isin := strings.TrimSpace(fields[1]); len(isin) == 12 {
+			s.ISINGrowth = isin
+		}
+		if isin := strings.TrimSpace(fields[2]); len(isin) == 12 {
+			s.ISINReinvest = isin
+		}
+		schemes = append(schemes, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(schemes) == 0 {
+		return nil, fmt.Errorf("empty amfi scheme master")
+	}
+	return schemes, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LastKnownProvider serves the most recent quote any provider returned,
+// persisted in last_quotes, or failing that the price stored on a holding.
+// It is meant to sit at the end of a fallback chain.
+type LastKnownProvider struct {
+	dbPool *pgxpool.Pool
+}
+
+// lastKnownSource is the Source of quotes served from the cache.
+const lastKnownSource = "lastknown"
+
+func (l *LastKnownProvider) Name() string { return lastKnownSource }
+
+func (l *LastKnownProvider) Quote(ctx context.Context, symbol string) (Quote, error) {
+	q := Quote{Symbol: symbol, Source: l.Name()}
+	err := l.dbPool.QueryRow(ctx, `
+		SELECT price, previous_close, currency FROM last_quotes WHERE symbol=$1
+		UNION ALL
+		SELECT current_price, previous_close, currency FROM assets WHERE name=$1 AND current_price > 0
+		LIMIT 1`, symbol).Scan(&q.Price, &q.PreviousClose, &q.Currency)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Quote{}, lookupErrorf("no stored quote for %s", symbol)
+	}
+	if err != nil {
+		return Quote{}, fmt.Errorf("last known quote for %s: %w", symbol, err)
+	}
+	return q, nil
+}
+
+// Remember upserts a live quote. Failures are ignored since the cache is best effort.
+func (l *LastKnownProvider) Remember(ctx context.Context, q Quote) {
+	l.dbPool.Exec(ctx, `
+		INSERT INTO last_quotes (symbol, price, previous_close, currency, source, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (symbol) DO UPDATE SET price=EXCLUDED.price, previous_close=EXCLUDED.previous_close,
+			currency=EXCLUDED.currency, source=EXCLUDED.source, updated_at=NOW()`,
+		q.Symbol, q.Price, q.PreviousClose, q.Currency, q.Source)
+}
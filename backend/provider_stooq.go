@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StooqProvider reads daily closes from stooq.com's CSV download. It is a
+// fallback for US/European equities, major indices and FX pairs; Indian and
+// Singapore listings are not covered.
+type StooqProvider struct{}
+
+var stooqSuffixes = map[string]struct{ suffix, currency string }{
+	"":    {".us", "USD"},
+	".L":  {".uk", "GBp"},
+	".DE": {".de", "EUR"},
+	".F":  {".de", "EUR"},
+	".T":  {".jp", "JPY"},
+	".HK": {".hk", "HKD"},
+}
+
+// stooqIndices maps Yahoo index symbols to Stooq's, with the currency of the
+// index's home market.
+var stooqIndices = map[string]struct{ ticker, currency string }{
+	"^GSPC": {"^spx", "USD"},
+	"^DJI":  {"^dji", "USD"},
+	"^IXIC": {"^ndq", "USD"},
+	"^FTSE": {"^ukx", "GBP"},
+	"^N225": {"^nkx", "JPY"},
+}
+
+func (s *StooqProvider) Name() string { return "stooq" }
+
+// stooqSymbol maps a Yahoo-style symbol to Stooq's ticker and quote currency.
+func stooqSymbol(symbol string) (string, string, error) {
+	if isFXSymbol(symbol) {
+		pair := strings.TrimSuffix(symbol, "=X")
+		if len(pair) == 3 {
+			pair = "USD" + pair
+		}
+		if len(pair) != 6 {
+			return "", "", lookupErrorf("unsupported fx pair %s", symbol)
+		}
+		return strings.ToLower(pair), strings.ToUpper(pair[3:]), nil
+	}
+	if idx, ok := stooqIndices[symbol]; ok {
+		return idx.ticker, idx.currency, nil
+	}
+
+	base, suffix := symbol, ""
+	if i := strings.LastIndex(symbol, "."); i > 0 {
+		base, suffix = symbol[:i], symbol[i:]
+	}
+	m, ok := stooqSuffixes[strings.ToUpper(suffix)]
+	if !ok {
+		return "", "", lookupErrorf("stooq does not cover %s", symbol)
+	}
+	return strings.ToLower(base) + m.suffix, m.currency, nil
+}
+
+func (s *StooqProvider) Quote(ctx context.Context, symbol string) (Quote, error) {
+	ticker, currency, err := stooqSymbol(symbol)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	// Ask for the last two weeks so weekends and holidays still leave two closes
+	now := time.Now()
+	urlStr := fmt.Sprintf("https://stooq.com/q/d/l/?s=%s&i=d&d1=%s&d2=%s",
+		url.QueryEscape(ticker), now.AddDate(0, 0, -14).Format("20060102"), now.Format("20060102"))
+	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	if err != nil {
+		return Quote{}, err
+	}
+	resp, err := marketHTTPClient.Do(req)
+	if err != nil {
+		return Quote{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Quote{}, fmt.Errorf("bad status %d from stooq", resp.StatusCode)
+	}
+
+	// Columns: Date,Open,High,Low,Close[,Volume]
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		return Quote{}, err
+	}
+	var closes []float64
+	for _, rec := range records[min(1, len(records)):] {
+		if len(rec) < 5 {
+			continue
+		}
+		if c, err := strconv.ParseFloat(rec[4], 64); err == nil {
+			closes = append(closes, c)
+		}
+	}
+	if len(closes) == 0 {
+		return Quote{}, lookupErrorf("no stooq data for %s", symbol)
+	}
+
+	q := Quote{Symbol: symbol, Price: closes[len(closes)-1], PreviousClose: closes[len(closes)-1], Currency: currency, Source: s.Name()}
+	if len(closes) > 1 {
+		q.PreviousClose = closes[len(closes)-2]
+	}
+	return q, nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// YahooProvider serves quotes, history and search from Yahoo Finance's
+// unofficial chart and search endpoints. quoteSummary additionally needs a
+// session cookie and matching crumb, fetched once and kept until rejected.
+type YahooProvider struct {
+	mu     sync.Mutex
+	cookie string
+	crumb  string
+}
+
+type YahooResponse struct {
+	Chart struct {
+		Result []struct {
+			Meta struct {
+				Currency           string  `json:"currency"`
+				RegularMarketPrice float64 `json:"regularMarketPrice"`
+				ChartPreviousClose float64 `json:"chartPreviousClose"`
+			} `json:"meta"`
+			Timestamp []int64 `json:"timestamp"`
+			Events    struct {
+				Dividends map[string]struct {
+					Amount float64 `json:"amount"`
+					Date   int64   `json:"date"`
+				} `json:"dividends"`
+				Splits map[string]struct {
+					Date        int64   `json:"date"`
+					Numerator   float64 `json:"numerator"`
+					Denominator float64 `json:"denominator"`
+					SplitRatio  string  `json:"splitRatio"`
+				} `json:"splits"`
+			} `json:"events"`
+			Indicators struct {
+				Quote []struct {
+					Close []*float64 `json:"close"`
+				} `json:"quote"`
+			} `json:"indicators"`
+		} `json:"result"`
+	} `json:"chart"`
+}
+
+// YahooSparkResponse is the v7 spark endpoint's reply: one chart-style
+// response per symbol. (v8 spark instead returns a bare map of symbol to
+// closes, without the meta block that carries the currency.)
+type YahooSparkResponse struct {
+	Spark struct {
+		Result []struct {
+			Symbol   string `json:"symbol"`
+			Response []struct {
+				Meta struct {
+					Currency           string  `json:"currency"`
+					RegularMarketPrice float64 `json:"regularMarketPrice"`
+					ChartPreviousClose float64 `json:"chartPreviousClose"`
+				} `json:"meta"`
+			} `json:"response"`
+		} `json:"result"`
+	} `json:"spark"`
+}
+
+// yahooValue is Yahoo's {"raw": 1.23, "fmt": "1.23"} number wrapper.
+type yahooValue struct {
+	Raw *float64 `json:"raw"`
+}
+
+type YahooQuoteSummaryResponse struct {
+	QuoteSummary struct {
+		Result []struct {
+			AssetProfile struct {
+				Sector   string `json:"sector"`
+				Industry string `json:"industry"`
+				Country  string `json:"country"`
+			} `json:"assetProfile"`
+			Price struct {
+				LongName  string     `json:"longName"`
+				QuoteType string     `json:"quoteType"`
+				Currency  string     `json:"currency"`
+				MarketCap yahooValue `json:"marketCap"`
+			} `json:"price"`
+			FundProfile struct {
+				CategoryName           string `json:"categoryName"`
+				FeesExpensesInvestment struct {
+					AnnualReportExpenseRatio yahooValue `json:"annualReportExpenseRatio"`
+				} `json:"feesExpensesInvestment"`
+			} `json:"fundProfile"`
+		} `json:"result"`
+	} `json:"quoteSummary"`
+}
+
+type YahooSearchResponse struct {
+	Quotes []SearchResult `json:"quotes"`
+}
+
+// yahooBatchSize is the most symbols the spark endpoint accepts at once.
+const yahooBatchSize = 20
+
+// We use a User-Agent to prevent Yahoo from blocking us as a bot
+var yahooHeaders = map[string]string{"User-Agent": "Mozilla/5.0"}
+
+func (y *YahooProvider) Name() string { return "yahoo" }
+
+// chart calls the v8 chart endpoint with the given query parameters
+// (interval plus either range or period1/period2).
+func (y *YahooProvider) chart(ctx context.Context, symbol string, params url.Values) (YahooResponse, error) {
+	urlStr := fmt.Sprintf("https://query1.finance.yahoo.com/v8/finance/chart/%s?%s", url.PathEscape(symbol), params.Encode())
+
+	var data YahooResponse
+	if err := getJSON(ctx, urlStr, yahooHeaders, &data); err != nil {
+		return data, err
+	}
+	if len(data.Chart.Result) == 0 {
+		return data, lookupErrorf("no data found for symbol")
+	}
+	return data, nil
+}
+
+func (y *YahooProvider) Quote(ctx context.Context, symbol string) (Quote, error) {
+	data, err := y.chart(ctx, symbol, url.Values{"interval": {"1d"}})
+	if err != nil {
+		return Quote{}, err
+	}
+	meta := data.Chart.Result[0].Meta
+	return Quote{Symbol: symbol, Price: meta.RegularMarketPrice, PreviousClose: meta.ChartPreviousClose, Currency: meta.Currency, Source: y.Name()}, nil
+}
+
+// Quotes prices up to yahooBatchSize symbols with one call to the v7 spark
+// endpoint, which returns the same meta block as the chart endpoint and,
+// unlike v7 quote, needs no cookie or crumb.
+func (y *YahooProvider) Quotes(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	params := url.Values{"symbols": {strings.Join(symbols, ",")}, "range": {"1d"}, "interval": {"1d"}}
+	var data YahooSparkResponse
+	if err := getJSON(ctx, "https://query1.finance.yahoo.com/v7/finance/spark?"+params.Encode(), yahooHeaders, &data); err != nil {
+		return nil, err
+	}
+	return data.quotes(y.Name()), nil
+}
+
+// quotes returns a quote for each symbol the response priced, skipping
+// symbols Yahoo did not recognise.
+func (data YahooSparkResponse) quotes(source string) map[string]Quote {
+	quotes := make(map[string]Quote, len(data.Spark.Result))
+	for _, r := range data.Spark.Result {
+		if len(r.Response) == 0 || r.Response[0].Meta.RegularMarketPrice <= 0 {
+			continue
+		}
+		meta := r.Response[0].Meta
+		quotes[r.Symbol] = Quote{Symbol: r.Symbol, Price: meta.RegularMarketPrice, PreviousClose: meta.ChartPreviousClose, Currency: meta.Currency, Source: source}
+	}
+	return quotes
+}
+
+func (y *YahooProvider) History(ctx context.Context, symbol, rng string) (PriceSeries, error) {
+	interval := "1d"
+	if rng == "max" {
+		interval = "1mo"
+	} else if rng == "1y" {
+		interval = "1wk"
+	}
+
+	data, err := y.chart(ctx, symbol, url.Values{"interval": {interval}, "range": {rng}})
+	if err != nil {
+		return PriceSeries{}, err
+	}
+	return y.toSeries(symbol, data), nil
+}
+
+func (y *YahooProvider) DailyHistory(ctx context.Context, symbol string, from time.Time) (PriceSeries, error) {
+	params := url.Values{
+		"interval": {"1d"},
+		"period1":  {strconv.FormatInt(from.Unix(), 10)},
+		"period2":  {strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	data, err := y.chart(ctx, symbol, params)
+	if err != nil {
+		return PriceSeries{}, err
+	}
+	return y.toSeries(symbol, data), nil
+}
+
+// Dividends lists cash distributions with an ex-date after from, from the
+// chart endpoint's div events. Amounts are per share in the quote currency.
+func (y *YahooProvider) Dividends(ctx context.Context, symbol string, from time.Time) ([]DividendEvent, error) {
+	params := url.Values{
+		"interval": {"1d"},
+		"events":   {"div"},
+		"period1":  {strconv.FormatInt(from.Unix(), 10)},
+		"period2":  {strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	data, err := y.chart(ctx, symbol, params)
+	if err != nil {
+		return nil, err
+	}
+	res := data.Chart.Result[0]
+	events := make([]DividendEvent, 0, len(res.Events.Dividends))
+	for _, d := range res.Events.Dividends {
+		if d.Amount <= 0 {
+			continue
+		}
+		events = append(events, DividendEvent{
+			Symbol:   symbol,
+			ExDate:   time.Unix(d.Date, 0).UTC().Format(dateLayout),
+			Amount:   d.Amount,
+			Currency: res.Meta.Currency,
+			Source:   y.Name(),
+		})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ExDate < events[j].ExDate })
+	return events, nil
+}
+
+// CorporateActions lists splits (including reverse splits and bonus issues,
+// which Yahoo reports the same way) with an ex-date after from.
+func (y *YahooProvider) CorporateActions(ctx context.Context, symbol string, from time.Time) ([]CorporateAction, error) {
+	params := url.Values{
+		"interval": {"1d"},
+		"events":   {"split"},
+		"period1":  {strconv.FormatInt(from.Unix(), 10)},
+		"period2":  {strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	data, err := y.chart(ctx, symbol, params)
+	if err != nil {
+		return nil, err
+	}
+	var actions []CorporateAction
+	for _, s := range data.Chart.Result[0].Events.Splits {
+		if s.Numerator <= 0 || s.Denominator <= 0 {
+			continue
+		}
+		actions = append(actions, CorporateAction{
+			Symbol: symbol,
+			Type:   ActionSplit,
+			ExDate: time.Unix(s.Date, 0).UTC().Format(dateLayout),
+			Ratio:  s.Numerator / s.Denominator,
+			Source: y.Name(),
+			Notes:  s.SplitRatio,
+		})
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].ExDate < actions[j].ExDate })
+	return actions, nil
+}
+
+func (y *YahooProvider) toSeries(symbol string, data YahooResponse) PriceSeries {
+	res := data.Chart.Result[0]
+	series := PriceSeries{
+		Symbol:   symbol,
+		Currency: res.Meta.Currency,
+		Quote:    Quote{Symbol: symbol, Price: res.Meta.RegularMarketPrice, PreviousClose: res.Meta.ChartPreviousClose, Currency: res.Meta.Currency, Source: y.Name()},
+	}
+	if len(res.Indicators.Quote) == 0 {
+		return series
+	}
+	closes := res.Indicators.Quote[0].Close
+	for i, ts := range res.Timestamp {
+		// Yahoo pads non-trading intervals with nulls
+		if i >= len(closes) || closes[i] == nil {
+			continue
+		}
+		series.Points = append(series.Points, PricePoint{Time: time.Unix(ts, 0).UTC(), Close: *closes[i]})
+	}
+	return series
+}
+
+// session returns headers carrying Yahoo's session cookie and the crumb
+// that must accompany them. fc.yahoo.com sets the cookie (while answering
+// 404); getcrumb then issues the crumb for it.
+func (y *YahooProvider) session(ctx context.Context) (map[string]string, string, error) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+
+	if y.crumb == "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", "https://fc.yahoo.com", nil)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Header.Set("User-Agent", yahooHeaders["User-Agent"])
+		resp, err := marketHTTPClient.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		resp.Body.Close()
+		var parts []string
+		for _, c := range resp.Cookies() {
+			parts = append(parts, c.Name+"="+c.Value)
+		}
+		if len(parts) == 0 {
+			return nil, "", fmt.Errorf("no yahoo session cookie")
+		}
+		cookie := strings.Join(parts, "; ")
+
+		req, err = http.NewRequestWithContext(ctx, "GET", "https://query2.finance.yahoo.com/v1/test/getcrumb", nil)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Header.Set("User-Agent", yahooHeaders["User-Agent"])
+		req.Header.Set("Cookie", cookie)
+		resp, err = marketHTTPClient.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		crumb := strings.TrimSpace(string(body))
+		if err != nil || resp.StatusCode != http.StatusOK || crumb == "" {
+			return nil, "", fmt.Errorf("no yahoo crumb (status %d)", resp.StatusCode)
+		}
+		y.cookie, y.crumb = cookie, crumb
+	}
+	return map[string]string{"User-Agent": yahooHeaders["User-Agent"], "Cookie": y.cookie}, y.crumb, nil
+}
+
+// dropSession forgets a cookie and crumb Yahoo no longer accepts.
+func (y *YahooProvider) dropSession() {
+	y.mu.Lock()
+	y.cookie, y.crumb = "", ""
+	y.mu.Unlock()
+}
+
+// Profile reads sector, country and market cap (stocks) or category and
+// expense ratio (funds and ETFs) from the quoteSummary modules.
+func (y *YahooProvider) Profile(ctx context.Context, symbol string) (InstrumentProfile, error) {
+	var data YahooQuoteSummaryResponse
+	for attempt := 0; ; attempt++ {
+		headers, crumb, err := y.session(ctx)
+		if err != nil {
+			return InstrumentProfile{}, err
+		}
+		urlStr := fmt.Sprintf("https://query2.finance.yahoo.com/v10/finance/quoteSummary/%s?modules=assetProfile,price,fundProfile&crumb=%s",
+			url.PathEscape(symbol), url.QueryEscape(crumb))
+		err = getJSON(ctx, urlStr, headers, &data)
+		var status *httpStatusError
+		if attempt == 0 && errors.As(err, &status) && (status.status == http.StatusUnauthorized || status.status == http.StatusForbidden) {
+			// Expired session; start a new one once
+			y.dropSession()
+			continue
+		}
+		if err != nil {
+			return InstrumentProfile{}, err
+		}
+		break
+	}
+	if len(data.QuoteSummary.Result) == 0 {
+		return InstrumentProfile{}, lookupErrorf("no profile found for %s", symbol)
+	}
+
+	r := data.QuoteSummary.Result[0]
+	p := InstrumentProfile{
+		Symbol:            symbol,
+		Name:              r.Price.LongName,
+		QuoteType:         r.Price.QuoteType,
+		Sector:            r.AssetProfile.Sector,
+		Industry:          r.AssetProfile.Industry,
+		Country:           r.AssetProfile.Country,
+		MarketCapCurrency: r.Price.Currency,
+		FundCategory:      r.FundProfile.CategoryName,
+		ExpenseRatio:      r.FundProfile.FeesExpensesInvestment.AnnualReportExpenseRatio.Raw,
+		Source:            y.Name(),
+	}
+	if r.Price.MarketCap.Raw != nil {
+		p.MarketCap = *r.Price.MarketCap.Raw
+	}
+	return p, nil
+}
+
+func (y *YahooProvider) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	urlStr := fmt.Sprintf("https://query2.finance.yahoo.com/v1/finance/search?q=%s&quotesCount=%d&newsCount=0", url.QueryEscape(query), limit)
+	var data YahooSearchResponse
+	if err := getJSON(ctx, urlStr, yahooHeaders, &data); err != nil {
+		return nil, err
+	}
+	return data.Quotes, nil
+}
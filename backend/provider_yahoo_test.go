@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// sparkFixture is a v7 spark reply for two listed symbols and one Yahoo does
+// not recognise, trimmed of meta fields the provider ignores.
+const sparkFixture = `{"spark":{"result":[
+{"symbol":"AAPL","response":[{"meta":{"currency":"USD","symbol":"AAPL","exchangeName":"NMS","instrumentType":"EQUITY","regularMarketTime":1718395201,"gmtoffset":-14400,"timezone":"EDT","regularMarketPrice":212.49,"chartPreviousClose":214.24,"previousClose":214.24,"scale":3,"priceHint":2,"dataGranularity":"1d","range":"1d"},"timestamp":[1718395201],"indicators":{"quote":[{"close":[212.49]}]}}]},
+{"symbol":"INFY.NS","response":[{"meta":{"currency":"INR","symbol":"INFY.NS","exchangeName":"NSI","instrumentType":"EQUITY","regularMarketTime":1718359199,"gmtoffset":19800,"timezone":"IST","regularMarketPrice":1490.95,"chartPreviousClose":1478.4,"previousClose":1478.4,"scale":3,"priceHint":2,"dataGranularity":"1d","range":"1d"},"timestamp":[1718359199],"indicators":{"quote":[{"close":[1490.95]}]}}]},
+{"symbol":"NOSUCH","response":[]}
+],"error":null}}`
+
+func TestYahooSparkQuotes(t *testing.T) {
+	var data YahooSparkResponse
+	if err := json.Unmarshal([]byte(sparkFixture), &data); err != nil {
+		t.Fatalf("decoding spark fixture: %v", err)
+	}
+	want := map[string]Quote{
+		"AAPL":    {Symbol: "AAPL", Price: 212.49, PreviousClose: 214.24, Currency: "USD", Source: "yahoo"},
+		"INFY.NS": {Symbol: "INFY.NS", Price: 1490.95, PreviousClose: 1478.4, Currency: "INR", Source: "yahoo"},
+	}
+	if got := data.quotes("yahoo"); !reflect.DeepEqual(got, want) {
+		t.Errorf("quotes() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Target kinds: what a target's key is matched against
+const (
+	TargetAsset = "asset" // assets.name
+	TargetType  = "type"  // assets.asset_type
+	TargetTag   = "tag"   // assets.tag
+)
+
+const defaultTolerance = 5.0 // percentage points
+
+// AllocationTarget is one target weight with its tolerance band, both in
+// percent of the targeted portfolio.
+type AllocationTarget struct {
+	Key       string  `json:"key"`
+	Weight    float64 `json:"weight"`
+	Tolerance float64 `json:"tolerance"`
+}
+
+// TargetSet is a user's full target mix. All targets share one kind so the
+// groups they describe never overlap.
+type TargetSet struct {
+	Kind    string             `json:"kind"`
+	Targets []AllocationTarget `json:"targets"`
+}
+
+func validateTargetSet(ts *TargetSet) error {
+	ts.Kind = strings.ToLower(strings.TrimSpace(ts.Kind))
+	if ts.Kind != TargetAsset && ts.Kind != TargetType && ts.Kind != TargetTag {
+		return fmt.Errorf("kind must be one of asset, type, tag")
+	}
+	sum := 0.0
+	seen := map[string]bool{}
+	for i := range ts.Targets {
+		t := &ts.Targets[i]
+		t.Key = strings.TrimSpace(t.Key)
+		if t.Key == "" {
+			return fmt.Errorf("every target needs a key")
+		}
+		if seen[strings.ToLower(t.Key)] {
+			return fmt.Errorf("duplicate target %q", t.Key)
+		}
+		seen[strings.ToLower(t.Key)] = true
+		if t.Weight < 0 || t.Weight > 100 || t.Tolerance < 0 {
+			return fmt.Errorf("weights must be between 0 and 100 and tolerances non-negative")
+		}
+		if t.Tolerance == 0 {
+			t.Tolerance = defaultTolerance
+		}
+		sum += t.Weight
+	}
+	if len(ts.Targets) > 0 && math.Abs(sum-100) > 0.01 {
+		return fmt.Errorf("target weights must add up to 100 (got %.2f)", sum)
+	}
+	return nil
+}
+
+func loadTargetSet(ctx context.Context, q dbQuerier, userID int) (TargetSet, error) {
+	ts := TargetSet{Kind: TargetType, Targets: []AllocationTarget{}}
+	rows, err := q.Query(ctx, "SELECT kind, key, weight, tolerance FROM allocation_targets WHERE user_id=$1 ORDER BY weight DESC, key", userID)
+	if err != nil {
+		return ts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t AllocationTarget
+		if err := rows.Scan(&ts.Kind, &t.Key, &t.Weight, &t.Tolerance); err != nil {
+			return ts, err
+		}
+		ts.Targets = append(ts.Targets, t)
+	}
+	return ts, rows.Err()
+}
+
+// rebalanceHolding is a position the rebalancer can trade.
+type rebalanceHolding struct {
+	symbol     string
+	group      string
+	quantity   float64
+	price      float64 // native currency
+	rate       float64 // native -> reporting currency
+	value      float64 // reporting currency
+	fractional bool
+}
+
+// fractionalUnits reports whether a symbol trades in fractional units:
+// mutual fund units and crypto do, exchange-listed shares do not.
+func fractionalUnits(symbol, assetType string) bool {
+	if symbolScheme(symbol) == "AMFI" || strings.Contains(strings.ToLower(assetType), "mutual fund") {
+		return true
+	}
+	e := exchangeForSymbol(symbol)
+	return e != nil && e.Code == "CRYPTO"
+}
+
+// GroupDrift is one target group's position against its band.
+type GroupDrift struct {
+	Key           string  `json:"key"`
+	Target        float64 `json:"target"`
+	Tolerance     float64 `json:"tolerance"`
+	Value         float64 `json:"value"`
+	CurrentWeight float64 `json:"currentWeight"`
+	Drift         float64 `json:"drift"`
+	InBand        bool    `json:"inBand"`
+	PostWeight    float64 `json:"postWeight"`
+}
+
+// RebalanceTrade is one suggested order.
+type RebalanceTrade struct {
+	Symbol   string  `json:"symbol"`
+	Group    string  `json:"group"`
+	Action   string  `json:"action"` // buy or sell
+	Units    float64 `json:"units"`
+	Price    float64 `json:"price"`
+	Amount   float64 `json:"amount"` // reporting currency
+	Currency string  `json:"currency"`
+}
+
+// planRebalance computes value changes per group. Groups outside their band
+// move back to target; the resulting cash imbalance (less any new cash) is
+// met from in-band groups, drawing on those furthest on the right side of
+// target first. In cash-only mode nothing is sold and new cash goes to the
+// most underweight groups.
+func planRebalance(targets []AllocationTarget, values map[string]float64, cash float64, cashOnly bool) map[string]float64 {
+	total := cash
+	for _, t := range targets {
+		total += values[t.Key]
+	}
+	deltas := map[string]float64{}
+	if total <= 0 {
+		return deltas
+	}
+
+	if cashOnly {
+		shortfall := map[string]float64{}
+		sum := 0.0
+		for _, t := range targets {
+			if gap := t.Weight/100*total - values[t.Key]; gap > 0 {
+				shortfall[t.Key] = gap
+				sum += gap
+			}
+		}
+		for key, gap := range shortfall {
+			deltas[key] = gap * math.Min(1, cash/sum)
+		}
+		// Cash beyond every shortfall is spread by target weight
+		if cash > sum {
+			for _, t := range targets {
+				deltas[t.Key] += (cash - sum) * t.Weight / 100
+			}
+		}
+		return deltas
+	}
+
+	invested := total - cash
+	need := -cash
+	for _, t := range targets {
+		weight := 0.0
+		if invested > 0 {
+			weight = values[t.Key] / total * 100
+		}
+		if math.Abs(weight-t.Weight) > t.Tolerance || (invested == 0 && t.Weight > 0) {
+			deltas[t.Key] = t.Weight/100*total - values[t.Key]
+			need += deltas[t.Key]
+		}
+	}
+
+	// need > 0: buys exceed sells plus cash, so trim in-band overweights.
+	// need < 0: leftover cash, so top up in-band underweights.
+	room := map[string]float64{}
+	roomSum := 0.0
+	for _, t := range targets {
+		if _, moved := deltas[t.Key]; moved {
+			continue
+		}
+		excess := values[t.Key] - t.Weight/100*total
+		if (need > 0 && excess > 0) || (need < 0 && excess < 0) {
+			room[t.Key] = math.Abs(excess)
+			roomSum += math.Abs(excess)
+		}
+	}
+	if roomSum > 0 {
+		share := math.Min(1, math.Abs(need)/roomSum)
+		for key, r := range room {
+			if need > 0 {
+				deltas[key] = -r * share
+			} else {
+				deltas[key] = r * share
+			}
+		}
+	}
+	return deltas
+}
+
+// tradesFor turns a group's value change into orders rounded to tradable
+// units. A buy goes entirely into the group's largest holding; a sell draws
+// on the largest holding and moves on to the next only once it is used up,
+// so each group trades as few instruments as possible. Buys round down so
+// they never exceed the cash planned.
+func tradesFor(group string, delta float64, holdings []rebalanceHolding, currencyOf map[string]string) []RebalanceTrade {
+	ordered := make([]rebalanceHolding, 0, len(holdings))
+	for _, h := range holdings {
+		if h.price*h.rate > 0 {
+			ordered = append(ordered, h)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].value > ordered[j].value })
+	if len(ordered) == 0 {
+		return nil
+	}
+	if delta > 0 {
+		ordered = ordered[:1]
+	}
+
+	var trades []RebalanceTrade
+	remaining := math.Abs(delta)
+	for _, h := range ordered {
+		if remaining <= 0 {
+			break
+		}
+		unitValue := h.price * h.rate
+		units := remaining / unitValue
+		if !h.fractional {
+			if delta > 0 {
+				units = math.Floor(units)
+			} else {
+				units = math.Round(units)
+			}
+		} else {
+			units = math.Floor(units*1000) / 1000
+		}
+		action := "buy"
+		if delta < 0 {
+			action = "sell"
+			units = math.Min(units, h.quantity)
+		}
+		if units <= 0 {
+			continue
+		}
+		remaining -= units * unitValue
+		trades = append(trades, RebalanceTrade{
+			Symbol: h.symbol, Group: group, Action: action, Units: units,
+			Price: h.price, Amount: units * unitValue, Currency: currencyOf[h.symbol],
+		})
+	}
+	return trades
+}
+
+// --- ROUTES ---
+
+func registerRebalanceRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/targets - The user's target allocation
+	api.GET("/targets", func(c *gin.Context) {
+		ts, err := loadTargetSet(context.Background(), dbPool, currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		c.JSON(http.StatusOK, ts)
+	})
+
+	// PUT /api/targets - Replace the target allocation
+	api.PUT("/targets", func(c *gin.Context) {
+		var ts TargetSet
+		if err := c.ShouldBindJSON(&ts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateTargetSet(&ts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userID := currentUserID(c)
+
+		ctx := context.Background()
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		if _, err := tx.Exec(ctx, "DELETE FROM allocation_targets WHERE user_id=$1", userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save targets"})
+			return
+		}
+		for _, t := range ts.Targets {
+			_, err := tx.Exec(ctx, "INSERT INTO allocation_targets (user_id, kind, key, weight, tolerance) VALUES ($1, $2, $3, $4, $5)",
+				userID, ts.Kind, t.Key, t.Weight, t.Tolerance)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save targets"})
+				return
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save targets"})
+			return
+		}
+		c.JSON(http.StatusOK, ts)
+	})
+
+	// GET /api/rebalance?mode=full|cash&cash=0&currency= - Drift against targets and trades to get back in band
+	api.GET("/rebalance", func(c *gin.Context) {
+		ctx := context.Background()
+		userID := currentUserID(c)
+
+		mode := c.DefaultQuery("mode", "full")
+		if mode != "full" && mode != "cash" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be full or cash"})
+			return
+		}
+		cash := 0.0
+		if v := c.Query("cash"); v != "" {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil || parsed < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cash must be a non-negative amount"})
+				return
+			}
+			cash = parsed
+		}
+
+		settings, err := loadUserSettings(ctx, dbPool, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		base := settings.BaseCurrency
+		if override := strings.ToUpper(c.Query("currency")); override != "" {
+			if !validCurrencyCode(override) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a three-letter currency code"})
+				return
+			}
+			base = override
+		}
+
+		ts, err := loadTargetSet(ctx, dbPool, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		if len(ts.Targets) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Set target weights with PUT /api/targets first"})
+			return
+		}
+		groupOf := map[string]string{}
+		for _, t := range ts.Targets {
+			groupOf[strings.ToLower(t.Key)] = t.Key
+		}
+
+		rows, err := dbPool.Query(ctx, `
+			SELECT name, COALESCE(asset_type, ''), COALESCE(tag, ''), quantity, current_price, currency
+			FROM assets WHERE user_id=$1 AND quantity > 0 ORDER BY name`, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		fx := newFXConverter(ctx, dbPool, base)
+		byGroup := map[string][]rebalanceHolding{}
+		values := map[string]float64{}
+		currencyOf := map[string]string{}
+		untargeted := []string{}
+		for rows.Next() {
+			var h rebalanceHolding
+			var assetType, tag, currency string
+			if err := rows.Scan(&h.symbol, &assetType, &tag, &h.quantity, &h.price, &currency); err != nil {
+				rows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			key := map[string]string{TargetAsset: h.symbol, TargetType: assetType, TargetTag: tag}[ts.Kind]
+			group, ok := groupOf[strings.ToLower(key)]
+			if !ok {
+				untargeted = append(untargeted, h.symbol)
+				continue
+			}
+			rate, ok := fx.now(normalizeCurrency(currency))
+			if !ok {
+				continue
+			}
+			h.group, h.rate = group, rate
+			h.value = h.quantity * h.price * rate
+			h.fractional = fractionalUnits(h.symbol, assetType)
+			currencyOf[h.symbol] = normalizeCurrency(currency)
+			byGroup[group] = append(byGroup[group], h)
+			values[group] += h.value
+		}
+		rows.Close()
+
+		deltas := planRebalance(ts.Targets, values, cash, mode == "cash")
+
+		trades := []RebalanceTrade{}
+		unfunded := []string{}
+		post := map[string]float64{}
+		cashLeft := cash
+		planned := 0.0
+		for _, t := range ts.Targets {
+			post[t.Key] = values[t.Key]
+			delta := deltas[t.Key]
+			if delta == 0 {
+				continue
+			}
+			if len(byGroup[t.Key]) == 0 {
+				// Nothing held in this group yet, so there is no instrument to buy
+				unfunded = append(unfunded, t.Key)
+				continue
+			}
+			if delta > 0 {
+				planned += delta
+				continue
+			}
+			for _, tr := range tradesFor(t.Key, delta, byGroup[t.Key], currencyOf) {
+				post[t.Key] -= tr.Amount
+				cashLeft += tr.Amount
+				trades = append(trades, tr)
+			}
+		}
+		// Buys are sized from what the rounded sells actually raise plus the
+		// cash on hand, so the plan never spends more than it has.
+		scale := 1.0
+		if planned > cashLeft {
+			scale = math.Max(cashLeft, 0) / planned
+		}
+		for _, t := range ts.Targets {
+			delta := deltas[t.Key]
+			if delta <= 0 || len(byGroup[t.Key]) == 0 {
+				continue
+			}
+			for _, tr := range tradesFor(t.Key, math.Min(delta*scale, cashLeft), byGroup[t.Key], currencyOf) {
+				post[t.Key] += tr.Amount
+				cashLeft -= tr.Amount
+				trades = append(trades, tr)
+			}
+		}
+		sort.SliceStable(trades, func(i, j int) bool { return trades[i].Action == "sell" && trades[j].Action == "buy" })
+
+		total, postTotal := cash, cashLeft
+		for _, t := range ts.Targets {
+			total += values[t.Key]
+			postTotal += post[t.Key]
+		}
+		drift := make([]GroupDrift, 0, len(ts.Targets))
+		for _, t := range ts.Targets {
+			g := GroupDrift{Key: t.Key, Target: t.Weight, Tolerance: t.Tolerance, Value: values[t.Key]}
+			if total > 0 {
+				g.CurrentWeight = values[t.Key] / total * 100
+			}
+			if postTotal > 0 {
+				g.PostWeight = post[t.Key] / postTotal * 100
+			}
+			g.Drift = g.CurrentWeight - t.Weight
+			g.InBand = math.Abs(g.Drift) <= t.Tolerance
+			drift = append(drift, g)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"baseCurrency":     base,
+			"mode":             mode,
+			"kind":             ts.Kind,
+			"cash":             cash,
+			"cashLeft":         cashLeft,
+			"groups":           drift,
+			"trades":           trades,
+			"unfundedGroups":   unfunded,
+			"untargetedAssets": untargeted,
+			"missingRates":     fx.missingRates(),
+		})
+	})
+}
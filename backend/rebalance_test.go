@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPlanRebalance(t *testing.T) {
+	twoWay := []AllocationTarget{{Key: "A", Weight: 60, Tolerance: 5}, {Key: "B", Weight: 40, Tolerance: 5}}
+	threeWay := []AllocationTarget{
+		{Key: "A", Weight: 50, Tolerance: 5},
+		{Key: "B", Weight: 30, Tolerance: 5},
+		{Key: "C", Weight: 20, Tolerance: 5},
+	}
+
+	tests := []struct {
+		name     string
+		targets  []AllocationTarget
+		values   map[string]float64
+		cash     float64
+		cashOnly bool
+		want     map[string]float64
+	}{
+		{
+			name:    "in band",
+			targets: twoWay,
+			values:  map[string]float64{"A": 62, "B": 38},
+			want:    map[string]float64{},
+		},
+		{
+			name:    "out of band both ways",
+			targets: twoWay,
+			values:  map[string]float64{"A": 70, "B": 30},
+			want:    map[string]float64{"A": -10, "B": 10},
+		},
+		{
+			name:    "new cash pulls both groups out of band",
+			targets: twoWay,
+			values:  map[string]float64{"A": 60, "B": 40},
+			cash:    20,
+			want:    map[string]float64{"A": 12, "B": 8},
+		},
+		{
+			name:    "in-band groups fund the shortfall",
+			targets: threeWay,
+			values:  map[string]float64{"A": 53, "B": 22, "C": 25},
+			want:    map[string]float64{"A": -3, "B": 8, "C": -5},
+		},
+		{
+			name:     "cash only tops up the underweight",
+			targets:  twoWay,
+			values:   map[string]float64{"A": 70, "B": 30},
+			cash:     10,
+			cashOnly: true,
+			want:     map[string]float64{"B": 10},
+		},
+		{
+			name:     "cash only beyond every shortfall",
+			targets:  twoWay,
+			values:   map[string]float64{"A": 60, "B": 40},
+			cash:     20,
+			cashOnly: true,
+			want:     map[string]float64{"A": 12, "B": 8},
+		},
+		{
+			name:    "nothing to rebalance",
+			targets: twoWay,
+			values:  map[string]float64{},
+			want:    map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planRebalance(tt.targets, tt.values, tt.cash, tt.cashOnly)
+			if len(got) != len(tt.want) {
+				t.Fatalf("planRebalance() = %v, want %v", got, tt.want)
+			}
+			for key, delta := range tt.want {
+				if math.Abs(got[key]-delta) > 1e-9 {
+					t.Errorf("delta[%s] = %g, want %g", key, got[key], delta)
+				}
+			}
+		})
+	}
+}
+
+func TestTradesFor(t *testing.T) {
+	stocks := []rebalanceHolding{
+		{symbol: "Y", quantity: 4, price: 100, rate: 1, value: 400},
+		{symbol: "X", quantity: 6, price: 100, rate: 1, value: 600},
+	}
+	currencyOf := map[string]string{"X": "USD", "Y": "USD", "F": "INR", "E": "EUR"}
+
+	type trade struct {
+		symbol, action string
+		units, amount  float64
+	}
+	tests := []struct {
+		name     string
+		delta    float64
+		holdings []rebalanceHolding
+		want     []trade
+	}{
+		{
+			name:     "buy goes into the largest holding, rounded down",
+			delta:    250,
+			holdings: stocks,
+			want:     []trade{{"X", "buy", 2, 200}},
+		},
+		{
+			name:     "sell rounds to the nearest unit",
+			delta:    -250,
+			holdings: stocks,
+			want:     []trade{{"X", "sell", 3, 300}},
+		},
+		{
+			name:     "sell spills over once a holding is used up",
+			delta:    -900,
+			holdings: stocks,
+			want:     []trade{{"X", "sell", 6, 600}, {"Y", "sell", 3, 300}},
+		},
+		{
+			name:     "fractional units",
+			delta:    100,
+			holdings: []rebalanceHolding{{symbol: "F", quantity: 10, price: 30, rate: 1, value: 300, fractional: true}},
+			want:     []trade{{"F", "buy", 3.333, 99.99}},
+		},
+		{
+			name:     "converted at the holding's rate",
+			delta:    50,
+			holdings: []rebalanceHolding{{symbol: "E", quantity: 1, price: 10, rate: 2, value: 20}},
+			want:     []trade{{"E", "buy", 2, 40}},
+		},
+		{
+			name:     "too small to trade",
+			delta:    50,
+			holdings: stocks,
+			want:     nil,
+		},
+		{
+			name:     "no price",
+			delta:    500,
+			holdings: []rebalanceHolding{{symbol: "X", quantity: 6, price: 0, rate: 1}},
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tradesFor("G", tt.delta, tt.holdings, currencyOf)
+			if len(got) != len(tt.want) {
+				t.Fatalf("tradesFor() = %+v, want %+v", got, tt.want)
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if g.Symbol != w.symbol || g.Action != w.action || math.Abs(g.Units-w.units) > 1e-9 || math.Abs(g.Amount-w.amount) > 1e-6 {
+					t.Errorf("trade %d = %+v, want %+v", i, g, w)
+				}
+				if g.Group != "G" || g.Currency != currencyOf[g.Symbol] {
+					t.Errorf("trade %d group/currency = %s/%s", i, g.Group, g.Currency)
+				}
+			}
+		})
+	}
+}
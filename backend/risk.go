@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// defaultRiskFreeRates are annual rates per currency, roughly the yield on
+// short-term government paper. Override with RISK_FREE_RATES, e.g.
+// "INR=0.065,USD=0.045,SGD=0.03".
+const defaultRiskFreeRates = "INR=0.065,USD=0.045,SGD=0.03,EUR=0.03,GBP=0.045"
+
+// zScore95 is the one-sided 95% quantile of the standard normal distribution.
+const zScore95 = 1.6449
+
+func parseRiskFreeRates(cfg string) map[string]float64 {
+	rates := map[string]float64{}
+	for _, part := range strings.Split(cfg, ",") {
+		cur, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		if r, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+			rates[strings.ToUpper(strings.TrimSpace(cur))] = r
+		}
+	}
+	return rates
+}
+
+var riskFreeRates = func() map[string]float64 {
+	rates := parseRiskFreeRates(defaultRiskFreeRates)
+	for cur, r := range parseRiskFreeRates(os.Getenv("RISK_FREE_RATES")) {
+		rates[cur] = r
+	}
+	return rates
+}()
+
+// RiskMetrics describe the daily return distribution of one series. VaR is a
+// one-day 95% loss expressed as a positive fraction of value.
+type RiskMetrics struct {
+	Name             string  `json:"name"`
+	Currency         string  `json:"currency"`
+	Value            float64 `json:"value"`
+	Observations     int     `json:"observations"`
+	AnnualizedReturn float64 `json:"annualizedReturn"`
+	Volatility       float64 `json:"volatility"`
+	RiskFreeRate     float64 `json:"riskFreeRate"`
+	Sharpe           float64 `json:"sharpe"`
+	Sortino          float64 `json:"sortino"`
+	MaxDrawdown      float64 `json:"maxDrawdown"`
+	PeakDate         string  `json:"peakDate,omitempty"`
+	TroughDate       string  `json:"troughDate,omitempty"`
+	HistoricalVaR95  float64 `json:"historicalVaR95"`
+	ParametricVaR95  float64 `json:"parametricVaR95"`
+	HistoricalVaRAmt float64 `json:"historicalVaRAmount"`
+	ParametricVaRAmt float64 `json:"parametricVaRAmount"`
+}
+
+// computeRisk derives risk metrics from a chronological level series (prices
+// or a growth index). rf is the annual risk-free rate.
+func computeRisk(name, currency string, levels []PricePoint, rf, value float64) RiskMetrics {
+	m := RiskMetrics{Name: name, Currency: currency, Value: value, RiskFreeRate: rf}
+	closes := make([]float64, len(levels))
+	for i, p := range levels {
+		closes[i] = p.Close
+	}
+	returns := periodReturns(closes)
+	m.Observations = len(returns)
+	if len(returns) < 2 {
+		return m
+	}
+
+	m.AnnualizedReturn = mean(returns) * tradingDaysPerYear
+	m.Volatility = stdDev(returns) * math.Sqrt(tradingDaysPerYear)
+	if m.Volatility > 0 {
+		m.Sharpe = (m.AnnualizedReturn - rf) / m.Volatility
+	}
+
+	dailyRF := rf / tradingDaysPerYear
+	downside := 0.0
+	for _, r := range returns {
+		if d := r - dailyRF; d < 0 {
+			downside += d * d
+		}
+	}
+	if dd := math.Sqrt(downside/float64(len(returns))) * math.Sqrt(tradingDaysPerYear); dd > 0 {
+		m.Sortino = (m.AnnualizedReturn - rf) / dd
+	}
+
+	peak, peakAt := levels[0].Close, levels[0].Time
+	for _, p := range levels {
+		if p.Close > peak {
+			peak, peakAt = p.Close, p.Time
+		}
+		if peak > 0 {
+			if dd := p.Close/peak - 1; dd < m.MaxDrawdown {
+				m.MaxDrawdown = dd
+				m.PeakDate = peakAt.Format(dateLayout)
+				m.TroughDate = p.Time.Format(dateLayout)
+			}
+		}
+	}
+
+	sorted := append([]float64(nil), returns...)
+	sort.Float64s(sorted)
+	m.HistoricalVaR95 = math.Max(0, -sorted[int(math.Floor(0.05*float64(len(sorted)-1)))])
+	m.ParametricVaR95 = math.Max(0, -(mean(returns) - zScore95*stdDev(returns)))
+	m.HistoricalVaRAmt = m.HistoricalVaR95 * value
+	m.ParametricVaRAmt = m.ParametricVaR95 * value
+	return m
+}
+
+// --- ROUTES ---
+
+func registerRiskRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/analytics/risk?range=1Y&currency=&riskFree= - Volatility, Sharpe, Sortino, drawdown and VaR
+	api.GET("/analytics/risk", func(c *gin.Context) {
+		ctx := context.Background()
+		req, ok := loadAnalyticsRequest(c, dbPool, "1Y")
+		if !ok {
+			return
+		}
+		if len(req.in.txns) == 0 {
+			c.JSON(http.StatusOK, gin.H{"baseCurrency": req.base, "portfolio": nil, "assets": []RiskMetrics{}})
+			return
+		}
+
+		// An explicit riskFree applies to the portfolio and every asset
+		rfFor := func(currency string) float64 { return riskFreeRates[currency] }
+		if v := c.Query("riskFree"); v != "" {
+			rf, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "riskFree must be an annual rate such as 0.05"})
+				return
+			}
+			rfFor = func(string) float64 { return rf }
+		}
+
+		rows, err := dbPool.Query(ctx,
+			"SELECT name, quantity, current_price, currency FROM assets WHERE user_id=$1 AND quantity > 0 ORDER BY name", currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		type held struct {
+			symbol, currency string
+			value            float64
+		}
+		var holdings []held
+		for rows.Next() {
+			var h held
+			var qty, price float64
+			if err := rows.Scan(&h.symbol, &qty, &price, &h.currency); err != nil {
+				rows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			h.value = qty * price
+			holdings = append(holdings, h)
+		}
+		rows.Close()
+
+		assets := []RiskMetrics{}
+		for _, h := range holdings {
+			series, err := loadPriceHistory(ctx, dbPool, h.symbol, req.start.AddDate(0, 0, -1))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load price history"})
+				return
+			}
+			assets = append(assets, computeRisk(h.symbol, h.currency, series.Points, rfFor(h.currency), h.value))
+		}
+
+		opening, points := valuationSeries(req.in.txns, req.in.prices, req.in.currencyOf, req.fx.rate, req.start, req.today)
+		index, _ := twrIndex(opening, points)
+		// Days before the first position would only add flat returns
+		first := 0
+		for first < len(points) && points[first].Value == 0 {
+			first++
+		}
+		total := 0.0
+		if len(points) > 0 {
+			total = points[len(points)-1].Value
+		}
+		portfolio := computeRisk("Portfolio", req.base, index[first:], rfFor(req.base), total)
+
+		c.JSON(http.StatusOK, gin.H{
+			"baseCurrency": req.base,
+			"range":        req.rng,
+			"from":         req.start.Format(dateLayout),
+			"to":           req.today.Format(dateLayout),
+			"portfolio":    portfolio,
+			"assets":       assets,
+			"missingRates": req.fx.missing,
+		})
+	})
+}
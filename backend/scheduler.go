@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	schedulerTick = time.Minute
+	jobLease      = 10 * time.Minute
+)
+
+// scheduledJob is a named background task. next computes when it should run
+// again after finishing at the given time.
+type scheduledJob struct {
+	name string
+	run  func(ctx context.Context, dbPool *pgxpool.Pool) (any, error)
+	next func(now time.Time) time.Time
+}
+
+// nextDailyAt returns the next occurrence of hh:mm in the zone after now.
+func nextDailyAt(now time.Time, zone string, hh, mm int) time.Time {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hh, mm, 0, 0, loc)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+var scheduledJobs = []scheduledJob{
+	{
+		// Quotes for symbols whose market is open: every 15 minutes while any
+		// regular session is trading, otherwise every two hours for 24-hour
+		// venues such as crypto. Closing prices come from price-refresh-eod.
+		name: "price-refresh",
+		run: func(ctx context.Context, dbPool *pgxpool.Pool) (any, error) {
+			now := time.Now()
+			return runPriceUpdate(ctx, dbPool, func(s string) bool {
+				return symbolScheme(s) != "AMFI" && symbolMarketOpen(s, now)
+			})
+		},
+		next: func(now time.Time) time.Time {
+			if anyMarketOpen(now) {
+				return now.Add(15 * time.Minute)
+			}
+			return now.Add(2 * time.Hour)
+		},
+	},
+	{
+		// End-of-day pass for everything, after the last market (New York) closes
+		name: "price-refresh-eod",
+		run: func(ctx context.Context, dbPool *pgxpool.Pool) (any, error) {
+			return runPriceUpdate(ctx, dbPool, func(s string) bool { return symbolScheme(s) != "AMFI" })
+		},
+		next: func(now time.Time) time.Time { return nextDailyAt(now, "America/New_York", 16, 30) },
+	},
+	{
+		// AMFI publishes NAVs once a day, late evening IST
+		name: "amfi-nav",
+		run: func(ctx context.Context, dbPool *pgxpool.Pool) (any, error) {
+			return runPriceUpdate(ctx, dbPool, func(s string) bool { return symbolScheme(s) == "AMFI" })
+		},
+		next: func(now time.Time) time.Time { return nextDailyAt(now, "Asia/Kolkata", 23, 30) },
+	},
+	{
+		// AMFI scheme master (codes and ISINs) for statement imports, weekly
+		name: "amfi-scheme-master",
+		run: func(ctx context.Context, dbPool *pgxpool.Pool) (any, error) {
+			n, err := refreshSchemeMaster(ctx, dbPool)
+			return map[string]int{"schemes": n}, err
+		},
+		next: func(now time.Time) time.Time { return nextDailyAt(now, "Asia/Kolkata", 6, 0).AddDate(0, 0, 6) },
+	},
+	{
+		// Daily FX closes for every held currency, once the New York session ends
+		name: "fx-rates",
+		run: func(ctx context.Context, dbPool *pgxpool.Pool) (any, error) {
+			return nil, syncFXRates(ctx, dbPool, 30)
+		},
+		next: func(now time.Time) time.Time { return nextDailyAt(now, "America/New_York", 17, 15) },
+	},
+	{
+		// Record each user's end-of-day total once closing prices and FX are in
+		name: "portfolio-snapshots",
+		run: func(ctx context.Context, dbPool *pgxpool.Pool) (any, error) {
+			return nil, snapshotAllUsers(ctx, dbPool)
+		},
+		next: func(now time.Time) time.Time { return nextDailyAt(now, "America/New_York", 17, 45) },
+	},
+	{
+		// Sector, category and market-cap profiles; only stale rows are re-fetched
+		name: "instrument-metadata",
+		run: func(ctx context.Context, dbPool *pgxpool.Pool) (any, error) {
+			return nil, refreshAllInstrumentProfiles(ctx, dbPool)
+		},
+		next: func(now time.Time) time.Time { return nextDailyAt(now, "UTC", 3, 0) },
+	},
+	{
+		// Splits and other corporate actions, applied before dividends so
+		// entitlements are counted on the adjusted share count
+		name: "corporate-actions",
+		run: func(ctx context.Context, dbPool *pgxpool.Pool) (any, error) {
+			return syncCorporateActions(ctx, dbPool)
+		},
+		next: func(now time.Time) time.Time { return nextDailyAt(now, "UTC", 3, 30) },
+	},
+	{
+		// Dividend events from providers, booked into each holder's ledger
+		name: "dividends",
+		run: func(ctx context.Context, dbPool *pgxpool.Pool) (any, error) {
+			return syncDividends(ctx, dbPool)
+		},
+		next: func(now time.Time) time.Time { return nextDailyAt(now, "UTC", 4, 0) },
+	},
+}
+
+// claimJob takes a lease on a due job. The conditional UPDATE is atomic, so
+// when several instances poll at once only one of them gets the row back.
+func claimJob(ctx context.Context, dbPool *pgxpool.Pool, name, owner string) (bool, error) {
+	tag, err := dbPool.Exec(ctx, `
+		UPDATE scheduled_jobs SET locked_by=$2, locked_until=NOW() + $3::interval
+		WHERE name=$1 AND next_run_at <= NOW() AND (locked_until IS NULL OR locked_until < NOW())`,
+		name, owner, fmt.Sprintf("%d seconds", int(jobLease.Seconds())))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func finishJob(ctx context.Context, dbPool *pgxpool.Pool, job scheduledJob, result any, runErr error) {
+	status, errText := "ok", ""
+	if runErr != nil {
+		status, errText = "failed", runErr.Error()
+	}
+	report, _ := json.Marshal(result)
+
+	_, err := dbPool.Exec(ctx, `
+		UPDATE scheduled_jobs SET last_run_at=NOW(), next_run_at=$2, locked_by=NULL, locked_until=NULL,
+			last_status=$3, last_error=$4, last_report=$5
+		WHERE name=$1`,
+		job.name, job.next(time.Now()), status, errText, report)
+	if err != nil {
+		log.Printf("Scheduler: could not record %s result: %v", job.name, err)
+	}
+}
+
+// startScheduler polls scheduled_jobs and runs any job this instance claims.
+func startScheduler(dbPool *pgxpool.Pool) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s-%d", host, os.Getpid())
+
+	ctx := context.Background()
+	for _, job := range scheduledJobs {
+		dbPool.Exec(ctx, "INSERT INTO scheduled_jobs (name, next_run_at) VALUES ($1, NOW()) ON CONFLICT (name) DO NOTHING", job.name)
+	}
+
+	go func() {
+		ticker := time.NewTicker(schedulerTick)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, job := range scheduledJobs {
+				claimed, err := claimJob(ctx, dbPool, job.name, owner)
+				if err != nil {
+					log.Printf("Scheduler: claim %s failed: %v", job.name, err)
+					continue
+				}
+				if !claimed {
+					continue
+				}
+
+				runCtx, cancel := context.WithTimeout(ctx, jobLease)
+				result, err := job.run(runCtx, dbPool)
+				cancel()
+				if err != nil {
+					log.Printf("Scheduler: %s failed: %v", job.name, err)
+				}
+				finishJob(ctx, dbPool, job, result, err)
+			}
+		}
+	}()
+}
+
+// JobStatus is the persisted state of a scheduled job.
+type JobStatus struct {
+	Name       string          `json:"name"`
+	LastRunAt  *time.Time      `json:"lastRunAt"`
+	NextRunAt  time.Time       `json:"nextRunAt"`
+	LockedBy   *string         `json:"lockedBy"`
+	LastStatus *string         `json:"lastStatus"`
+	LastError  *string         `json:"lastError"`
+	LastReport json.RawMessage `json:"lastReport"`
+}
+
+func loadJobStatuses(ctx context.Context, q dbQuerier) ([]JobStatus, error) {
+	rows, err := q.Query(ctx, `
+		SELECT name, last_run_at, next_run_at, locked_by, last_status, last_error, COALESCE(last_report, 'null'::jsonb)
+		FROM scheduled_jobs ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []JobStatus{}
+	for rows.Next() {
+		var j JobStatus
+		if err := rows.Scan(&j.Name, &j.LastRunAt, &j.NextRunAt, &j.LockedBy, &j.LastStatus, &j.LastError, &j.LastReport); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UserSettings holds per-user preferences. Missing rows fall back to defaults.
+type UserSettings struct {
+	LotMethod    string   `json:"lotMethod"`
+	BaseCurrency string   `json:"baseCurrency"`
+	Benchmarks   []string `json:"benchmarks"`
+	// Percent withheld from imported dividends
+	DividendWithholding float64 `json:"dividendWithholding"`
+}
+
+func defaultUserSettings() UserSettings {
+	return UserSettings{LotMethod: LotFIFO, BaseCurrency: fxPivot, Benchmarks: []string{}}
+}
+
+// validCurrencyCode accepts ISO 4217-style three-letter codes.
+func validCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func loadUserSettings(ctx context.Context, q dbQuerier, userID int) (UserSettings, error) {
+	s := defaultUserSettings()
+	err := q.QueryRow(ctx, "SELECT lot_method, base_currency, benchmarks, dividend_withholding FROM user_settings WHERE user_id=$1", userID).Scan(&s.LotMethod, &s.BaseCurrency, &s.Benchmarks, &s.DividendWithholding)
+	if err == pgx.ErrNoRows {
+		return defaultUserSettings(), nil
+	}
+	return s, err
+}
+
+func registerSettingsRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/settings - Fetch the user's preferences
+	api.GET("/settings", func(c *gin.Context) {
+		s, err := loadUserSettings(context.Background(), dbPool, currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		c.JSON(http.StatusOK, s)
+	})
+
+	// PUT /api/settings - Update the user's preferences
+	api.PUT("/settings", func(c *gin.Context) {
+		ctx := context.Background()
+		userID := currentUserID(c)
+
+		// Start from the stored values so partial updates keep other fields
+		s, err := loadUserSettings(ctx, dbPool, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Query failed"})
+			return
+		}
+		if err := c.ShouldBindJSON(&s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		s.LotMethod = strings.ToLower(s.LotMethod)
+		if !validLotMethod(s.LotMethod) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lotMethod must be one of fifo, lifo, hifo, specific"})
+			return
+		}
+		s.BaseCurrency = strings.ToUpper(strings.TrimSpace(s.BaseCurrency))
+		if !validCurrencyCode(s.BaseCurrency) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "baseCurrency must be a three-letter currency code"})
+			return
+		}
+		benchmarks, err := normalizeBenchmarks(s.Benchmarks)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkBenchmarks(ctx, dbPool, benchmarks); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		s.Benchmarks = benchmarks
+		if s.DividendWithholding < 0 || s.DividendWithholding >= 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dividendWithholding must be a percentage from 0 to under 100"})
+			return
+		}
+
+		_, err = dbPool.Exec(ctx,
+			`INSERT INTO user_settings (user_id, lot_method, base_currency, benchmarks, dividend_withholding) VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (user_id) DO UPDATE SET lot_method=EXCLUDED.lot_method, base_currency=EXCLUDED.base_currency,
+			 	benchmarks=EXCLUDED.benchmarks, dividend_withholding=EXCLUDED.dividend_withholding`,
+			userID, s.LotMethod, s.BaseCurrency, s.Benchmarks, s.DividendWithholding)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save settings"})
+			return
+		}
+		c.JSON(http.StatusOK, s)
+	})
+}
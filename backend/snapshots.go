@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AssetSnapshot is one holding's end-of-day position in the reporting currency.
+type AssetSnapshot struct {
+	Symbol   string  `json:"symbol"`
+	Quantity float64 `json:"quantity"`
+	Value    float64 `json:"value"`
+	Cost     float64 `json:"cost"`
+}
+
+// PortfolioSnapshot is a user's end-of-day total, stored in portfolio_snapshots.
+type PortfolioSnapshot struct {
+	Date       time.Time       `json:"date"`
+	Currency   string          `json:"currency"`
+	TotalValue float64         `json:"totalValue"`
+	TotalCost  float64         `json:"totalCost"`
+	Holdings   []AssetSnapshot `json:"holdings"`
+}
+
+// buildSnapshots replays the ledger and values it at the end of every day in
+// [start, end] that has a price or a trade. Cost is the open lots' basis under
+// the user's lot method, each converted at its trade-date rate, matching the
+// portfolio summary.
+func buildSnapshots(in portfolioInputs, fx *fxTable, method string, selections map[int][]LotSelection, start, end time.Time) ([]PortfolioSnapshot, error) {
+	days := map[time.Time]bool{end: true}
+	for _, points := range in.prices {
+		for _, p := range points {
+			if !p.Time.Before(start) && !p.Time.After(end) {
+				days[p.Time] = true
+			}
+		}
+	}
+	for _, t := range in.txns {
+		if d, err := time.Parse(dateLayout, t.Date); err == nil && !d.Before(start) && !d.After(end) {
+			days[d] = true
+		}
+	}
+	grid := make([]time.Time, 0, len(days))
+	for d := range days {
+		grid = append(grid, d)
+	}
+	sort.Slice(grid, func(i, j int) bool { return grid[i].Before(grid[j]) })
+
+	ledger := map[string][]Transaction{}
+	qty, cost := map[string]float64{}, map[string]float64{}
+
+	next := 0
+	var snapshots []PortfolioSnapshot
+	for _, d := range grid {
+		day := d.Format(dateLayout)
+		changed := map[string]bool{}
+		for next < len(in.txns) && in.txns[next].Date <= day {
+			t := in.txns[next]
+			ledger[t.Symbol] = append(ledger[t.Symbol], t)
+			changed[t.Symbol] = true
+			next++
+		}
+		for symbol := range changed {
+			open, _, err := matchLots(ledger[symbol], method, selections)
+			if err != nil {
+				return nil, err
+			}
+			qty[symbol], cost[symbol] = 0, 0
+			for _, l := range open {
+				opened, _ := time.Parse(dateLayout, l.OpenDate)
+				qty[symbol] += l.Quantity
+				cost[symbol] += l.CostBasis * fx.rate(in.currencyOf[symbol], opened)
+			}
+		}
+
+		snap := PortfolioSnapshot{Date: d, Currency: fx.to, Holdings: []AssetSnapshot{}}
+		for symbol, q := range qty {
+			if q <= 1e-9 {
+				continue
+			}
+			a := AssetSnapshot{Symbol: symbol, Quantity: q, Cost: cost[symbol]}
+			if p, ok := closeOn(in.prices[symbol], d); ok {
+				a.Value = q * p * fx.rate(in.currencyOf[symbol], d)
+			}
+			snap.TotalValue += a.Value
+			snap.TotalCost += a.Cost
+			snap.Holdings = append(snap.Holdings, a)
+		}
+		sort.Slice(snap.Holdings, func(i, j int) bool { return snap.Holdings[i].Symbol < snap.Holdings[j].Symbol })
+		snapshots = append(snapshots, snap)
+	}
+	return snapshots, nil
+}
+
+func storeSnapshots(ctx context.Context, q dbQuerier, userID int, snapshots []PortfolioSnapshot) error {
+	for _, s := range snapshots {
+		holdings, err := json.Marshal(s.Holdings)
+		if err != nil {
+			return err
+		}
+		_, err = q.Exec(ctx, `
+			INSERT INTO portfolio_snapshots (user_id, date, currency, total_value, total_cost, holdings) VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (user_id, date) DO UPDATE SET currency=EXCLUDED.currency, total_value=EXCLUDED.total_value,
+				total_cost=EXCLUDED.total_cost, holdings=EXCLUDED.holdings`,
+			userID, s.Date, s.Currency, s.TotalValue, s.TotalCost, holdings)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateSnapshots drops a user's snapshots from date onward (all of them
+// when date is empty) after a ledger change; the next read rebuilds them.
+func invalidateSnapshots(ctx context.Context, q dbQuerier, userID int, date string) error {
+	if date == "" {
+		_, err := q.Exec(ctx, "DELETE FROM portfolio_snapshots WHERE user_id=$1", userID)
+		return err
+	}
+	_, err := q.Exec(ctx, "DELETE FROM portfolio_snapshots WHERE user_id=$1 AND date >= $2", userID, date)
+	return err
+}
+
+// invalidateSymbolSnapshots drops snapshots from date onward for every user
+// whose valuation depends on symbol, after closes from that date were stored
+// late (a backfill or a gap filled). For an FX symbol that is every user
+// trading in or reporting in the currency.
+func invalidateSymbolSnapshots(ctx context.Context, q dbQuerier, symbol string, date time.Time) error {
+	if isFXSymbol(symbol) {
+		currency := strings.TrimSuffix(symbol, "=X")
+		_, err := q.Exec(ctx, `
+			DELETE FROM portfolio_snapshots WHERE date >= $2 AND user_id IN (
+				SELECT user_id FROM transactions WHERE currency=$1
+				UNION SELECT user_id FROM user_settings WHERE base_currency=$1)`, currency, date)
+		return err
+	}
+	_, err := q.Exec(ctx,
+		"DELETE FROM portfolio_snapshots WHERE date >= $2 AND user_id IN (SELECT user_id FROM transactions WHERE symbol=$1)",
+		symbol, date)
+	return err
+}
+
+// ensureSnapshots brings a user's snapshots up to today, reconstructing from
+// the last stored day (or from inception if none are stored, or if they were
+// recorded in a different base currency). With fetch set, missing price
+// history is topped up from upstream first; otherwise only stored closes are
+// used, which is what request handlers want.
+func ensureSnapshots(ctx context.Context, dbPool *pgxpool.Pool, userID int, fetch bool) error {
+	settings, err := loadUserSettings(ctx, dbPool, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := dbPool.Exec(ctx, "DELETE FROM portfolio_snapshots WHERE user_id=$1 AND currency <> $2", userID, settings.BaseCurrency); err != nil {
+		return err
+	}
+
+	in, err := loadPortfolioInputs(ctx, dbPool, userID, fetch)
+	if err != nil || len(in.txns) == 0 {
+		return err
+	}
+
+	start, _ := time.Parse(dateLayout, in.txns[0].Date)
+	var last *time.Time
+	if err := dbPool.QueryRow(ctx, "SELECT MAX(date) FROM portfolio_snapshots WHERE user_id=$1", userID).Scan(&last); err != nil {
+		return err
+	}
+	if last != nil && last.After(start) {
+		start = *last
+	}
+
+	currencies := []string{}
+	for _, cur := range in.currencyOf {
+		currencies = append(currencies, cur)
+	}
+	fx, err := loadFXTable(ctx, dbPool, currencies, settings.BaseCurrency)
+	if err != nil {
+		return err
+	}
+
+	selections, err := loadLotSelections(ctx, dbPool, userID)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	snapshots, err := buildSnapshots(in, fx, settings.LotMethod, selections, start, today)
+	if err != nil {
+		return err
+	}
+	return storeSnapshots(ctx, dbPool, userID, snapshots)
+}
+
+// snapshotAllUsers records today's snapshot (and fills any gaps) for every
+// user with a ledger. It backs both the end-of-day job and the backfill command.
+func snapshotAllUsers(ctx context.Context, dbPool *pgxpool.Pool) error {
+	rows, err := dbPool.Query(ctx, "SELECT DISTINCT user_id FROM transactions")
+	if err != nil {
+		return err
+	}
+	var users []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, id := range users {
+		if err := ensureSnapshots(ctx, dbPool, id, true); err != nil {
+			failed++
+			log.Printf("Snapshot for user %d failed: %v", id, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d users failed", failed, len(users))
+	}
+	return nil
+}
+
+// --- ROUTES ---
+
+func registerSnapshotRoutes(api *gin.RouterGroup, dbPool *pgxpool.Pool) {
+	// GET /api/portfolio/history?range=1y - Daily total value in the user's base currency, as a chart
+	api.GET("/portfolio/history", func(c *gin.Context) {
+		ctx := context.Background()
+		userID := currentUserID(c)
+		rng := c.DefaultQuery("range", "1y")
+
+		// The end-of-day job keeps snapshots current; rebuild here only what a
+		// ledger edit or backfill invalidated, and from stored closes only
+		var last *time.Time
+		if err := dbPool.QueryRow(ctx, "SELECT MAX(date) FROM portfolio_snapshots WHERE user_id=$1", userID).Scan(&last); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load portfolio history"})
+			return
+		}
+		yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+		if last == nil || last.Before(yesterday) {
+			if err := ensureSnapshots(ctx, dbPool, userID, false); err != nil {
+				log.Printf("Snapshots for user %d: %v", userID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build portfolio history"})
+				return
+			}
+		}
+
+		from := historyEpoch
+		if days := rangeDays(rng); days != 0 {
+			from = time.Now().UTC().AddDate(0, 0, -days)
+		}
+		rows, err := dbPool.Query(ctx,
+			"SELECT date, total_value, currency FROM portfolio_snapshots WHERE user_id=$1 AND date > $2 ORDER BY date",
+			userID, from)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load portfolio history"})
+			return
+		}
+		defer rows.Close()
+
+		series := PriceSeries{Symbol: "PORTFOLIO"}
+		for rows.Next() {
+			var p PricePoint
+			if err := rows.Scan(&p.Time, &p.Close, &series.Currency); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load portfolio history"})
+				return
+			}
+			series.Points = append(series.Points, p)
+		}
+
+		if n := len(series.Points); n > 0 {
+			series.Quote = Quote{Symbol: series.Symbol, Price: series.Points[n-1].Close, PreviousClose: series.Points[0].Close, Currency: series.Currency, Source: "snapshots"}
+		}
+		if len(series.Points) > maxChartPoints {
+			series.Points = thinToMonthEnds(series.Points)
+		}
+		c.JSON(http.StatusOK, chartResponse(series))
+	})
+}
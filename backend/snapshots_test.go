@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBuildSnapshotsCost(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(dateLayout, s)
+		return d
+	}
+	in := portfolioInputs{
+		txns: []Transaction{
+			{ID: 1, Symbol: "AAPL", Type: TxnBuy, Date: "2023-01-02", Quantity: 10, Price: 100},
+			{ID: 2, Symbol: "AAPL", Type: TxnBuy, Date: "2023-01-03", Quantity: 10, Price: 200},
+			{ID: 3, Symbol: "AAPL", Type: TxnSell, Date: "2023-01-04", Quantity: 10, Price: 150},
+		},
+		prices:     map[string][]PricePoint{"AAPL": {{Time: day("2023-01-04"), Close: 150}}},
+		currencyOf: map[string]string{"AAPL": "USD"},
+	}
+	fx := &fxTable{to: "USD"}
+
+	tests := []struct {
+		method     string
+		selections map[int][]LotSelection
+		want       float64
+	}{
+		{method: LotFIFO, want: 2000},
+		{method: LotLIFO, want: 1000},
+		{method: LotHIFO, want: 1000},
+		{method: LotSpecific, selections: map[int][]LotSelection{3: {{LotID: 2, Quantity: 10}}}, want: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			snapshots, err := buildSnapshots(in, fx, tt.method, tt.selections, day("2023-01-02"), day("2023-01-05"))
+			if err != nil {
+				t.Fatalf("buildSnapshots() error = %v", err)
+			}
+			last := snapshots[len(snapshots)-1]
+			if math.Abs(last.TotalCost-tt.want) > 1e-9 || math.Abs(last.TotalValue-1500) > 1e-9 {
+				t.Errorf("last snapshot cost %g value %g, want cost %g value 1500", last.TotalCost, last.TotalValue, tt.want)
+			}
+		})
+	}
+
+	in.txns = append(in.txns, Transaction{ID: 4, Symbol: "AAPL", Type: TxnSell, Date: "2023-01-05", Quantity: 20, Price: 150})
+	if _, err := buildSnapshots(in, fx, LotFIFO, nil, day("2023-01-02"), day("2023-01-05")); err == nil {
+		t.Error("buildSnapshots() with an oversold ledger should fail")
+	}
+}
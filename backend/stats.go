@@ -0,0 +1,45 @@
+package main
+
+import "math"
+
+// tradingDaysPerYear annualises daily statistics.
+const tradingDaysPerYear = 252
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// covariance is the sample covariance of two equally long series.
+func covariance(xs, ys []float64) float64 {
+	if len(xs) < 2 || len(xs) != len(ys) {
+		return 0
+	}
+	mx, my := mean(xs), mean(ys)
+	sum := 0.0
+	for i := range xs {
+		sum += (xs[i] - mx) * (ys[i] - my)
+	}
+	return sum / float64(len(xs)-1)
+}
+
+func stdDev(xs []float64) float64 {
+	return math.Sqrt(covariance(xs, xs))
+}
+
+// periodReturns turns a chronological level series into simple returns.
+func periodReturns(levels []float64) []float64 {
+	out := make([]float64, 0, len(levels))
+	for i := 1; i < len(levels); i++ {
+		if levels[i-1] > 0 {
+			out = append(out, levels[i]/levels[i-1]-1)
+		}
+	}
+	return out
+}